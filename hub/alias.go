@@ -0,0 +1,178 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+const aliasesFileName = ".aliases.json"
+
+var ErrAliasNotFound = errors.New("alias not found")
+
+// ModelAlias maps a short name such as "sdxl-base" to a repo, optionally
+// pinned to a revision.
+type ModelAlias struct {
+	Name      string    `json:"name"`
+	RepoId    string    `json:"repo_id"`
+	RepoType  string    `json:"repo_type"`
+	Revision  string    `json:"revision,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Repo returns the aliased repo, with its revision when pinned.
+func (a *ModelAlias) Repo() *Repo {
+	return NewRepo(a.RepoId).WithType(a.RepoType).WithRevision(a.Revision)
+}
+
+// Alias registers or replaces a short name for a repo in the cache directory.
+// An empty revision follows the default branch.
+func Alias(cacheDir string, name string, repo *Repo, revision string) error {
+	if name == "" {
+		return fmt.Errorf("alias name must not be empty")
+	}
+
+	repoType := repo.Type
+	if repoType == "" {
+		repoType = ModelRepoType
+	}
+	if revision == "" {
+		revision = repo.Revision
+	}
+
+	return updateAliases(cacheDir, func(aliases map[string]*ModelAlias) error {
+		aliases[name] = &ModelAlias{
+			Name:      name,
+			RepoId:    repo.Id,
+			RepoType:  repoType,
+			Revision:  revision,
+			CreatedAt: time.Now().UTC(),
+		}
+		return nil
+	})
+}
+
+// ResolveAlias looks up an alias registered with Alias.
+func ResolveAlias(cacheDir string, name string) (*ModelAlias, error) {
+	aliases, err := readAliases(filepath.Join(cacheDir, aliasesFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	alias, ok := aliases[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAliasNotFound, name)
+	}
+	return alias, nil
+}
+
+// ListAliases returns every alias sorted by name.
+func ListAliases(cacheDir string) ([]ModelAlias, error) {
+	aliases, err := readAliases(filepath.Join(cacheDir, aliasesFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]ModelAlias, 0, len(aliases))
+	for _, alias := range aliases {
+		list = append(list, *alias)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+func RenameAlias(cacheDir string, oldName string, newName string) error {
+	if newName == "" {
+		return fmt.Errorf("alias name must not be empty")
+	}
+
+	return updateAliases(cacheDir, func(aliases map[string]*ModelAlias) error {
+		alias, ok := aliases[oldName]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrAliasNotFound, oldName)
+		}
+		if _, taken := aliases[newName]; taken {
+			return fmt.Errorf("alias %s already exists", newName)
+		}
+
+		delete(aliases, oldName)
+		alias.Name = newName
+		aliases[newName] = alias
+		return nil
+	})
+}
+
+func RemoveAlias(cacheDir string, name string) error {
+	return updateAliases(cacheDir, func(aliases map[string]*ModelAlias) error {
+		if _, ok := aliases[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrAliasNotFound, name)
+		}
+		delete(aliases, name)
+		return nil
+	})
+}
+
+// updateAliases applies fn under a file lock, since the CLI and applications
+// may share one cache.
+func updateAliases(cacheDir string, fn func(map[string]*ModelAlias) error) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	path := filepath.Join(cacheDir, aliasesFileName)
+	fileLock := flock.New(path + ".lock")
+	if err := fileLock.Lock(); err != nil {
+		return fmt.Errorf("failed to lock aliases: %w", err)
+	}
+	defer fileLock.Unlock()
+
+	aliases, err := readAliases(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(aliases); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode aliases: %w", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write aliases: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move aliases into place: %w", err)
+	}
+
+	return nil
+}
+
+func readAliases(path string) (map[string]*ModelAlias, error) {
+	aliases := make(map[string]*ModelAlias)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return aliases, nil
+		}
+		return nil, fmt.Errorf("failed to read aliases: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse aliases: %w", err)
+	}
+
+	return aliases, nil
+}
@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// apiRequest sends a JSON request to the hub API and decodes the response
+// into out, when given. body, when given, is sent as JSON.
+func apiRequest(client *Client, method string, apiURL string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiURL, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = *getHeaders(client)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("API request failed: %w", newHTTPError(resp))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// apiPages requests a paginated list endpoint and calls visit for each item,
+// following the Link header's next page until visit returns false or the
+// pages run out.
+func apiPages[T any](client *Client, firstURL string, visit func(T) bool) error {
+	nextURL := firstURL
+	for nextURL != "" {
+		req, err := http.NewRequest("GET", nextURL, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header = *getHeaders(client)
+
+		resp, err := client.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("API request failed: %w", newHTTPError(resp))
+		}
+
+		var page []T
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		for _, item := range page {
+			if !visit(item) {
+				return nil
+			}
+		}
+
+		nextURL = ""
+		if matches := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); len(matches) > 1 {
+			nextURL = matches[1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package hub
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vbauerster/mpb/v7"
+)
+
+const archiveInfoFileName = ".archive.json"
+
+// CachedAssetsPath returns (and creates) a folder for derived assets, laid out
+// like huggingface_hub's cached_assets_path: <assetsDir>/<library>/<namespace>/<subfolder>.
+func CachedAssetsPath(assetsDir string, library string, namespace string, subfolder string) (string, error) {
+	if library == "" {
+		return "", fmt.Errorf("library name must not be empty")
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	if subfolder == "" {
+		subfolder = "default"
+	}
+
+	path := filepath.Join(assetsDir, library, namespace, subfolder)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", fmt.Errorf("failed to create assets folder: %w", err)
+	}
+
+	return path, nil
+}
+
+type ExtractOptions struct {
+	// AssetsDir is the root of the assets cache, see Config.AssetsDir.
+	AssetsDir string
+	Library   string
+	Namespace string
+	// KeepArchive keeps the downloaded archive next to the extracted files.
+	KeepArchive bool
+}
+
+type archiveInfo struct {
+	URL         string    `json:"url"`
+	SHA256      string    `json:"sha256"`
+	ExtractedAt time.Time `json:"extracted_at"`
+}
+
+// DownloadAndExtract downloads a .zip, .tar, .tar.gz or .tgz asset and
+// extracts it into the assets cache, in a folder named by the archive's
+// sha256 so the same archive is only unpacked once. Returns the folder.
+func DownloadAndExtract(source DownloadSource, opts ExtractOptions, progress *mpb.Progress) (string, error) {
+	info, err := source.GetFileInfo()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	fileName := filepath.Base(info.Filename)
+	if archiveFormat(fileName) == "" {
+		return "", fmt.Errorf("unsupported archive format: %s", fileName)
+	}
+
+	downloadsDir, err := CachedAssetsPath(opts.AssetsDir, opts.Library, opts.Namespace, ".downloads")
+	if err != nil {
+		return "", err
+	}
+
+	archivePath := filepath.Join(downloadsDir, fileName)
+	if err := source.Download(archivePath, progress); err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", fileName, err)
+	}
+
+	sum, err := sha256File(OSFS{}, archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", fileName, err)
+	}
+
+	extractDir := filepath.Join(opts.AssetsDir, opts.Library, namespaceOrDefault(opts.Namespace), sum)
+	if _, err := os.Stat(filepath.Join(extractDir, archiveInfoFileName)); err == nil {
+		log.Printf("[Download] %s is already extracted to %s", fileName, extractDir)
+		if !opts.KeepArchive {
+			os.Remove(archivePath)
+		}
+		return extractDir, nil
+	}
+
+	// extract next to the final folder and move it into place once complete
+	tmpDir := extractDir + ".incomplete"
+	os.RemoveAll(tmpDir)
+	if err := ExtractArchive(archivePath, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(archiveInfo{URL: info.URL, SHA256: sum, ExtractedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, archiveInfoFileName), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to record archive hash: %w", err)
+	}
+
+	os.RemoveAll(extractDir)
+	if err := os.Rename(tmpDir, extractDir); err != nil {
+		return "", fmt.Errorf("failed to move extracted files into place: %w", err)
+	}
+
+	if opts.KeepArchive {
+		if err := os.Rename(archivePath, filepath.Join(extractDir, fileName)); err != nil {
+			return "", fmt.Errorf("failed to keep archive: %w", err)
+		}
+	} else {
+		os.Remove(archivePath)
+	}
+
+	return extractDir, nil
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func archiveFormat(fileName string) string {
+	name := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// ExtractArchive unpacks a .zip, .tar, .tar.gz or .tgz file into destDir.
+// Entries escaping destDir and links are skipped.
+func ExtractArchive(archivePath string, destDir string) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", destDir, err)
+	}
+
+	switch archiveFormat(archivePath) {
+	case "zip":
+		return extractZip(archivePath, destDir)
+	case "tar.gz", "tar":
+		return extractTar(archivePath, destDir)
+	}
+	return fmt.Errorf("unsupported archive format: %s", filepath.Base(archivePath))
+}
+
+// archiveEntryPath resolves an entry name inside destDir, rejecting names
+// like "../../etc/passwd".
+func archiveEntryPath(destDir string, name string) (string, bool) {
+	path := filepath.Join(destDir, name)
+	if path != destDir && !strings.HasPrefix(path, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return "", false
+	}
+	return path, true
+}
+
+func extractZip(archivePath string, destDir string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		path, ok := archiveEntryPath(destDir, f.Name)
+		if !ok {
+			log.Printf("[Download] Skipping archive entry outside destination: %s", f.Name)
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			log.Printf("[Download] Skipping non-regular archive entry: %s", f.Name)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		err = writeArchiveFile(path, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractTar(archivePath string, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archiveFormat(archivePath) == "tar.gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		path, ok := archiveEntryPath(destDir, header.Name)
+		if !ok {
+			log.Printf("[Download] Skipping archive entry outside destination: %s", header.Name)
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(path, tr); err != nil {
+				return err
+			}
+		default:
+			log.Printf("[Download] Skipping non-regular archive entry: %s", header.Name)
+		}
+	}
+}
+
+func writeArchiveFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
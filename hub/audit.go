@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditAction is the kind of change made to the cache.
+type AuditAction string
+
+const (
+	// a file was fetched into the cache
+	AuditDownload AuditAction = "download"
+	// a revision was deleted with Client.DeleteRevision
+	AuditDelete AuditAction = "delete"
+	// a cached file was removed because it was corrupt, rejected by a hook
+	// or a broken link
+	AuditEvict AuditAction = "evict"
+)
+
+// AuditEvent records who changed what in the cache, and when. Bytes is what
+// the change added to or freed from the cache. Result is "ok", or the error
+// that failed the change, in which case the cache may hold part of it.
+type AuditEvent struct {
+	Time       time.Time   `json:"time"`
+	Action     AuditAction `json:"action"`
+	User       string      `json:"user"`
+	Host       string      `json:"host"`
+	RepoId     string      `json:"repo"`
+	RepoType   string      `json:"repo_type"`
+	Revision   string      `json:"revision,omitempty"`
+	CommitHash string      `json:"commit,omitempty"`
+	Files      []string    `json:"files,omitempty"`
+	Bytes      int64       `json:"bytes"`
+	Result     string      `json:"result"`
+	// why files were evicted
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditFunc receives every change a client makes to the cache. It runs on
+// the goroutine making the change, so slow sinks should hand events off.
+type AuditFunc func(event *AuditEvent)
+
+// AuditFile appends every event as a JSON line to the file at path, e.g. for
+// compliance records. Failed writes are logged, not returned, so they don't
+// fail the change.
+func AuditFile(path string) AuditFunc {
+	var mu sync.Mutex
+	return func(event *AuditEvent) {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		line = append(line, '\n')
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// single small appends don't interleave between processes
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("[Audit] Failed to open %s: %v", path, err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.Write(line); err != nil {
+			log.Printf("[Audit] Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+var auditIdentity = sync.OnceValues(func() (string, string) {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name, host
+})
+
+// audit fills in who and when and hands the event to client.Audit.
+func (client *Client) audit(event *AuditEvent, err error) {
+	if client.Audit == nil {
+		return
+	}
+
+	name, host := auditIdentity()
+	if client.AuditUser != "" {
+		name = client.AuditUser
+	}
+	event.Time = time.Now().UTC()
+	event.User = name
+	event.Host = host
+	event.Result = "ok"
+	if err != nil {
+		event.Result = err.Error()
+	}
+	client.Audit(event)
+}
+
+// auditEviction records the removal of a snapshot file, found from its path
+// under the cache, with the bytes of its blob when that went too.
+func (client *Client) auditEviction(pointerPath string, freed int64, reason string) {
+	if client.Audit == nil {
+		return
+	}
+
+	rel, err := filepath.Rel(client.CacheDir, pointerPath)
+	if err != nil {
+		return
+	}
+	// <repo folder>/snapshots/<commit>/<file>
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 4)
+	if len(parts) < 4 || parts[1] != "snapshots" {
+		return
+	}
+	repoId, repoType, ok := parseRepoFolderName(parts[0])
+	if !ok {
+		return
+	}
+
+	client.audit(&AuditEvent{
+		Action:     AuditEvict,
+		RepoId:     repoId,
+		RepoType:   repoType,
+		CommitHash: parts[2],
+		Files:      []string{parts[3]},
+		Bytes:      freed,
+		Reason:     reason,
+	}, nil)
+}
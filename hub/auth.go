@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Auth authenticates requests to one endpoint, e.g. a private mirror that
+// wants basic auth or an API-key header instead of the hub token.
+type Auth interface {
+	Apply(req *http.Request)
+}
+
+type BearerAuth struct {
+	Token string
+}
+
+func (a BearerAuth) Apply(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+}
+
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a BasicAuth) Apply(req *http.Request) {
+	req.SetBasicAuth(a.Username, a.Password)
+}
+
+// HeaderAuth sends a custom header, e.g. X-JFrog-Art-Api for Artifactory.
+type HeaderAuth struct {
+	Name  string
+	Value string
+}
+
+func (a HeaderAuth) Apply(req *http.Request) {
+	req.Header.Set(a.Name, a.Value)
+}
+
+// WithEndpointAuth returns a client that authenticates requests under the URL
+// prefix with auth instead of the hub token.
+func (client *Client) WithEndpointAuth(prefix string, auth Auth) *Client {
+	c := client.clone()
+	c.EndpointAuth = make(map[string]Auth, len(client.EndpointAuth)+1)
+	for p, a := range client.EndpointAuth {
+		c.EndpointAuth[p] = a
+	}
+	c.EndpointAuth[strings.TrimSuffix(prefix, "/")] = auth
+	return c
+}
+
+// authFor returns the auth of the longest EndpointAuth prefix matching url.
+func authFor(endpoints map[string]Auth, url string) Auth {
+	var (
+		best    Auth
+		bestLen = -1
+	)
+	for prefix, auth := range endpoints {
+		if len(prefix) <= bestLen || !strings.HasPrefix(url, prefix) {
+			continue
+		}
+		// match whole path segments only
+		if rest := url[len(prefix):]; rest != "" && rest[0] != '/' && rest[0] != '?' {
+			continue
+		}
+		best, bestLen = auth, len(prefix)
+	}
+	return best
+}
+
+// authTransport replaces the hub token with the configured scheme on requests
+// to endpoints in EndpointAuth, so the token never reaches a mirror.
+type authTransport struct {
+	base      http.RoundTripper
+	endpoints map[string]Auth
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth := authFor(t.endpoints, req.URL.String())
+	if auth == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	auth.Apply(req)
+	return t.base.RoundTrip(req)
+}
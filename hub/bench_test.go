@@ -0,0 +1,105 @@
+package hub_test
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/go-vault/model-cache/hub"
+	"github.com/go-vault/model-cache/hub/hubtest"
+)
+
+// the I/O knobs under test, e.g.
+// go test ./hub -run '^$' -bench . -args -bench.buffer 1048576 -bench.ranges 8
+var (
+	benchSize   = flag.Int64("bench.size", 256*1024*1024, "bytes of the single file and of all shards together; 256MB is fetched in ranges")
+	benchBuffer = flag.Int("bench.buffer", 0, "read buffer size in bytes, 0 tunes it from the measured speed")
+	benchFlush  = flag.Duration("bench.flush", 0, "sync partial downloads this often, 0 leaves it to the OS")
+	benchRanges = flag.Int("bench.ranges", 0, "concurrent range requests per source, 0 tunes it from the measured speed")
+)
+
+const (
+	benchShards        = 4
+	benchSnapshotFiles = 200
+)
+
+// benchContent returns size seeded random bytes, the same on every run.
+func benchContent(rng *rand.Rand, size int64) []byte {
+	b := make([]byte, size)
+	rng.Read(b)
+	return b
+}
+
+// benchDownload times downloads of params into a fresh cache each iteration.
+// With mirror, the server is also the client's mirror, so a large single file
+// goes through the multi-source range download.
+func benchDownload(b *testing.B, files map[string][]byte, params hub.DownloadParams, mirror bool) {
+	srv := hubtest.NewServer()
+	defer srv.Close()
+	srv.AddRepo(hub.ModelRepoType, params.Repo.Id, files)
+
+	var size int64
+	for _, content := range files {
+		size += int64(len(content))
+	}
+	b.SetBytes(size)
+
+	// the download logs would drown the results
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cacheDir, err := os.MkdirTemp("", "hub-bench-")
+		if err != nil {
+			b.Fatal(err)
+		}
+		client := srv.NewClient(cacheDir)
+		if mirror {
+			client.MirrorEndpoint = srv.URL
+		}
+		client.BufferSize = *benchBuffer
+		client.FlushInterval = *benchFlush
+		client.RangeWorkers = *benchRanges
+		paramsCopy := params
+		b.StartTimer()
+
+		_, err = client.Download(&paramsCopy)
+
+		b.StopTimer()
+		os.RemoveAll(cacheDir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSingleFile(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	files := map[string][]byte{"model.safetensors": benchContent(rng, *benchSize)}
+	benchDownload(b, files, hub.DownloadParams{Repo: hub.NewRepo("bench/single-file"), FileName: "model.safetensors"}, true)
+}
+
+func BenchmarkSharded(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	files := map[string][]byte{}
+	for i := 1; i <= benchShards; i++ {
+		files[fmt.Sprintf("model-%05d-of-%05d.safetensors", i, benchShards)] = benchContent(rng, *benchSize/benchShards)
+	}
+	benchDownload(b, files, hub.DownloadParams{Repo: hub.NewRepo("bench/sharded")}, false)
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	files := map[string][]byte{}
+	for i := 0; i < benchSnapshotFiles; i++ {
+		files[fmt.Sprintf("files/%04d.json", i)] = benchContent(rng, 16*1024)
+	}
+	benchDownload(b, files, hub.DownloadParams{Repo: hub.NewRepo("bench/snapshot")}, false)
+}
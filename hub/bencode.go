@@ -0,0 +1,109 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errBencode = errors.New("invalid bencode")
+
+// decodeBencode parses one bencoded value into int64, string, []any or
+// map[string]any.
+func decodeBencode(data []byte) (any, error) {
+	value, rest, err := bdecode(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errBencode, len(rest))
+	}
+	return value, nil
+}
+
+// nesting limit so a crafted file can't exhaust the stack
+const maxBencodeDepth = 64
+
+func bdecode(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end", errBencode)
+	}
+	if depth > maxBencodeDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deep", errBencode)
+	}
+
+	switch c := data[0]; {
+	case c == 'i':
+		end := indexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated integer", errBencode)
+		}
+		n, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errBencode, err)
+		}
+		return n, data[end+1:], nil
+
+	case c == 'l':
+		list := []any{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			value, next, err := bdecode(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, value)
+			rest = next
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated list", errBencode)
+		}
+		return list, rest[1:], nil
+
+	case c == 'd':
+		dict := map[string]any{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			key, next, err := bdecode(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: dictionary key is not a string", errBencode)
+			}
+			value, next, err := bdecode(next, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			dict[name] = value
+			rest = next
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated dictionary", errBencode)
+		}
+		return dict, rest[1:], nil
+
+	case c >= '0' && c <= '9':
+		colon := indexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("%w: string without length", errBencode)
+		}
+		n, err := strconv.Atoi(string(data[:colon]))
+		if err != nil || n < 0 || colon+1+n > len(data) {
+			return nil, nil, fmt.Errorf("%w: bad string length", errBencode)
+		}
+		return string(data[colon+1 : colon+1+n]), data[colon+1+n:], nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: unexpected %q", errBencode, data[0])
+}
+
+func indexByte(data []byte, b byte) int {
+	for i, c := range data {
+		if c == b {
+			return i
+		}
+	}
+	return -1
+}
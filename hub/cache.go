@@ -0,0 +1,555 @@
+package hub
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CachedRepo struct {
+	Id           string            `json:"id"`
+	Type         string            `json:"type"`
+	Path         string            `json:"path"`
+	Size         int64             `json:"size"`
+	NbFiles      int               `json:"nb_files"`
+	Revisions    []CachedRevision  `json:"revisions"`
+	Refs         map[string]string `json:"refs"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+type CachedRevision struct {
+	CommitHash   string    `json:"commit_hash"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	NbFiles      int       `json:"nb_files"`
+	Refs         []string  `json:"refs"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ScanCache walks the cache directory and reports every cached repo with its
+// revisions. Repo sizes count each blob once, revision sizes count the files
+// reachable from that snapshot.
+func ScanCache(cacheDir string) ([]CachedRepo, error) {
+	return (&Client{CacheDir: cacheDir}).ScanCache()
+}
+
+// ScanCache is ScanCache for the client's cache, answered from the cache
+// index when CacheIndex is set.
+func (client *Client) ScanCache() ([]CachedRepo, error) {
+	if client.CacheIndex {
+		var repos []CachedRepo
+		err := client.withCacheIndex(func(idx *CacheIndex) {
+			repos = idx.Scan()
+		})
+		if err == nil {
+			return repos, nil
+		}
+		log.Printf("[Download] Cache index unavailable, scanning the cache: %v", err)
+	}
+
+	cacheDir := client.CacheDir
+	entries, err := client.fs().ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var repos []CachedRepo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		repoId, repoType, ok := parseRepoFolderName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		repo, err := client.scanRepo(filepath.Join(cacheDir, entry.Name()), repoId, repoType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", entry.Name(), err)
+		}
+		repos = append(repos, *repo)
+	}
+
+	return repos, nil
+}
+
+// DeleteRevision removes a cached snapshot (by commit hash or ref name) along
+// with refs pointing at it and blobs no other snapshot uses. The repo folder
+// is removed entirely once its last snapshot is gone.
+func DeleteRevision(cacheDir, repoId, repoType, revision string) error {
+	_, err := (&Client{CacheDir: cacheDir}).deleteRevision(repoId, repoType, revision)
+	return err
+}
+
+// DeleteRevision is DeleteRevision in the client's cache, recording the
+// deletion with client.Audit.
+func (client *Client) DeleteRevision(repoId, repoType, revision string) error {
+	if repoType == "" {
+		repoType = ModelRepoType
+	}
+	deleted, err := client.deleteRevision(repoId, repoType, revision)
+	if deleted == nil {
+		// nothing was removed
+		return err
+	}
+	client.audit(&AuditEvent{
+		Action:     AuditDelete,
+		RepoId:     repoId,
+		RepoType:   repoType,
+		Revision:   revision,
+		CommitHash: deleted.commitHash,
+		Files:      deleted.files,
+		Bytes:      deleted.freed,
+	}, err)
+	return err
+}
+
+// deletedRevision is what deleteRevision removed: the snapshot's files and
+// the bytes of the blobs that went with them.
+type deletedRevision struct {
+	commitHash string
+	files      []string
+	freed      int64
+}
+
+// deleteRevision does the work of DeleteRevision. It returns nil until the
+// snapshot is removed, and what was removed from then on, even on errors.
+func (client *Client) deleteRevision(repoId, repoType, revision string) (*deletedRevision, error) {
+	if repoType == "" {
+		repoType = ModelRepoType
+	}
+	fsys := client.fs()
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(repoId, repoType))
+
+	commitHash := revision
+	if !isCommitHash(revision) {
+		var err error
+		commitHash, err = readRef(fsys, storageFolder, revision)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	snapshotPath := filepath.Join(storageFolder, "snapshots", commitHash)
+	if _, err := fsys.Stat(snapshotPath); err != nil {
+		return nil, fmt.Errorf("snapshot %s not found in cache: %w", commitHash, err)
+	}
+
+	deleted := &deletedRevision{commitHash: commitHash}
+	walkDir(fsys, snapshotPath, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if rel, err := filepath.Rel(snapshotPath, path); err == nil {
+				deleted.files = append(deleted.files, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+
+	if err := fsys.RemoveAll(snapshotPath); err != nil {
+		return nil, fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	appendIndexRecord(fsys, client.CacheDir, &indexRecord{
+		Op:     indexOpDelete,
+		Repo:   repoFolderName(repoId, repoType),
+		Commit: commitHash,
+	})
+
+	// drop refs that pointed at the deleted snapshot
+	refs, err := client.readRefs(storageFolder)
+	if err != nil {
+		return deleted, err
+	}
+	for name, hash := range refs {
+		if hash == commitHash {
+			client.removeRef(storageFolder, name)
+		}
+	}
+
+	// drop blobs that are no longer referenced by any snapshot
+	used := make(map[string]bool)
+	var realFiles []string
+	snapshots, _ := fsys.ReadDir(filepath.Join(storageFolder, "snapshots"))
+	for _, snapshot := range snapshots {
+		client.walkSnapshot(filepath.Join(storageFolder, "snapshots", snapshot.Name()), func(path string, target string, _ os.FileInfo) {
+			if target == path {
+				realFiles = append(realFiles, path)
+				return
+			}
+			used[filepath.Base(target)] = true
+		})
+	}
+
+	// downloads in progress aren't unused, and keep the repo folder
+	unused := make(map[string]int64)
+	downloading := false
+	blobs, _ := fsys.ReadDir(filepath.Join(storageFolder, "blobs"))
+	for _, blob := range blobs {
+		if strings.HasSuffix(blob.Name(), ".incomplete") {
+			downloading = true
+			continue
+		}
+		if used[blob.Name()] {
+			continue
+		}
+		if info, err := blob.Info(); err == nil {
+			unused[blob.Name()] = info.Size()
+		}
+	}
+	// real files placed by LinkMode keep the blob they were copied from,
+	// found by the hash it is named after
+	client.markCopiedBlobs(realFiles, unused)
+
+	removeRepo := len(snapshots) == 0 && !downloading
+	for name, size := range unused {
+		deleted.freed += size
+		if !removeRepo {
+			fsys.Remove(filepath.Join(storageFolder, "blobs", name))
+		}
+	}
+
+	if removeRepo {
+		return deleted, fsys.RemoveAll(storageFolder)
+	}
+	return deleted, nil
+}
+
+// markCopiedBlobs removes from unused the blobs that files hold a copy of.
+// Only files the size of an unused blob are hashed, by sha256 for LFS blobs
+// and the git object id for the rest.
+func (client *Client) markCopiedBlobs(files []string, unused map[string]int64) {
+	for _, path := range files {
+		info, err := client.fs().Stat(path)
+		if err != nil {
+			continue
+		}
+		sameSize := false
+		for _, size := range unused {
+			if size == info.Size() {
+				sameSize = true
+				break
+			}
+		}
+		if !sameSize {
+			continue
+		}
+
+		f, err := client.fs().OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+		sha := sha256.New()
+		oid := sha1.New()
+		fmt.Fprintf(oid, "blob %d\x00", info.Size())
+		_, err = io.Copy(io.MultiWriter(sha, oid), f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		delete(unused, hex.EncodeToString(sha.Sum(nil)))
+		delete(unused, hex.EncodeToString(oid.Sum(nil)))
+	}
+}
+
+// IsSnapshotComplete reports whether the cached snapshot of a revision (a
+// commit hash or a ref such as "main") holds every file matching allowPatterns,
+// without touching the network. The repo's full file list isn't known offline,
+// so each pattern must match at least one cached file: literal names must
+// exist, globs are satisfied by any match. Links to missing blobs make the
+// snapshot incomplete.
+func IsSnapshotComplete(cacheDir string, repo *Repo, revision string, allowPatterns []string) (bool, error) {
+	return (&Client{CacheDir: cacheDir}).IsSnapshotComplete(repo, revision, allowPatterns)
+}
+
+// IsSnapshotComplete is IsSnapshotComplete for the client's cache.
+func (client *Client) IsSnapshotComplete(repo *Repo, revision string, allowPatterns []string) (bool, error) {
+	fsys := client.fs()
+	if revision == "" {
+		revision = repo.Revision
+	}
+	if revision == "" {
+		revision = DefaultRevision
+	}
+
+	repoType := repo.Type
+	if repoType == "" {
+		repoType = ModelRepoType
+	}
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(repo.Id, repoType))
+
+	commitHash := revision
+	if !isCommitHash(revision) {
+		var err error
+		commitHash, err = readRef(fsys, storageFolder, revision)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve revision %s: %w", revision, err)
+		}
+	}
+
+	snapshotPath := filepath.Join(storageFolder, "snapshots", commitHash)
+	if _, err := fsys.Stat(snapshotPath); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	var files []string
+	complete := true
+	err := walkDir(fsys, snapshotPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(snapshotPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if len(allowPatterns) > 0 && !matchesAnyPattern(relPath, allowPatterns) {
+			return nil
+		}
+
+		// follows the pointer to its blob
+		if _, err := fsys.Stat(path); err != nil {
+			complete = false
+			return filepath.SkipAll
+		}
+		files = append(files, relPath)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if !complete || len(files) == 0 {
+		return false, nil
+	}
+
+	for _, pattern := range allowPatterns {
+		if !anyFileMatches(files, pattern) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func anyFileMatches(files []string, pattern string) bool {
+	for _, file := range files {
+		if matchesAnyPattern(file, []string{pattern}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) scanRepo(storageFolder, repoId, repoType string) (*CachedRepo, error) {
+	repo := &CachedRepo{
+		Id:   repoId,
+		Type: repoType,
+		Path: storageFolder,
+	}
+
+	refs, err := client.readRefs(storageFolder)
+	if err != nil {
+		return nil, err
+	}
+	repo.Refs = refs
+
+	refsByCommit := make(map[string][]string)
+	for name, hash := range refs {
+		refsByCommit[hash] = append(refsByCommit[hash], name)
+	}
+
+	blobs, _ := client.fs().ReadDir(filepath.Join(storageFolder, "blobs"))
+	for _, blob := range blobs {
+		info, err := blob.Info()
+		if err != nil || info.IsDir() || strings.HasSuffix(blob.Name(), ".incomplete") {
+			continue
+		}
+		repo.Size += info.Size()
+		repo.NbFiles++
+		if info.ModTime().After(repo.LastModified) {
+			repo.LastModified = info.ModTime()
+		}
+	}
+
+	snapshots, _ := client.fs().ReadDir(filepath.Join(storageFolder, "snapshots"))
+	for _, snapshot := range snapshots {
+		if !snapshot.IsDir() {
+			continue
+		}
+
+		revision := CachedRevision{
+			CommitHash: snapshot.Name(),
+			Path:       filepath.Join(storageFolder, "snapshots", snapshot.Name()),
+			Refs:       refsByCommit[snapshot.Name()],
+		}
+		sort.Strings(revision.Refs)
+
+		client.walkSnapshot(revision.Path, func(_ string, _ string, info os.FileInfo) {
+			revision.Size += info.Size()
+			revision.NbFiles++
+			if info.ModTime().After(revision.LastModified) {
+				revision.LastModified = info.ModTime()
+			}
+		})
+
+		repo.Revisions = append(repo.Revisions, revision)
+	}
+
+	return repo, nil
+}
+
+func (client *Client) readRefs(storageFolder string) (map[string]string, error) {
+	refs := make(map[string]string)
+	refsDir := filepath.Join(storageFolder, "refs")
+
+	err := walkDir(client.fs(), refsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		data, err := client.fs().ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// a ref left half written by writeRef
+		if isStaleRefTemp(path, data) {
+			return nil
+		}
+
+		name, _ := filepath.Rel(refsDir, path)
+		refs[filepath.ToSlash(name)] = strings.TrimSpace(string(data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refs: %w", err)
+	}
+
+	return refs, nil
+}
+
+// removeRef removes a ref file along with the folders a nested ref such as
+// refs/pr/1 leaves empty.
+func (client *Client) removeRef(storageFolder string, name string) {
+	refsDir := filepath.Join(storageFolder, "refs")
+	path := filepath.Join(refsDir, name)
+	client.fs().Remove(path)
+	for dir := filepath.Dir(path); dir != refsDir && strings.HasPrefix(dir, refsDir); dir = filepath.Dir(dir) {
+		if client.fs().Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// walkSnapshot calls fn for every file in a snapshot with the resolved target
+// path (the blob for symlinked files) and its stat info.
+func (client *Client) walkSnapshot(snapshotPath string, fn func(path string, target string, info os.FileInfo)) {
+	fsys := client.fs()
+	walkDir(fsys, snapshotPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		target := path
+		if entry.Type()&os.ModeSymlink != 0 {
+			resolved, err := evalSymlinks(fsys, path)
+			if err != nil {
+				return nil
+			}
+			target = resolved
+		}
+
+		targetInfo, err := fsys.Stat(target)
+		if err != nil {
+			return nil
+		}
+
+		fn(path, target, targetInfo)
+		return nil
+	})
+}
+
+func parseRepoFolderName(name string) (string, string, bool) {
+	parts := strings.Split(name, "--")
+	if len(parts) < 2 {
+		return "", "", false
+	}
+
+	var repoType string
+	switch parts[0] {
+	case "models":
+		repoType = ModelRepoType
+	case "datasets":
+		repoType = DatasetRepoType
+	case "spaces":
+		repoType = SpaceRepoType
+	default:
+		return "", "", false
+	}
+
+	return strings.Join(parts[1:], "/"), repoType, true
+}
+
+type CacheStats struct {
+	Repos     int   `json:"repos"`
+	Revisions int   `json:"revisions"`
+	Files     int   `json:"files"`
+	Size      int64 `json:"size"`
+}
+
+func GetCacheStats(cacheDir string) (*CacheStats, error) {
+	return (&Client{CacheDir: cacheDir}).CacheStats()
+}
+
+// CacheStats is GetCacheStats for the client's cache, answered from the
+// cache index when CacheIndex is set.
+func (client *Client) CacheStats() (*CacheStats, error) {
+	if client.CacheIndex {
+		var stats *CacheStats
+		err := client.withCacheIndex(func(idx *CacheIndex) {
+			stats = idx.Stats()
+		})
+		if err == nil {
+			return stats, nil
+		}
+		log.Printf("[Download] Cache index unavailable, scanning the cache: %v", err)
+	}
+
+	repos, err := client.ScanCache()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &CacheStats{Repos: len(repos)}
+	for _, repo := range repos {
+		stats.Revisions += len(repo.Revisions)
+		stats.Files += repo.NbFiles
+		stats.Size += repo.Size
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,158 @@
+package hub
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrCacheCorrupt is returned when a cached file fails the Client.CacheCheck.
+var ErrCacheCorrupt = errors.New("cached file is corrupt")
+
+// CacheCheck is how much a cached file is checked before it is returned, to
+// catch bit rot or manual edits in long-lived caches.
+type CacheCheck int
+
+const (
+	CacheCheckNone CacheCheck = iota
+	// compare the blob's size with the file's metadata; costs a stat
+	CacheCheckSize
+	// also hash the whole blob against its name, the sha256 of LFS files or
+	// the git blob id of the others; costs a full read
+	CacheCheckHash
+)
+
+var gitOidPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// checkCachedFile checks the blob behind pointerPath against its etag and,
+// when known (> 0), its size. Corrupt blobs and their pointer are removed so
+// the file is fetched again.
+func (client *Client) checkCachedFile(pointerPath string, blobPath string, etag string, size int64) error {
+	if client.CacheCheck == CacheCheckNone {
+		return nil
+	}
+
+	err := client.verifyBlob(blobPath, etag, size)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("[Download] Removing corrupt cached file %s: %v", pointerPath, err)
+	var freed int64
+	if info, statErr := client.fs().Stat(blobPath); statErr == nil {
+		freed = info.Size()
+	}
+	client.fs().Remove(pointerPath)
+	client.fs().Remove(blobPath)
+	client.auditEviction(pointerPath, freed, err.Error())
+	return err
+}
+
+func (client *Client) verifyBlob(blobPath string, etag string, size int64) error {
+	info, err := client.fs().Stat(blobPath)
+	if err != nil {
+		return err
+	}
+	if size > 0 && info.Size() != size {
+		return fmt.Errorf("%w: %s has %d bytes, expected %d", ErrCacheCorrupt, filepath.Base(blobPath), info.Size(), size)
+	}
+	if client.CacheCheck < CacheCheckHash {
+		return nil
+	}
+
+	var h hash.Hash
+	switch {
+	case sha256Pattern.MatchString(etag):
+		h = sha256.New()
+	case gitOidPattern.MatchString(etag):
+		// git hashes a "blob <size>\0" header before the content
+		h = sha1.New()
+		fmt.Fprintf(h, "blob %d\x00", info.Size())
+	default:
+		// a blob keyed by some other etag can't be checked
+		return nil
+	}
+
+	f, err := client.fs().OpenFile(blobPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", blobPath, err)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != etag {
+		return fmt.Errorf("%w: %s hashes to %s", ErrCacheCorrupt, filepath.Base(blobPath), sum)
+	}
+	return nil
+}
+
+// resolveBlob finds the blob a snapshot pointer links to, for checking a cache
+// hit without metadata. Pointers that are copies rather than symlinks are
+// their own blob.
+func (client *Client) resolveBlob(pointerPath string) string {
+	if blob, err := evalSymlinks(client.fs(), pointerPath); err == nil {
+		return blob
+	}
+	return pointerPath
+}
+
+// checkPointer makes sure a snapshot pointer that is a symlink resolves to a
+// blob in the blobs folder of storageFolder, so a dangling link, or one that
+// was pointed elsewhere, is never returned as a cache hit. A broken link is
+// created again when the blob its target names is still cached, and removed
+// otherwise. It reports whether a usable file is at pointerPath.
+func (client *Client) checkPointer(storageFolder string, pointerPath string) bool {
+	info, err := client.fs().Lstat(pointerPath)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		// copies and hardlinks are their own blob
+		return info.Mode().IsRegular()
+	}
+	blobsDir := filepath.Join(storageFolder, "blobs")
+	if client.inBlobsDir(blobsDir, pointerPath) {
+		return true
+	}
+
+	if target, err := client.fs().Readlink(pointerPath); err == nil && validPathSegment(filepath.Base(target)) {
+		blobPath := filepath.Join(blobsDir, filepath.Base(target))
+		if info, err := client.fs().Stat(blobPath); err == nil && info.Mode().IsRegular() {
+			log.Printf("[Download] Relinking broken pointer %s", pointerPath)
+			if err := createSymlink(client, blobPath, pointerPath); err == nil {
+				return true
+			}
+		}
+	}
+
+	log.Printf("[Download] Removing broken pointer %s", pointerPath)
+	client.fs().Remove(pointerPath)
+	client.auditEviction(pointerPath, 0, "broken link")
+	return false
+}
+
+// inBlobsDir reports whether the symlink at pointerPath resolves to a regular
+// file directly in blobsDir. Both sides are resolved, so a cache reached
+// through a symlinked folder still matches.
+func (client *Client) inBlobsDir(blobsDir string, pointerPath string) bool {
+	target, err := evalSymlinks(client.fs(), pointerPath)
+	if err != nil {
+		return false
+	}
+	dir, err := evalSymlinks(client.fs(), blobsDir)
+	if err != nil || filepath.Dir(target) != dir {
+		return false
+	}
+	info, err := client.fs().Stat(target)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,719 @@
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoCacheIndex is returned by LoadCacheIndex when the cache has never been
+// indexed; RebuildCacheIndex creates the index from what is on disk.
+var ErrNoCacheIndex = errors.New("cache has no index")
+
+// the journal is folded into the index file once it holds this many records
+const cacheIndexCompactRecords = 10000
+
+// CacheIndex mirrors the cache layout (repos, revisions, files and blob sizes)
+// so scans and eviction decisions don't have to walk the cache directory.
+// Clients with CacheIndex set append every download and cache hit to a
+// journal, which LoadCacheIndex replays.
+//
+// The index is a JSON file and an append-only journal rather than a database:
+// processes sharing the cache append without coordinating, it works on any
+// FS, and it needs no cgo or database dependency. Clients with CacheIndex set
+// keep one loaded index per cache, which their ScanCache and CacheStats
+// bring up to date by reading only the journal lines appended since.
+type CacheIndex struct {
+	CacheDir  string                  `json:"-"`
+	UpdatedAt time.Time               `json:"updated_at"`
+	Repos     map[string]*IndexedRepo `json:"repos"`
+}
+
+type IndexedRepo struct {
+	Id        string                      `json:"id"`
+	Type      string                      `json:"type"`
+	Refs      map[string]string           `json:"refs"`
+	Revisions map[string]*IndexedRevision `json:"revisions"`
+	Blobs     map[string]*IndexedBlob     `json:"blobs"`
+}
+
+type IndexedRevision struct {
+	// file path in the snapshot to blob name
+	Files      map[string]string `json:"files"`
+	LastAccess time.Time         `json:"last_access"`
+}
+
+type IndexedBlob struct {
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	LastAccess   time.Time `json:"last_access"`
+}
+
+// indexRecord is one journal line.
+type indexRecord struct {
+	Op     string    `json:"op"`
+	Repo   string    `json:"repo"`
+	Commit string    `json:"commit"`
+	Ref    string    `json:"ref,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Blob   string    `json:"blob,omitempty"`
+	Size   int64     `json:"size,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+const (
+	indexOpFile   = "file"
+	indexOpAccess = "access"
+	indexOpDelete = "delete"
+	indexOpRef    = "ref"
+)
+
+func cacheIndexDir(cacheDir string) string {
+	return filepath.Join(cacheDir, ".index")
+}
+
+// LoadCacheIndex reads the index and replays the journal on top of it.
+func LoadCacheIndex(cacheDir string) (*CacheIndex, error) {
+	return (&Client{CacheDir: cacheDir}).LoadCacheIndex()
+}
+
+// LoadCacheIndex is LoadCacheIndex for the client's cache.
+func (client *Client) LoadCacheIndex() (*CacheIndex, error) {
+	dir := cacheIndexDir(client.CacheDir)
+	idx, err := client.readCacheIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := client.replayJournal(idx, filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	if records >= cacheIndexCompactRecords {
+		if err := client.compactCacheIndex(); err != nil {
+			log.Printf("[Download] Failed to compact cache index: %v", err)
+		}
+	}
+
+	return idx, nil
+}
+
+func (client *Client) readCacheIndex() (*CacheIndex, error) {
+	data, err := client.fs().ReadFile(filepath.Join(cacheIndexDir(client.CacheDir), "index.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrNoCacheIndex
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache index: %w", err)
+	}
+
+	idx := &CacheIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse cache index: %w", err)
+	}
+	idx.CacheDir = client.CacheDir
+	if idx.Repos == nil {
+		idx.Repos = make(map[string]*IndexedRepo)
+	}
+	return idx, nil
+}
+
+// replayJournal applies every record of the journal and returns their count.
+func (client *Client) replayJournal(idx *CacheIndex, path string) (int, error) {
+	count, _, err := client.replayJournalFrom(idx, path, 0)
+	return count, err
+}
+
+// replayJournalFrom applies the records of the journal past offset, and
+// returns their count and the offset after the last complete line. A line
+// still being written is left for the next call.
+func (client *Client) replayJournalFrom(idx *CacheIndex, path string, offset int64) (int, int64, error) {
+	f, err := client.fs().OpenFile(path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, offset, nil
+	}
+	if err != nil {
+		return 0, offset, fmt.Errorf("failed to read cache index journal: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, offset, fmt.Errorf("failed to read cache index journal: %w", err)
+	}
+
+	count := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return count, offset, nil
+		}
+		if err != nil {
+			return count, offset, fmt.Errorf("failed to read cache index journal: %w", err)
+		}
+		offset += int64(len(line))
+
+		var record indexRecord
+		// a torn line from a crashed writer is skipped
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+		idx.apply(&record)
+		count++
+	}
+}
+
+// loadedIndexes holds the index each cache's clients share, by loadedIndexKey.
+var loadedIndexes sync.Map
+
+type loadedIndexKey struct {
+	fs  FS
+	dir string
+}
+
+// loadedIndex is an index kept current with its journal.
+type loadedIndex struct {
+	mu  sync.Mutex
+	idx *CacheIndex
+	// index.json as loaded; compactions and rebuilds replace it
+	modTime time.Time
+	size    int64
+	// journal bytes and records applied on top of it
+	offset  int64
+	records int
+}
+
+// withCacheIndex calls fn with the client's loaded index once it has caught
+// up with the journal. fn must not keep idx, which later calls update.
+func (client *Client) withCacheIndex(fn func(idx *CacheIndex)) error {
+	fsys := client.fs()
+	// FS implementations that can't be map keys load the index every time
+	if !reflect.TypeOf(fsys).Comparable() {
+		idx, err := client.LoadCacheIndex()
+		if err != nil {
+			return err
+		}
+		fn(idx)
+		return nil
+	}
+
+	value, _ := loadedIndexes.LoadOrStore(loadedIndexKey{fs: fsys, dir: filepath.Clean(client.CacheDir)}, &loadedIndex{})
+	loaded := value.(*loadedIndex)
+	loaded.mu.Lock()
+	defer loaded.mu.Unlock()
+
+	if err := loaded.refresh(client); err != nil {
+		return err
+	}
+	fn(loaded.idx)
+	return nil
+}
+
+func (l *loadedIndex) refresh(client *Client) error {
+	dir := cacheIndexDir(client.CacheDir)
+	journalPath := filepath.Join(dir, "journal.jsonl")
+
+	info, err := client.fs().Stat(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		l.idx = nil
+		return ErrNoCacheIndex
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache index: %w", err)
+	}
+
+	// a journal shorter than what was applied was rotated by a compaction
+	// or rebuild, which rewrites the index too
+	rotated := false
+	if journal, err := client.fs().Stat(journalPath); err == nil && journal.Size() < l.offset {
+		rotated = true
+	} else if os.IsNotExist(err) && l.offset > 0 {
+		rotated = true
+	}
+
+	if l.idx == nil || rotated || !info.ModTime().Equal(l.modTime) || info.Size() != l.size {
+		idx, err := client.readCacheIndex()
+		if err != nil {
+			return err
+		}
+		l.idx, l.modTime, l.size, l.offset, l.records = idx, info.ModTime(), info.Size(), 0, 0
+	}
+
+	records, offset, err := client.replayJournalFrom(l.idx, journalPath, l.offset)
+	l.offset = offset
+	l.records += records
+	if err != nil {
+		return err
+	}
+
+	if l.records >= cacheIndexCompactRecords {
+		if err := client.compactCacheIndex(); err != nil {
+			log.Printf("[Download] Failed to compact cache index: %v", err)
+		}
+		// the index is read again once the compaction replaced it
+		l.records = 0
+	}
+	return nil
+}
+
+// compactCacheIndex folds the journal into the index file. Records appended
+// while it runs go to a fresh journal.
+func (client *Client) compactCacheIndex() error {
+	fsys := client.fs()
+	dir := cacheIndexDir(client.CacheDir)
+	lock, locked, err := client.tryLock(filepath.Join(dir, "lock"))
+	if err != nil || !locked {
+		return err
+	}
+	defer lock.Unlock()
+
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	compacting := journalPath + ".compacting"
+	if err := fsys.Rename(journalPath, compacting); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	idx, err := client.readCacheIndex()
+	if err != nil {
+		return err
+	}
+	if _, err := client.replayJournal(idx, compacting); err != nil {
+		return err
+	}
+	if err := client.writeCacheIndex(idx); err != nil {
+		return err
+	}
+	return fsys.Remove(compacting)
+}
+
+// RebuildCacheIndex walks the cache directory once and replaces the index with
+// what it finds, e.g. after files were added by another tool.
+func RebuildCacheIndex(cacheDir string) (*CacheIndex, error) {
+	return (&Client{CacheDir: cacheDir}).RebuildCacheIndex()
+}
+
+// RebuildCacheIndex is RebuildCacheIndex for the client's cache.
+func (client *Client) RebuildCacheIndex() (*CacheIndex, error) {
+	fsys := client.fs()
+	cacheDir := client.CacheDir
+	dir := cacheIndexDir(cacheDir)
+	if err := client.mkdirAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to create cache index directory: %w", err)
+	}
+
+	lock, err := client.lock(filepath.Join(dir, "lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock cache index: %w", err)
+	}
+	defer lock.Unlock()
+
+	// the walk sees everything journaled so far
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	fsys.Remove(journalPath + ".compacting")
+	if err := fsys.Rename(journalPath, journalPath+".compacting"); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to rotate cache index journal: %w", err)
+	}
+
+	idx := &CacheIndex{CacheDir: cacheDir, Repos: make(map[string]*IndexedRepo)}
+	entries, err := fsys.ReadDir(cacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		repoId, repoType, ok := parseRepoFolderName(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		repo, err := client.indexRepo(filepath.Join(cacheDir, entry.Name()), repoId, repoType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index %s: %w", entry.Name(), err)
+		}
+		idx.Repos[entry.Name()] = repo
+	}
+
+	if err := client.writeCacheIndex(idx); err != nil {
+		return nil, err
+	}
+	fsys.Remove(journalPath + ".compacting")
+
+	// records written during the walk
+	if _, err := client.replayJournal(idx, journalPath); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (client *Client) indexRepo(storageFolder string, repoId string, repoType string) (*IndexedRepo, error) {
+	refs, err := client.readRefs(storageFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &IndexedRepo{
+		Id:        repoId,
+		Type:      repoType,
+		Refs:      refs,
+		Revisions: make(map[string]*IndexedRevision),
+		Blobs:     make(map[string]*IndexedBlob),
+	}
+
+	snapshots, _ := client.fs().ReadDir(filepath.Join(storageFolder, "snapshots"))
+	for _, snapshot := range snapshots {
+		if !snapshot.IsDir() {
+			continue
+		}
+		snapshotPath := filepath.Join(storageFolder, "snapshots", snapshot.Name())
+		revision := &IndexedRevision{Files: make(map[string]string)}
+
+		client.walkSnapshot(snapshotPath, func(path string, target string, info os.FileInfo) {
+			relPath, err := filepath.Rel(snapshotPath, path)
+			if err != nil {
+				return
+			}
+			blob := filepath.Base(target)
+			revision.Files[filepath.ToSlash(relPath)] = blob
+			repo.Blobs[blob] = &IndexedBlob{
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+				LastAccess:   info.ModTime(),
+			}
+			if info.ModTime().After(revision.LastAccess) {
+				revision.LastAccess = info.ModTime()
+			}
+		})
+
+		repo.Revisions[snapshot.Name()] = revision
+	}
+
+	return repo, nil
+}
+
+func (client *Client) writeCacheIndex(idx *CacheIndex) error {
+	idx.UpdatedAt = time.Now()
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache index: %w", err)
+	}
+
+	path := filepath.Join(cacheIndexDir(idx.CacheDir), "index.json")
+	tmpPath := path + ".tmp"
+	if err := client.writeFile(tmpPath, data); err != nil {
+		return fmt.Errorf("failed to write cache index: %w", err)
+	}
+	if err := client.fs().Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move cache index into place: %w", err)
+	}
+	return nil
+}
+
+func (idx *CacheIndex) apply(record *indexRecord) {
+	repo := idx.Repos[record.Repo]
+	if repo == nil {
+		if record.Op == indexOpDelete {
+			return
+		}
+		repoId, repoType, ok := parseRepoFolderName(record.Repo)
+		if !ok {
+			return
+		}
+		repo = &IndexedRepo{
+			Id:        repoId,
+			Type:      repoType,
+			Refs:      make(map[string]string),
+			Revisions: make(map[string]*IndexedRevision),
+			Blobs:     make(map[string]*IndexedBlob),
+		}
+		idx.Repos[record.Repo] = repo
+	}
+
+	switch record.Op {
+	case indexOpDelete:
+		repo.deleteRevision(record.Commit)
+		if len(repo.Revisions) == 0 {
+			delete(idx.Repos, record.Repo)
+		}
+		return
+	case indexOpRef:
+		repo.Refs[record.Ref] = record.Commit
+		return
+	}
+
+	if record.Ref != "" {
+		repo.Refs[record.Ref] = record.Commit
+	}
+	revision := repo.Revisions[record.Commit]
+	if revision == nil && record.Op == indexOpAccess {
+		// files cached before the index was built wait for a rebuild
+		return
+	}
+	if revision == nil {
+		revision = &IndexedRevision{Files: make(map[string]string)}
+		repo.Revisions[record.Commit] = revision
+	}
+	if record.Time.After(revision.LastAccess) {
+		revision.LastAccess = record.Time
+	}
+
+	blob := record.Blob
+	if blob == "" {
+		blob = revision.Files[record.Path]
+	}
+	if blob == "" {
+		return
+	}
+	if record.Op == indexOpFile {
+		revision.Files[record.Path] = blob
+		if repo.Blobs[blob] == nil {
+			repo.Blobs[blob] = &IndexedBlob{Size: record.Size, LastModified: record.Time}
+		}
+	}
+	if b := repo.Blobs[blob]; b != nil && record.Time.After(b.LastAccess) {
+		b.LastAccess = record.Time
+	}
+}
+
+// deleteRevision drops the revision, the refs pointing at it and blobs no
+// other revision uses, like DeleteRevision does on disk.
+func (repo *IndexedRepo) deleteRevision(commitHash string) {
+	delete(repo.Revisions, commitHash)
+	for name, hash := range repo.Refs {
+		if hash == commitHash {
+			delete(repo.Refs, name)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, revision := range repo.Revisions {
+		for _, blob := range revision.Files {
+			used[blob] = true
+		}
+	}
+	for blob := range repo.Blobs {
+		if !used[blob] {
+			delete(repo.Blobs, blob)
+		}
+	}
+}
+
+// Scan reports the indexed repos the way ScanCache does.
+func (idx *CacheIndex) Scan() []CachedRepo {
+	names := make([]string, 0, len(idx.Repos))
+	for name := range idx.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	repos := make([]CachedRepo, 0, len(names))
+	for _, name := range names {
+		indexed := idx.Repos[name]
+		repo := CachedRepo{
+			Id:   indexed.Id,
+			Type: indexed.Type,
+			Path: filepath.Join(idx.CacheDir, name),
+			Refs: maps.Clone(indexed.Refs),
+		}
+		for _, blob := range indexed.Blobs {
+			repo.Size += blob.Size
+			repo.NbFiles++
+			if blob.LastModified.After(repo.LastModified) {
+				repo.LastModified = blob.LastModified
+			}
+		}
+
+		refsByCommit := make(map[string][]string)
+		for ref, hash := range indexed.Refs {
+			refsByCommit[hash] = append(refsByCommit[hash], ref)
+		}
+
+		commits := make([]string, 0, len(indexed.Revisions))
+		for commit := range indexed.Revisions {
+			commits = append(commits, commit)
+		}
+		sort.Strings(commits)
+
+		for _, commit := range commits {
+			revision := CachedRevision{
+				CommitHash: commit,
+				Path:       filepath.Join(repo.Path, "snapshots", commit),
+				Refs:       refsByCommit[commit],
+			}
+			sort.Strings(revision.Refs)
+			for _, blobName := range indexed.Revisions[commit].Files {
+				blob := indexed.Blobs[blobName]
+				if blob == nil {
+					continue
+				}
+				revision.Size += blob.Size
+				revision.NbFiles++
+				if blob.LastModified.After(revision.LastModified) {
+					revision.LastModified = blob.LastModified
+				}
+			}
+			repo.Revisions = append(repo.Revisions, revision)
+		}
+
+		repos = append(repos, repo)
+	}
+
+	return repos
+}
+
+// Stats totals the index the way GetCacheStats does.
+func (idx *CacheIndex) Stats() *CacheStats {
+	stats := &CacheStats{Repos: len(idx.Repos)}
+	for _, repo := range idx.Repos {
+		stats.Revisions += len(repo.Revisions)
+		stats.Files += len(repo.Blobs)
+		for _, blob := range repo.Blobs {
+			stats.Size += blob.Size
+		}
+	}
+	return stats
+}
+
+// EvictionCandidate is a cached revision and the bytes deleting it frees.
+type EvictionCandidate struct {
+	RepoId     string    `json:"repo_id"`
+	RepoType   string    `json:"repo_type"`
+	CommitHash string    `json:"commit_hash"`
+	Refs       []string  `json:"refs"`
+	Reclaim    int64     `json:"reclaim"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// EvictionCandidates lists every revision, least recently used first. Reclaim
+// only counts blobs no other revision of the repo shares.
+func (idx *CacheIndex) EvictionCandidates() []EvictionCandidate {
+	var candidates []EvictionCandidate
+	for _, repo := range idx.Repos {
+		users := make(map[string]int)
+		for _, revision := range repo.Revisions {
+			for _, blob := range uniqueBlobs(revision) {
+				users[blob]++
+			}
+		}
+
+		for commit, revision := range repo.Revisions {
+			candidate := EvictionCandidate{
+				RepoId:     repo.Id,
+				RepoType:   repo.Type,
+				CommitHash: commit,
+				LastAccess: revision.LastAccess,
+			}
+			for ref, hash := range repo.Refs {
+				if hash == commit {
+					candidate.Refs = append(candidate.Refs, ref)
+				}
+			}
+			sort.Strings(candidate.Refs)
+			for _, blob := range uniqueBlobs(revision) {
+				if b := repo.Blobs[blob]; b != nil && users[blob] == 1 {
+					candidate.Reclaim += b.Size
+				}
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].LastAccess.Equal(candidates[j].LastAccess) {
+			return candidates[i].LastAccess.Before(candidates[j].LastAccess)
+		}
+		return candidates[i].RepoId+candidates[i].CommitHash < candidates[j].RepoId+candidates[j].CommitHash
+	})
+	return candidates
+}
+
+func uniqueBlobs(revision *IndexedRevision) []string {
+	seen := make(map[string]bool, len(revision.Files))
+	var blobs []string
+	for _, blob := range revision.Files {
+		if !seen[blob] {
+			seen[blob] = true
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs
+}
+
+// appendIndexRecord adds a record to the journal of an indexed cache. Caches
+// without an index are left alone.
+func appendIndexRecord(fsys FS, cacheDir string, record *indexRecord) {
+	dir := cacheIndexDir(cacheDir)
+	if _, err := fsys.Stat(filepath.Join(dir, "index.json")); err != nil {
+		return
+	}
+
+	record.Time = time.Now()
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	// single small appends don't interleave between writers
+	f, err := fsys.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[Download] Failed to update cache index: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		log.Printf("[Download] Failed to update cache index: %v", err)
+	}
+}
+
+// indexFile records a file that is now in the cache.
+func (client *Client) indexFile(repo *Repo, revision string, fileName string, metadata *FileMetadata) {
+	if !client.CacheIndex {
+		return
+	}
+	record := &indexRecord{
+		Op:     indexOpFile,
+		Repo:   repoFolderName(repo.Id, repo.Type),
+		Commit: metadata.CommitHash,
+		Path:   filepath.ToSlash(fileName),
+		Blob:   metadata.ETag,
+		Size:   int64(metadata.Size),
+	}
+	if revision != metadata.CommitHash {
+		record.Ref = revision
+	}
+	appendIndexRecord(client.fs(), client.CacheDir, record)
+}
+
+// indexAccess records a cache hit on a file of a known commit.
+func (client *Client) indexAccess(repo *Repo, commitHash string, fileName string) {
+	if !client.CacheIndex {
+		return
+	}
+	appendIndexRecord(client.fs(), client.CacheDir, &indexRecord{
+		Op:     indexOpAccess,
+		Repo:   repoFolderName(repo.Id, repo.Type),
+		Commit: commitHash,
+		Path:   filepath.ToSlash(fileName),
+	})
+}
+
+// indexRef records a ref moving to commitHash.
+func (client *Client) indexRef(repo *Repo, ref string, commitHash string) {
+	if !client.CacheIndex {
+		return
+	}
+	appendIndexRecord(client.fs(), client.CacheDir, &indexRecord{
+		Op:     indexOpRef,
+		Repo:   repoFolderName(repo.Id, repo.Type),
+		Commit: commitHash,
+		Ref:    ref,
+	})
+}
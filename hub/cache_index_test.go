@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadedCacheIndexFollowsJournal(t *testing.T) {
+	client, _ := memCache(t)
+	if _, err := client.RebuildCacheIndex(); err != nil {
+		t.Fatal(err)
+	}
+	client.CacheIndex = true
+
+	stats, err := client.CacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Repos != 1 || stats.Revisions != 1 || stats.Files != 2 {
+		t.Fatalf("got %+v, want 1 repo, 1 revision, 2 files", stats)
+	}
+
+	// a download by another client of the cache lands in the journal
+	other := &Client{CacheDir: client.CacheDir, FS: client.FS, CacheIndex: true}
+	other.indexFile(&Repo{Id: "org/other", Type: ModelRepoType}, "main", "model.bin", &FileMetadata{
+		CommitHash: "89abcdef0123456789abcdef0123456789abcdef",
+		ETag:       "blob-other",
+		Size:       100,
+	})
+
+	repos, err := client.ScanCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[1].Id != "org/other" || repos[1].Size != 100 || repos[1].Refs["main"] == "" {
+		t.Fatalf("journaled download missing from %+v", repos)
+	}
+
+	value, ok := loadedIndexes.Load(loadedIndexKey{fs: client.FS, dir: filepath.Clean(client.CacheDir)})
+	if !ok {
+		t.Fatal("index not kept loaded")
+	}
+	if loaded := value.(*loadedIndex); loaded.records != 1 {
+		t.Fatalf("applied %d journal records, want 1", loaded.records)
+	}
+
+	// a rebuild replaces the index the client holds
+	client.fs().RemoveAll(filepath.Join(client.CacheDir, repoFolderName("org/model", ModelRepoType)))
+	if _, err := client.RebuildCacheIndex(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = client.CacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Repos != 0 {
+		t.Fatalf("got %d repos after the rebuild, want 0", stats.Repos)
+	}
+}
@@ -0,0 +1,167 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCommit = "0123456789abcdef0123456789abcdef01234567"
+
+// memCache lays out one cached snapshot of org/model in a MemFS: a ref, two
+// blobs and a pointer to each, the second in a subfolder.
+func memCache(t *testing.T) (*Client, string) {
+	t.Helper()
+	client := &Client{CacheDir: "/cache", FS: NewMemFS()}
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName("org/model", ModelRepoType))
+	snapshotPath := filepath.Join(storageFolder, "snapshots", testCommit)
+
+	for _, dir := range []string{filepath.Join(storageFolder, "blobs"), filepath.Join(storageFolder, "refs"), snapshotPath} {
+		if err := client.mkdirAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.writeFile(filepath.Join(storageFolder, "refs", "main"), []byte(testCommit+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config.json":            "aaaa",
+		"unet/model.safetensors": "bbbbbbbb",
+	}
+	for name, content := range files {
+		blobPath := filepath.Join(storageFolder, "blobs", "blob-"+filepath.Base(name))
+		if err := client.writeFile(blobPath, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := createSymlink(client, blobPath, filepath.Join(snapshotPath, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client, storageFolder
+}
+
+func TestMemFSScanCache(t *testing.T) {
+	client, _ := memCache(t)
+
+	repos, err := client.ScanCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 {
+		t.Fatalf("got %d repos, want 1", len(repos))
+	}
+	repo := repos[0]
+	if repo.Id != "org/model" || repo.Refs["main"] != testCommit {
+		t.Fatalf("got repo %s with refs %v", repo.Id, repo.Refs)
+	}
+	if repo.Size != 12 || repo.NbFiles != 2 {
+		t.Fatalf("got %d bytes in %d files, want 12 in 2", repo.Size, repo.NbFiles)
+	}
+	if len(repo.Revisions) != 1 || repo.Revisions[0].NbFiles != 2 {
+		t.Fatalf("got revisions %+v", repo.Revisions)
+	}
+
+	complete, err := client.IsSnapshotComplete(&Repo{Id: "org/model"}, "main", []string{"unet/*"})
+	if err != nil || !complete {
+		t.Fatalf("IsSnapshotComplete = %v, %v; want true", complete, err)
+	}
+}
+
+func TestMemFSCheckPointer(t *testing.T) {
+	client, storageFolder := memCache(t)
+	fsys := client.fs()
+	snapshotPath := filepath.Join(storageFolder, "snapshots", testCommit)
+	pointer := filepath.Join(snapshotPath, "config.json")
+
+	if !client.checkPointer(storageFolder, pointer) {
+		t.Fatal("valid pointer rejected")
+	}
+
+	// pointed outside the blobs folder, at a name still cached
+	fsys.Remove(pointer)
+	if err := fsys.Symlink("../../elsewhere/blob-config.json", pointer); err != nil {
+		t.Fatal(err)
+	}
+	if !client.checkPointer(storageFolder, pointer) {
+		t.Fatal("broken pointer to a cached blob not relinked")
+	}
+	target, err := fsys.Readlink(pointer)
+	if err != nil || !strings.HasPrefix(target, "../../blobs/") {
+		t.Fatalf("relinked to %q, %v", target, err)
+	}
+
+	// the blob is gone
+	fsys.Remove(filepath.Join(storageFolder, "blobs", "blob-model.safetensors"))
+	dangling := filepath.Join(snapshotPath, "unet", "model.safetensors")
+	if client.checkPointer(storageFolder, dangling) {
+		t.Fatal("dangling pointer accepted")
+	}
+	if _, err := fsys.Lstat(dangling); !os.IsNotExist(err) {
+		t.Fatalf("dangling pointer not removed: %v", err)
+	}
+
+	complete, err := client.IsSnapshotComplete(&Repo{Id: "org/model"}, "main", []string{"unet/*"})
+	if err != nil || complete {
+		t.Fatalf("IsSnapshotComplete = %v, %v; want false", complete, err)
+	}
+}
+
+func TestMemFSDeleteRevision(t *testing.T) {
+	client, storageFolder := memCache(t)
+
+	if err := client.DeleteRevision("org/model", ModelRepoType, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.fs().Stat(storageFolder); !os.IsNotExist(err) {
+		t.Fatalf("repo folder left behind: %v", err)
+	}
+	repos, err := client.ScanCache()
+	if err != nil || len(repos) != 0 {
+		t.Fatalf("ScanCache = %v, %v; want no repos", repos, err)
+	}
+}
+
+func TestMemFSDeleteRevisionKeepsBlobsInUse(t *testing.T) {
+	client, storageFolder := memCache(t)
+	fsys := client.fs()
+	blobsDir := filepath.Join(storageFolder, "blobs")
+
+	// another snapshot holds a real copy of a blob, as LinkMode places it
+	content := []byte("copied")
+	sum := sha256.Sum256(content)
+	copied := hex.EncodeToString(sum[:])
+	otherSnapshot := filepath.Join(storageFolder, "snapshots", "89abcdef0123456789abcdef0123456789abcdef")
+	if err := client.mkdirAll(otherSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string][]byte{
+		filepath.Join(blobsDir, copied):                   content,
+		filepath.Join(otherSnapshot, "weights.bin"):       content,
+		filepath.Join(blobsDir, "in-progress.incomplete"): []byte("partial"),
+	} {
+		if err := client.writeFile(path, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := client.deleteRevision("org/model", ModelRepoType, testCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{copied, "in-progress.incomplete"} {
+		if _, err := fsys.Stat(filepath.Join(blobsDir, name)); err != nil {
+			t.Fatalf("blob %s removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"blob-config.json", "blob-model.safetensors"} {
+		if _, err := fsys.Stat(filepath.Join(blobsDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("unused blob %s kept: %v", name, err)
+		}
+	}
+	if deleted.freed != 12 {
+		t.Fatalf("freed %d bytes, want 12", deleted.freed)
+	}
+}
@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// ErrChecksumMismatch is returned when a downloaded file doesn't hash to its
+// pinned checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type ChecksumFormat string
+
+const (
+	ChecksumsSHA256SUMS ChecksumFormat = "sha256sums"
+	ChecksumsJSON       ChecksumFormat = "json"
+
+	SHA256SUMSFileName     = "SHA256SUMS"
+	SHA256SUMSJSONFileName = "sha256sums.json"
+)
+
+type snapshotChecksums struct {
+	Algorithm string            `json:"algorithm"`
+	Revision  string            `json:"revision"`
+	Files     map[string]string `json:"files"`
+}
+
+// WriteChecksums hashes the given snapshot files (paths relative to the
+// snapshot folder) and writes them as a SHA256SUMS or JSON manifest into
+// destDir, which defaults to the snapshot folder. Returns the manifest path.
+func WriteChecksums(snapshotPath string, files []string, format ChecksumFormat, destDir string) (string, error) {
+	return (&Client{}).writeChecksums(snapshotPath, files, format, destDir)
+}
+
+func (client *Client) writeChecksums(snapshotPath string, files []string, format ChecksumFormat, destDir string) (string, error) {
+	if destDir == "" {
+		destDir = snapshotPath
+	}
+
+	fileName := SHA256SUMSFileName
+	if format == ChecksumsJSON {
+		fileName = SHA256SUMSJSONFileName
+	} else if format != ChecksumsSHA256SUMS {
+		return "", fmt.Errorf("unsupported checksum format: %s", format)
+	}
+
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+
+	sums := make(map[string]string, len(sorted))
+	for _, file := range sorted {
+		name := filepath.ToSlash(file)
+		// the manifest would replace the repo's own file in the snapshot
+		if destDir == snapshotPath && (name == SHA256SUMSFileName || name == SHA256SUMSJSONFileName) {
+			return "", fmt.Errorf("repo already contains a file named %s", name)
+		}
+
+		sum, err := sha256File(client.fs(), filepath.Join(snapshotPath, file))
+		if err != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", file, err)
+		}
+		sums[name] = sum
+	}
+
+	var data []byte
+	if format == ChecksumsJSON {
+		encoded, err := json.MarshalIndent(snapshotChecksums{
+			Algorithm: "sha256",
+			Revision:  filepath.Base(snapshotPath),
+			Files:     sums,
+		}, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode checksums: %w", err)
+		}
+		data = append(encoded, '\n')
+	} else {
+		var b strings.Builder
+		for _, file := range sorted {
+			name := filepath.ToSlash(file)
+			fmt.Fprintf(&b, "%s  %s\n", sums[name], name)
+		}
+		data = []byte(b.String())
+	}
+
+	if err := client.mkdirAll(destDir); err != nil {
+		return "", fmt.Errorf("failed to create checksum directory: %w", err)
+	}
+
+	manifestPath := filepath.Join(destDir, fileName)
+	if err := client.writeFile(manifestPath, data); err != nil {
+		return "", fmt.Errorf("failed to write checksums: %w", err)
+	}
+
+	return manifestPath, nil
+}
+
+func sha256File(fsys FS, path string) (string, error) {
+	f, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Checksum is an expected file hash, written as "sha256:<hex>" or
+// "blake3:<hex>". A bare hex string is a sha256.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+func ParseChecksum(s string) (*Checksum, error) {
+	algorithm, sum, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		algorithm, sum = "sha256", algorithm
+	}
+	algorithm = strings.ToLower(algorithm)
+	if algorithm != "sha256" && algorithm != "blake3" {
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+
+	decoded, err := hex.DecodeString(strings.ToLower(sum))
+	if err != nil || len(decoded) != 32 {
+		return nil, fmt.Errorf("invalid %s checksum: %q", algorithm, sum)
+	}
+	return &Checksum{Algorithm: algorithm, Sum: decoded}, nil
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Sum)
+}
+
+func (c *Checksum) newHash() hash.Hash {
+	if c.Algorithm == "blake3" {
+		return blake3.New(32, nil)
+	}
+	return sha256.New()
+}
+
+// verify compares the hash of a finished download with the checksum.
+func (c *Checksum) verify(h hash.Hash, name string) error {
+	if sum := h.Sum(nil); !bytes.Equal(sum, c.Sum) {
+		return fmt.Errorf("%w for %s: expected %s, got %s:%x", ErrChecksumMismatch, name, c, c.Algorithm, sum)
+	}
+	return nil
+}
+
+func verifyChecksumFile(fsys FS, c *Checksum, path string, name string) error {
+	f, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := c.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	return c.verify(h, name)
+}
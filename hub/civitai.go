@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var civitaiVersionPattern = regexp.MustCompile(`/api/download/models/(\d+)`)
+
+// CivitaiModelVersion is the part of Civitai's model version metadata that
+// model cards are built from. The sidecar keeps the full response.
+type CivitaiModelVersion struct {
+	ID           int      `json:"id"`
+	ModelID      int      `json:"modelId"`
+	Name         string   `json:"name"`
+	BaseModel    string   `json:"baseModel"`
+	TrainedWords []string `json:"trainedWords"`
+	Description  string   `json:"description"`
+	Model        struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"model"`
+	Images []struct {
+		URL  string `json:"url"`
+		Type string `json:"type"`
+	} `json:"images"`
+}
+
+// WithSidecar makes Download also write the model version metadata and a
+// preview image next to the file, see WriteSidecar.
+func (s *CivitaiSource) WithSidecar() *CivitaiSource {
+	s.sidecar = true
+	return s
+}
+
+// FetchMetadata gets the model version metadata from the Civitai API, along
+// with the raw JSON.
+func (s *CivitaiSource) FetchMetadata() (*CivitaiModelVersion, []byte, error) {
+	apiURL, err := civitaiVersionURL(s.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := s.get(apiURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch model version metadata: %w", err)
+	}
+
+	var version CivitaiModelVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse model version metadata: %w", err)
+	}
+	return &version, data, nil
+}
+
+// WriteSidecar stores the model version metadata as <name>.civitai.info and
+// the first preview image as <name>.preview.<ext> next to destPath, the names
+// the A1111 and ComfyUI model browsers look for.
+func (s *CivitaiSource) WriteSidecar(destPath string) error {
+	version, data, err := s.FetchMetadata()
+	if err != nil {
+		return err
+	}
+
+	stem := strings.TrimSuffix(destPath, filepath.Ext(destPath))
+	if err := writeFileAtomic(stem+".civitai.info", data); err != nil {
+		return fmt.Errorf("failed to write metadata sidecar: %w", err)
+	}
+
+	for _, image := range version.Images {
+		// previews can also be videos
+		if image.URL == "" || (image.Type != "" && image.Type != "image") {
+			continue
+		}
+		preview, err := s.get(image.URL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch preview image: %w", err)
+		}
+		ext := ".png"
+		if u, err := url.Parse(image.URL); err == nil && path.Ext(u.Path) != "" {
+			ext = strings.ToLower(path.Ext(u.Path))
+		}
+		if err := writeFileAtomic(stem+".preview"+ext, preview); err != nil {
+			return fmt.Errorf("failed to write preview image: %w", err)
+		}
+		break
+	}
+
+	return nil
+}
+
+// civitaiVersionURL maps a download URL to the version's API URL, e.g.
+// https://civitai.com/api/download/models/123?type=Model to
+// https://civitai.com/api/v1/model-versions/123.
+func civitaiVersionURL(downloadURL string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", err
+	}
+
+	id := u.Query().Get("modelVersionId")
+	if m := civitaiVersionPattern.FindStringSubmatch(u.Path); m != nil {
+		id = m[1]
+	}
+	if id == "" {
+		return "", fmt.Errorf("no model version id in %s", redactURL(u))
+	}
+	return fmt.Sprintf("%s://%s/api/v1/model-versions/%s", u.Scheme, u.Host, id), nil
+}
+
+// largest metadata or preview image read into memory
+const maxSidecarSize = 32 << 20
+
+func (s *CivitaiSource) get(rawURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the key is only for civitai.com, not the image CDN
+	if s.apiKey != "" && req.URL.Host == civitaiHost(s.url) {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := defaultHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSidecarSize))
+}
+
+func civitaiHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
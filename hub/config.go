@@ -0,0 +1,274 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultEndpoint = "https://huggingface.co"
+
+const defaultUserAgent = "huggingface-go/0.0.1"
+
+// Config collects every setting the client reads from the environment. Fields
+// can be overridden after LoadConfig before building a Client with NewClient.
+type Config struct {
+	Endpoint  string
+	Token     string
+	TokenPath string
+	HFHome    string
+	CacheDir  string
+	UserAgent string
+	Offline   bool
+	// DebugHTTP logs every hub request, see Client.DebugHTTP.
+	DebugHTTP bool
+	// NetrcPath is read for per-host credentials when no token is set.
+	NetrcPath string
+	// AssetsDir holds extracted archives and other derived files.
+	AssetsDir string
+	// MirrorEndpoint is fetched from in parallel with Endpoint for large blobs.
+	MirrorEndpoint string
+	// Proxy overrides HTTPS_PROXY/HTTP_PROXY/NO_PROXY, which are honored otherwise.
+	Proxy *url.URL
+	// MetadataTTL caches API answers on disk, see Client.MetadataTTL.
+	MetadataTTL time.Duration
+	// CacheIndex journals downloads to the cache index, see Client.CacheIndex.
+	CacheIndex bool
+	// Durability syncs cache writes to disk, see Client.Durability.
+	Durability Durability
+	// Transport customizes dialing, e.g. a custom resolver or PreferIPv4.
+	Transport *TransportOptions
+	// EtagTimeout and DownloadTimeout bound requests, see Client.EtagTimeout.
+	EtagTimeout     time.Duration
+	DownloadTimeout time.Duration
+	// LinkMode places real files in snapshots, see Client.LinkMode.
+	LinkMode MaterializeMode
+	// DisableTelemetry trims the User-Agent, see Client.DisableTelemetry.
+	DisableTelemetry bool
+	// Concurrency caps the transfers running at once, see Client.Limiter.
+	Concurrency int
+	// SafeTensorsOnly refuses pickle-based weights, see Client.SafeTensorsOnly.
+	SafeTensorsOnly bool
+	// AuditLog is a file every change to the cache is appended to, see AuditFile.
+	AuditLog string
+	// AutoOffline uses the cache while the hub is unreachable, see Client.AutoOffline.
+	AutoOffline bool
+	// ResolutionOrder is the sources tried for each call, see Client.ResolutionOrder.
+	ResolutionOrder []Source
+}
+
+// LoadConfig reads the huggingface_hub environment variables over the defaults
+// in the config file at HF_HUB_CONFIG, $XDG_CONFIG_HOME/hf-hub/config.yaml or
+// ~/.config/hf-hub/config.yaml, which sets endpoint, mirror_endpoint,
+// token_path, cache_dir, assets_dir, concurrency, proxy, safe_mode, offline,
+// etag_timeout, download_timeout, audit_log, auto_offline and
+// resolution_order as "key: value" lines. HF_HOME overrides
+// the file's paths, and HTTPS_PROXY/HTTP_PROXY its proxy. Precedence, highest first:
+//
+//	cache dir: HF_HUB_CACHE, HUGGINGFACE_HUB_CACHE, $HF_HOME/hub, cache_dir, $XDG_CACHE_HOME/huggingface/hub, ~/.cache/huggingface/hub
+//	HF home:   HF_HOME, $XDG_CACHE_HOME/huggingface, ~/.cache/huggingface
+//	assets:    HF_ASSETS_CACHE, $HF_HOME/assets
+//	endpoint:  HF_ENDPOINT, https://huggingface.co
+//	token:     HF_TOKEN, HUGGING_FACE_HUB_TOKEN, the file at HF_TOKEN_PATH or $HF_HOME/token
+//	offline:   HF_HUB_OFFLINE set to 1/true/yes/on
+//	debug:     HF_DEBUG set to 1/true/yes/on
+//	netrc:     NETRC, ~/.netrc (only used without a token)
+//	timeouts:  HF_HUB_ETAG_TIMEOUT, HF_HUB_DOWNLOAD_TIMEOUT in seconds
+//	symlinks:  HF_HUB_DISABLE_SYMLINKS set to 1/true/yes/on copies files into snapshots
+//	telemetry: HF_HUB_DISABLE_TELEMETRY or DO_NOT_TRACK set to 1/true/yes/on
+func LoadConfig() (*Config, error) {
+	hfHome, err := envHFHome()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Endpoint:         DefaultEndpoint,
+		HFHome:           hfHome,
+		CacheDir:         filepath.Join(hfHome, "hub"),
+		AssetsDir:        filepath.Join(hfHome, "assets"),
+		TokenPath:        filepath.Join(hfHome, "token"),
+		UserAgent:        defaultUserAgent,
+		Offline:          IsOfflineMode(),
+		DebugHTTP:        envBool("HF_DEBUG"),
+		DisableTelemetry: envBool("HF_HUB_DISABLE_TELEMETRY") || envBool("DO_NOT_TRACK"),
+		NetrcPath:        defaultNetrcPath(),
+	}
+
+	configPath := configFilePath()
+	entries, err := readConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyConfigFile(configPath, entries); err != nil {
+		return nil, err
+	}
+	cfg.Offline = cfg.Offline || IsOfflineMode()
+	if firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy") != "" {
+		cfg.Proxy = nil
+	}
+
+	if cacheDir := firstEnv("HF_HUB_CACHE", "HUGGINGFACE_HUB_CACHE"); cacheDir != "" {
+		cfg.CacheDir = cacheDir
+	}
+	if assetsDir := os.Getenv("HF_ASSETS_CACHE"); assetsDir != "" {
+		cfg.AssetsDir = assetsDir
+	}
+	if endpoint := os.Getenv("HF_ENDPOINT"); endpoint != "" {
+		cfg.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if tokenPath := os.Getenv("HF_TOKEN_PATH"); tokenPath != "" {
+		cfg.TokenPath = tokenPath
+	}
+
+	cfg.Token = firstEnv("HF_TOKEN", "HUGGING_FACE_HUB_TOKEN")
+	if cfg.Token == "" {
+		cfg.Token = readTokenFile(cfg.TokenPath)
+	}
+
+	if cfg.EtagTimeout, err = envSeconds("HF_HUB_ETAG_TIMEOUT", cfg.EtagTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.DownloadTimeout, err = envSeconds("HF_HUB_DOWNLOAD_TIMEOUT", cfg.DownloadTimeout); err != nil {
+		return nil, err
+	}
+	if envBool("HF_HUB_DISABLE_SYMLINKS") {
+		cfg.LinkMode = MaterializeCopy
+	}
+
+	return cfg, nil
+}
+
+// NewClient builds a Client from the config, creating the cache directory.
+func (cfg *Config) NewClient() (*Client, error) {
+	cacheDir, err := expandPath(cfg.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand cache directory: %w", err)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+
+	client := &Client{
+		Endpoint:         endpoint,
+		MirrorEndpoint:   strings.TrimSuffix(cfg.MirrorEndpoint, "/"),
+		Token:            cfg.Token,
+		CacheDir:         cacheDir,
+		UserAgent:        userAgent,
+		Offline:          cfg.Offline,
+		DebugHTTP:        cfg.DebugHTTP,
+		MetadataTTL:      cfg.MetadataTTL,
+		CacheIndex:       cfg.CacheIndex,
+		Durability:       cfg.Durability,
+		EtagTimeout:      cfg.EtagTimeout,
+		DownloadTimeout:  cfg.DownloadTimeout,
+		LinkMode:         cfg.LinkMode,
+		DisableTelemetry: cfg.DisableTelemetry,
+		SafeTensorsOnly:  cfg.SafeTensorsOnly,
+		AutoOffline:      cfg.AutoOffline,
+		ResolutionOrder:  cfg.ResolutionOrder,
+	}
+	if cfg.Concurrency > 0 {
+		client.Limiter = NewLimiter(cfg.Concurrency)
+	}
+	if cfg.AuditLog != "" {
+		auditLog, err := expandPath(cfg.AuditLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand audit log path: %w", err)
+		}
+		client.Audit = AuditFile(auditLog)
+	}
+
+	if client.Token == "" && cfg.NetrcPath != "" {
+		netrc, err := LoadNetrc(cfg.NetrcPath)
+		if err != nil {
+			return nil, err
+		}
+		client.Netrc = netrc
+	}
+
+	if cfg.Proxy != nil || cfg.Transport != nil {
+		opts := TransportOptions{}
+		if cfg.Transport != nil {
+			opts = *cfg.Transport
+		}
+		if cfg.Proxy != nil {
+			opts.Proxy = cfg.Proxy
+		}
+		client.HTTPClient = NewHTTPClient(&opts)
+	}
+
+	return client, nil
+}
+
+func envHFHome() (string, error) {
+	if hfHome := os.Getenv("HF_HOME"); hfHome != "" {
+		return expandPath(hfHome)
+	}
+
+	if xdgCache := os.Getenv("XDG_CACHE_HOME"); xdgCache != "" {
+		return filepath.Join(xdgCache, "huggingface"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	return filepath.Join(homeDir, ".cache", "huggingface"), nil
+}
+
+// envSeconds reads a timeout given in seconds, which may be fractional like
+// python's float(), or returns fallback when it is unset.
+func envSeconds(name string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	timeout, err := parseSeconds(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return timeout, nil
+}
+
+func envBool(name string) bool {
+	switch strings.ToUpper(strings.TrimSpace(os.Getenv(name))) {
+	case "1", "ON", "YES", "TRUE":
+		return true
+	}
+	return false
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func readTokenFile(path string) string {
+	tokenBytes, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	// clean token i.e. remove any trailing newlines and whitespace
+	return strings.TrimSpace(string(tokenBytes))
+}
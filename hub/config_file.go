@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEntry is a "key: value" line of a config file.
+type configEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// configFilePath is HF_HUB_CONFIG, or config.yaml under
+// $XDG_CONFIG_HOME/hf-hub or ~/.config/hf-hub.
+func configFilePath() string {
+	if path := os.Getenv("HF_HUB_CONFIG"); path != "" {
+		return path
+	}
+	if xdgConfig := os.Getenv("XDG_CONFIG_HOME"); xdgConfig != "" {
+		return filepath.Join(xdgConfig, "hf-hub", "config.yaml")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".config", "hf-hub", "config.yaml")
+}
+
+// readConfigFile parses the flat subset of YAML a config file is written in:
+// one "key: value" per line, values optionally quoted, "#" comments. A
+// missing file has no entries.
+func readConfigFile(path string) ([]configEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var entries []configEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"key: value\"", path, lineNo)
+		}
+		value, err := unquoteConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		entries = append(entries, configEntry{key: strings.TrimSpace(key), value: value, line: lineNo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// unquoteConfigValue strips quotes, or a trailing comment from a bare value.
+func unquoteConfigValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quote in %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quote in %s", value)
+		}
+		// YAML escapes a single quote by doubling it
+		return strings.ReplaceAll(value[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// applyConfigFile sets the config from the file's entries. Settings that
+// default to a path under HF_HOME keep that path when HF_HOME is set, so the
+// environment still overrides the file.
+func (cfg *Config) applyConfigFile(path string, entries []configEntry) error {
+	hfHomeSet := os.Getenv("HF_HOME") != ""
+
+	for _, entry := range entries {
+		var err error
+		switch entry.key {
+		case "endpoint":
+			cfg.Endpoint = strings.TrimSuffix(entry.value, "/")
+		case "mirror_endpoint":
+			cfg.MirrorEndpoint = strings.TrimSuffix(entry.value, "/")
+		case "token_path":
+			if !hfHomeSet {
+				cfg.TokenPath, err = expandPath(entry.value)
+			}
+		case "cache_dir":
+			if !hfHomeSet {
+				cfg.CacheDir, err = expandPath(entry.value)
+			}
+		case "assets_dir":
+			if !hfHomeSet {
+				cfg.AssetsDir, err = expandPath(entry.value)
+			}
+		case "concurrency":
+			cfg.Concurrency, err = strconv.Atoi(entry.value)
+			if err == nil && cfg.Concurrency < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "proxy":
+			cfg.Proxy, err = url.Parse(entry.value)
+		case "safe_mode":
+			cfg.SafeTensorsOnly, err = strconv.ParseBool(entry.value)
+		case "offline":
+			cfg.Offline, err = strconv.ParseBool(entry.value)
+		case "etag_timeout":
+			cfg.EtagTimeout, err = parseSeconds(entry.value)
+		case "download_timeout":
+			cfg.DownloadTimeout, err = parseSeconds(entry.value)
+		case "audit_log":
+			cfg.AuditLog = entry.value
+		case "auto_offline":
+			cfg.AutoOffline, err = strconv.ParseBool(entry.value)
+		case "resolution_order":
+			cfg.ResolutionOrder, err = ParseResolutionOrder(entry.value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, entry.line, entry.key, err)
+		}
+	}
+	return nil
+}
+
+// parseSeconds reads a timeout given in seconds, which may be fractional.
+func parseSeconds(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("expected seconds, got %q", value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
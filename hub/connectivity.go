@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrHubUnreachable is returned, and the cache used instead, when
+// Client.AutoOffline is set and the endpoint doesn't answer a probe.
+var ErrHubUnreachable = errors.New("hub is unreachable")
+
+const (
+	// how long a probe may take before the hub counts as unreachable
+	probeTimeout = 3 * time.Second
+	// how long a probe's answer is reused for the same endpoint
+	probeTTL = 30 * time.Second
+)
+
+type probeResult struct {
+	err error
+	at  time.Time
+}
+
+// probes caches the last probe of each endpoint, shared by all clients so
+// a burst of downloads probes once.
+var probes = struct {
+	sync.Mutex
+	results map[string]probeResult
+	group   singleflight.Group
+}{results: map[string]probeResult{}}
+
+// probeEndpoint reports whether the client's endpoint answers a HEAD request,
+// through the client's transport and so its proxy. Any HTTP status counts as
+// reachable; only failing to get one doesn't.
+func (client *Client) probeEndpoint() error {
+	endpoint := client.Endpoint
+
+	probes.Lock()
+	result, ok := probes.results[endpoint]
+	probes.Unlock()
+	if ok && time.Since(result.at) < probeTTL {
+		return result.err
+	}
+
+	v, _, _ := probes.group.Do(endpoint, func() (any, error) {
+		err := client.probe(endpoint)
+		if err != nil {
+			log.Printf("[Download] %s is unreachable, using the cache: %v", endpoint, err)
+		}
+		probes.Lock()
+		probes.results[endpoint] = probeResult{err: err, at: time.Now()}
+		probes.Unlock()
+		return err, nil
+	})
+	err, _ := v.(error)
+	return err
+}
+
+func (client *Client) probe(endpoint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHubUnreachable, err)
+	}
+	req.Header.Set("User-Agent", client.userAgent())
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHubUnreachable, err)
+	}
+	resp.Body.Close()
+	return nil
+}
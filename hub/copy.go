@@ -0,0 +1,46 @@
+package hub
+
+import (
+	"errors"
+	"io"
+)
+
+// countingReader hands every chunk read from a download body to count, so
+// the body can be moved with io.CopyBuffer while progress, speed checks and
+// the read watchdog still see each chunk.
+type countingReader struct {
+	r     io.Reader
+	count func(p []byte) error
+
+	// the last error reading the body, to tell it from errors of count or
+	// of writing
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	if n > 0 {
+		if cerr := c.count(p[:n]); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+// copyBody copies body into out through buf until EOF and returns the bytes
+// written. out's ReadFrom is hidden: *os.File has no fast path for an HTTP
+// body and would fall back to copying in 32KB pieces, which is slower than a
+// buffer tuned to the link.
+func copyBody(out io.Writer, body *countingReader, buf *copyBuffer) (int64, error) {
+	body.err = nil
+	return io.CopyBuffer(struct{ io.Writer }{out}, body, buf.bytes())
+}
+
+// readFailed reports whether err, returned by copyBody, came from reading the
+// body, which a reconnect may fix, rather than from count or writing.
+func (c *countingReader) readFailed(err error) bool {
+	return err != nil && c.err != nil && errors.Is(err, c.err)
+}
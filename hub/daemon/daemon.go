@@ -0,0 +1,494 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-vault/model-cache/hub"
+	"github.com/google/uuid"
+	"github.com/vbauerster/mpb/v7"
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotPending = errors.New("job is not pending")
+	// ErrCallbackNotAllowed is returned for a job CallbackURL whose host isn't
+	// in Options.CallbackHosts.
+	ErrCallbackNotAllowed = errors.New("callback URL not allowed")
+)
+
+type Options struct {
+	// QueuePath is where the job queue is persisted. Defaults to <CacheDir>/.daemon/queue.json.
+	QueuePath string
+	// Workers is the number of jobs processed concurrently. Defaults to 1.
+	Workers int
+	// MaxAttempts is how many times a job is tried before it is marked failed. Defaults to hub.DefaultRetries.
+	MaxAttempts int
+	// InitialBackoff and MaxBackoff bound the delay between attempts of a failing job.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// KeepFinished is how long completed, failed and canceled jobs stay in the
+	// queue, for Status and List, before they are pruned. Defaults to 24 hours.
+	KeepFinished time.Duration
+
+	// Callbacks are called and WebhookURLs receive a POST once a job completes or finally fails.
+	Callbacks   []func(Notification)
+	WebhookURLs []string
+	// CallbackHosts are the hosts a job's CallbackURL may point at. Empty
+	// refuses every CallbackURL, so clients can't make the daemon POST to
+	// arbitrary, possibly internal, addresses.
+	CallbackHosts []string
+}
+
+type Daemon struct {
+	client *hub.Client
+	opts   Options
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	subs  map[int]chan Job
+	subID int
+	// repos being deleted, by repoKey; their jobs wait until it is done
+	deleting map[string]bool
+
+	wake   chan struct{}
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+func New(client *hub.Client, opts *Options) (*Daemon, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.QueuePath == "" {
+		o.QueuePath = filepath.Join(client.CacheDir, ".daemon", "queue.json")
+	}
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = hub.DefaultRetries
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = 5 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Minute
+	}
+	if o.KeepFinished <= 0 {
+		o.KeepFinished = 24 * time.Hour
+	}
+
+	// the daemon has no terminal to draw on, so keep progress bars off stdout
+	c := *client
+	if c.Progress == nil {
+		c.Progress = mpb.New(mpb.WithOutput(nil))
+	}
+
+	d := &Daemon{
+		client:   &c,
+		opts:     o,
+		jobs:     make(map[string]*Job),
+		subs:     make(map[int]chan Job),
+		deleting: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+
+	jobs, err := loadQueue(o.QueuePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		// jobs that were running when the process stopped are picked up again
+		if job.State == JobRunning {
+			job.State = JobQueued
+			job.NextAttempt = time.Time{}
+		}
+		d.jobs[job.ID] = job
+		d.order = append(d.order, job.ID)
+	}
+	d.pruneLocked(time.Now())
+
+	return d, nil
+}
+
+// Start launches the workers. They run until ctx is cancelled or Stop is called.
+func (d *Daemon) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+
+	for i := 0; i < d.opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker(ctx)
+	}
+
+	d.notify()
+}
+
+// Stop signals the workers to exit, cancelling in-flight downloads, and waits
+// for them to return. Their jobs are queued again for the next Start.
+func (d *Daemon) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
+
+func (d *Daemon) Submit(req JobRequest) (Job, error) {
+	if req.Repo == "" {
+		return Job{}, fmt.Errorf("repo is required")
+	}
+	if req.RepoType == "" {
+		req.RepoType = hub.ModelRepoType
+	}
+	if req.Revision == "" {
+		req.Revision = hub.DefaultRevision
+	}
+	if req.CallbackURL != "" && !d.callbackAllowed(req.CallbackURL) {
+		return Job{}, fmt.Errorf("%w: %s", ErrCallbackNotAllowed, req.CallbackURL)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:         uuid.NewString(),
+		JobRequest: req,
+		State:      JobQueued,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	d.mu.Lock()
+	d.jobs[job.ID] = job
+	d.order = append(d.order, job.ID)
+	err := d.persistLocked()
+	snapshot := job.clone()
+	d.mu.Unlock()
+
+	if err != nil {
+		return Job{}, err
+	}
+
+	d.publish(snapshot)
+	d.notify()
+
+	return snapshot, nil
+}
+
+func (d *Daemon) Status(id string) (Job, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	job, ok := d.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job.clone(), nil
+}
+
+// List returns all known jobs in submission order.
+func (d *Daemon) List() []Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	jobs := make([]Job, 0, len(d.order))
+	for _, id := range d.order {
+		jobs = append(jobs, d.jobs[id].clone())
+	}
+	return jobs
+}
+
+// Cancel removes a queued job from the work queue. Running jobs cannot be cancelled.
+func (d *Daemon) Cancel(id string) (Job, error) {
+	d.mu.Lock()
+	job, ok := d.jobs[id]
+	if !ok {
+		d.mu.Unlock()
+		return Job{}, ErrJobNotFound
+	}
+	if job.State != JobQueued {
+		d.mu.Unlock()
+		return Job{}, ErrJobNotPending
+	}
+
+	job.State = JobCanceled
+	job.UpdatedAt = time.Now()
+	err := d.persistLocked()
+	snapshot := job.clone()
+	d.mu.Unlock()
+
+	d.publish(snapshot)
+	return snapshot, err
+}
+
+// ListCached and CacheStats use the client's cache index when it keeps one.
+func (d *Daemon) ListCached() ([]hub.CachedRepo, error) {
+	return d.client.ScanCache()
+}
+
+func (d *Daemon) CacheStats() (*hub.CacheStats, error) {
+	return d.client.CacheStats()
+}
+
+// DuplicateBlobs reports the blobs cached under more than one repo. It always
+// walks the cache, as the index doesn't keep blob hashes.
+func (d *Daemon) DuplicateBlobs() (*hub.DedupReport, error) {
+	return d.client.FindDuplicateBlobs()
+}
+
+// Delete removes a cached revision. It refuses while a job for the same repo
+// is running, and holds back the repo's queued jobs until it is done.
+func (d *Daemon) Delete(repoId, repoType, revision string) error {
+	if repoType == "" {
+		repoType = hub.ModelRepoType
+	}
+	key := repoKey(repoId, repoType)
+
+	d.mu.Lock()
+	for _, job := range d.jobs {
+		if job.State == JobRunning && job.Repo == repoId && job.RepoType == repoType {
+			d.mu.Unlock()
+			return fmt.Errorf("cannot delete %s while job %s is downloading it", repoId, job.ID)
+		}
+	}
+	if d.deleting[key] {
+		d.mu.Unlock()
+		return fmt.Errorf("cannot delete %s while another delete of it is running", repoId)
+	}
+	d.deleting[key] = true
+	d.mu.Unlock()
+
+	err := d.client.DeleteRevision(repoId, repoType, revision)
+
+	d.mu.Lock()
+	delete(d.deleting, key)
+	d.mu.Unlock()
+	d.notify()
+
+	return err
+}
+
+func repoKey(repoId, repoType string) string {
+	return repoType + "/" + repoId
+}
+
+// Subscribe returns a channel receiving every job state change. Call the returned
+// function to unsubscribe. Slow subscribers miss updates rather than block workers.
+func (d *Daemon) Subscribe() (<-chan Job, func()) {
+	ch := make(chan Job, 64)
+
+	d.mu.Lock()
+	id := d.subID
+	d.subID++
+	d.subs[id] = ch
+	d.mu.Unlock()
+
+	return ch, func() {
+		d.mu.Lock()
+		if _, ok := d.subs[id]; ok {
+			delete(d.subs, id)
+			close(ch)
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Daemon) publish(job Job) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, ch := range d.subs {
+		select {
+		case ch <- job:
+		default:
+		}
+	}
+}
+
+func (d *Daemon) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Daemon) worker(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		job, wait := d.claim()
+		if job != nil {
+			// let another idle worker look for more work
+			d.notify()
+			d.run(ctx, job)
+			continue
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-d.wake:
+		case <-due:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// claim marks the next runnable job as running. If nothing is runnable it
+// returns how long until the earliest delayed retry becomes due.
+func (d *Daemon) claim() (*Job, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+
+	for _, id := range d.order {
+		job := d.jobs[id]
+		if job.State != JobQueued || d.deleting[repoKey(job.Repo, job.RepoType)] {
+			continue
+		}
+
+		if job.NextAttempt.After(now) {
+			if until := job.NextAttempt.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		job.State = JobRunning
+		job.Attempts++
+		job.UpdatedAt = now
+		job.StartedAt = now
+		if err := d.persistLocked(); err != nil {
+			log.Printf("[Daemon] Failed to persist job queue: %v", err)
+		}
+		return job, 0
+	}
+
+	return nil, wait
+}
+
+func (d *Daemon) run(ctx context.Context, job *Job) {
+	d.mu.Lock()
+	params := &hub.DownloadParams{
+		Repo: &hub.Repo{
+			Id:       job.Repo,
+			Type:     job.RepoType,
+			Revision: job.Revision,
+		},
+		FileName:       job.FileName,
+		SubFolder:      job.SubFolder,
+		Revision:       job.Revision,
+		ForceDownload:  job.ForceDownload,
+		AllowPatterns:  append([]string(nil), job.AllowPatterns...),
+		IgnorePatterns: append([]string(nil), job.IgnorePatterns...),
+		Context:        ctx,
+	}
+	started := job.clone()
+	d.mu.Unlock()
+
+	d.publish(started)
+
+	log.Printf("[Daemon] Starting job %s (%s, attempt %d)", job.ID, job.Repo, started.Attempts)
+	path, summary, err := d.client.DownloadWithSummary(params)
+
+	d.mu.Lock()
+	job.UpdatedAt = time.Now()
+	job.Bytes += summary.Bytes
+	if err == nil {
+		job.State = JobCompleted
+		job.Path = path
+		job.Error = ""
+	} else if ctx.Err() != nil {
+		// stopped rather than failed, so the attempt doesn't count
+		job.State = JobQueued
+		job.Attempts--
+		job.NextAttempt = time.Time{}
+	} else {
+		job.Error = err.Error()
+		if job.Attempts >= d.opts.MaxAttempts {
+			job.State = JobFailed
+		} else {
+			job.State = JobQueued
+			job.NextAttempt = job.UpdatedAt.Add(d.retryDelay(job.Attempts))
+		}
+	}
+	if perr := d.persistLocked(); perr != nil {
+		log.Printf("[Daemon] Failed to persist job queue: %v", perr)
+	}
+	finished := job.clone()
+	d.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		log.Printf("[Daemon] Job %s stopped, it will resume on the next start", job.ID)
+	} else if err != nil {
+		log.Printf("[Daemon] Job %s failed (attempt %d/%d): %v", job.ID, finished.Attempts, d.opts.MaxAttempts, err)
+	} else {
+		log.Printf("[Daemon] Job %s completed: %s", job.ID, path)
+	}
+
+	d.publish(finished)
+
+	if finished.Done() {
+		d.notifyFinished(finished)
+	}
+}
+
+func (d *Daemon) retryDelay(attempts int) time.Duration {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = d.opts.InitialBackoff
+	b.MaxInterval = d.opts.MaxBackoff
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	delay := b.InitialInterval
+	for i := 0; i < attempts; i++ {
+		delay = b.NextBackOff()
+	}
+	return delay
+}
+
+// pruneLocked drops finished jobs last updated more than KeepFinished ago.
+func (d *Daemon) pruneLocked(now time.Time) {
+	order := d.order[:0]
+	for _, id := range d.order {
+		job := d.jobs[id]
+		if job.Done() && now.Sub(job.UpdatedAt) > d.opts.KeepFinished {
+			delete(d.jobs, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	d.order = order
+}
+
+func (d *Daemon) persistLocked() error {
+	d.pruneLocked(time.Now())
+
+	jobs := make([]*Job, 0, len(d.order))
+	for _, id := range d.order {
+		jobs = append(jobs, d.jobs[id])
+	}
+	return saveQueue(d.opts.QueuePath, jobs)
+}
@@ -0,0 +1,175 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-vault/model-cache/hub/daemon"
+	"github.com/go-vault/model-cache/hub/daemon/grpcapi/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	cachepb.UnimplementedCacheServiceServer
+	daemon *daemon.Daemon
+}
+
+func NewServer(d *daemon.Daemon) *Server {
+	return &Server{daemon: d}
+}
+
+// Register attaches the cache service to a grpc.Server.
+func Register(s *grpc.Server, d *daemon.Daemon) {
+	cachepb.RegisterCacheServiceServer(s, NewServer(d))
+}
+
+func (s *Server) SubmitDownload(ctx context.Context, req *cachepb.SubmitDownloadRequest) (*cachepb.Job, error) {
+	job, err := s.daemon.Submit(daemon.JobRequest{
+		Repo:           req.GetRepo(),
+		RepoType:       req.GetRepoType(),
+		Revision:       req.GetRevision(),
+		FileName:       req.GetFileName(),
+		SubFolder:      req.GetSubFolder(),
+		AllowPatterns:  req.GetAllowPatterns(),
+		IgnorePatterns: req.GetIgnorePatterns(),
+		ForceDownload:  req.GetForceDownload(),
+		CallbackURL:    req.GetCallbackUrl(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toProtoJob(job), nil
+}
+
+func (s *Server) GetStatus(ctx context.Context, req *cachepb.GetStatusRequest) (*cachepb.Job, error) {
+	job, err := s.daemon.Status(req.GetJobId())
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return toProtoJob(job), nil
+}
+
+func (s *Server) ListCached(ctx context.Context, req *cachepb.ListCachedRequest) (*cachepb.ListCachedResponse, error) {
+	repos, err := s.daemon.ListCached()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &cachepb.ListCachedResponse{}
+	for _, repo := range repos {
+		pbRepo := &cachepb.CachedRepo{
+			Id:       repo.Id,
+			RepoType: repo.Type,
+			Path:     repo.Path,
+			Size:     repo.Size,
+			NbFiles:  int32(repo.NbFiles),
+		}
+		for _, rev := range repo.Revisions {
+			pbRepo.Revisions = append(pbRepo.Revisions, &cachepb.CachedRevision{
+				CommitHash: rev.CommitHash,
+				Path:       rev.Path,
+				Size:       rev.Size,
+				NbFiles:    int32(rev.NbFiles),
+				Refs:       rev.Refs,
+			})
+		}
+		resp.Repos = append(resp.Repos, pbRepo)
+	}
+
+	return resp, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	if req.GetRepo() == "" || req.GetRevision() == "" {
+		return nil, status.Error(codes.InvalidArgument, "repo and revision are required")
+	}
+
+	if err := s.daemon.Delete(req.GetRepo(), req.GetRepoType(), req.GetRevision()); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &cachepb.DeleteResponse{}, nil
+}
+
+func (s *Server) StreamProgress(req *cachepb.StreamProgressRequest, stream grpc.ServerStreamingServer[cachepb.Job]) error {
+	updates, unsubscribe := s.daemon.Subscribe()
+	defer unsubscribe()
+
+	jobID := req.GetJobId()
+	if jobID != "" {
+		// send the current state first so late subscribers don't wait for the next change
+		job, err := s.daemon.Status(jobID)
+		if err != nil {
+			return toStatusError(err)
+		}
+		if err := stream.Send(toProtoJob(job)); err != nil {
+			return err
+		}
+		if job.Done() {
+			return nil
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case job, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			if jobID != "" && job.ID != jobID {
+				continue
+			}
+			if err := stream.Send(toProtoJob(job)); err != nil {
+				return err
+			}
+			if jobID != "" && job.Done() {
+				return nil
+			}
+		}
+	}
+}
+
+func toProtoJob(job daemon.Job) *cachepb.Job {
+	return &cachepb.Job{
+		Id:            job.ID,
+		Repo:          job.Repo,
+		RepoType:      job.RepoType,
+		Revision:      job.Revision,
+		FileName:      job.FileName,
+		State:         toProtoState(job.State),
+		Attempts:      int32(job.Attempts),
+		Error:         job.Error,
+		Path:          job.Path,
+		CreatedAtUnix: job.CreatedAt.Unix(),
+		UpdatedAtUnix: job.UpdatedAt.Unix(),
+	}
+}
+
+func toProtoState(state daemon.JobState) cachepb.JobState {
+	switch state {
+	case daemon.JobQueued:
+		return cachepb.JobState_JOB_STATE_QUEUED
+	case daemon.JobRunning:
+		return cachepb.JobState_JOB_STATE_RUNNING
+	case daemon.JobCompleted:
+		return cachepb.JobState_JOB_STATE_COMPLETED
+	case daemon.JobFailed:
+		return cachepb.JobState_JOB_STATE_FAILED
+	case daemon.JobCanceled:
+		return cachepb.JobState_JOB_STATE_CANCELED
+	}
+	return cachepb.JobState_JOB_STATE_UNSPECIFIED
+}
+
+func toStatusError(err error) error {
+	if errors.Is(err, daemon.ErrJobNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,244 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-vault/model-cache/hub/daemon"
+)
+
+// Middleware wraps the API handler, e.g. to authenticate requests.
+type Middleware func(http.Handler) http.Handler
+
+type Options struct {
+	// Prefix is stripped from request paths so the API can be mounted under e.g. "/cache".
+	Prefix string
+	// Middleware is applied in order, the first entry being the outermost.
+	Middleware []Middleware
+}
+
+type handler struct {
+	daemon *daemon.Daemon
+}
+
+// NewHandler returns an http.Handler exposing the daemon:
+//
+//	GET    /models                              list cached repos and revisions
+//	DELETE /models?repo=&repo_type=&revision=   delete a cached revision
+//	GET    /stats                               cache totals
+//	GET    /duplicates                          blobs cached under several repos
+//	GET    /downloads                           list download jobs
+//	POST   /downloads                           queue a download (daemon.JobRequest body)
+//	GET    /downloads/{id}                      job status
+//	DELETE /downloads/{id}                      cancel a queued job
+//	GET    /downloads/{id}/events               job updates as server-sent events
+func NewHandler(d *daemon.Daemon, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	h := &handler{daemon: d}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", h.listCached)
+	mux.HandleFunc("DELETE /models", h.deleteRevision)
+	mux.HandleFunc("GET /stats", h.stats)
+	mux.HandleFunc("GET /duplicates", h.duplicates)
+	mux.HandleFunc("GET /downloads", h.listJobs)
+	mux.HandleFunc("POST /downloads", h.submit)
+	mux.HandleFunc("GET /downloads/{id}", h.status)
+	mux.HandleFunc("DELETE /downloads/{id}", h.cancel)
+	mux.HandleFunc("GET /downloads/{id}/events", h.events)
+
+	var root http.Handler = mux
+	if opts.Prefix != "" {
+		root = http.StripPrefix(strings.TrimSuffix(opts.Prefix, "/"), root)
+	}
+
+	for i := len(opts.Middleware) - 1; i >= 0; i-- {
+		root = opts.Middleware[i](root)
+	}
+
+	return root
+}
+
+// BearerAuth rejects requests that don't carry "Authorization: Bearer <token>".
+func BearerAuth(token string) Middleware {
+	expected := []byte("Bearer " + token)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, expected) != 1 {
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h *handler) listCached(w http.ResponseWriter, r *http.Request) {
+	repos, err := h.daemon.ListCached()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func (h *handler) deleteRevision(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	repo, revision := query.Get("repo"), query.Get("revision")
+	if repo == "" || revision == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("repo and revision are required"))
+		return
+	}
+
+	if err := h.daemon.Delete(repo, query.Get("repo_type"), revision); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.daemon.CacheStats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (h *handler) duplicates(w http.ResponseWriter, r *http.Request) {
+	report, err := h.daemon.DuplicateBlobs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (h *handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.daemon.List())
+}
+
+func (h *handler) submit(w http.ResponseWriter, r *http.Request) {
+	var req daemon.JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	job, err := h.daemon.Submit(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	job, err := h.daemon.Status(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *handler) cancel(w http.ResponseWriter, r *http.Request) {
+	job, err := h.daemon.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *handler) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	updates, unsubscribe := h.daemon.Subscribe()
+	defer unsubscribe()
+
+	id := r.PathValue("id")
+	job, err := h.daemon.Status(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeEvent(w, job); err != nil || job.Done() {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if update.ID != id {
+				continue
+			}
+			if err := writeEvent(w, update); err != nil {
+				return
+			}
+			flusher.Flush()
+			if update.Done() {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, job daemon.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.State, data)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[API] Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daemon.ErrJobNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, daemon.ErrJobNotPending):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// JobRequest describes what to download. An empty FileName queues a snapshot download.
+type JobRequest struct {
+	Repo           string   `json:"repo"`
+	RepoType       string   `json:"repo_type,omitempty"`
+	Revision       string   `json:"revision,omitempty"`
+	FileName       string   `json:"file_name,omitempty"`
+	SubFolder      string   `json:"sub_folder,omitempty"`
+	AllowPatterns  []string `json:"allow_patterns,omitempty"`
+	IgnorePatterns []string `json:"ignore_patterns,omitempty"`
+	ForceDownload  bool     `json:"force_download,omitempty"`
+	// CallbackURL receives a JSON Notification when the job completes or
+	// fails. Its host must be in the daemon's Options.CallbackHosts.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type Job struct {
+	ID string `json:"id"`
+	JobRequest
+
+	State    JobState `json:"state"`
+	Attempts int      `json:"attempts"`
+	Error    string   `json:"error,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	// Bytes counts the bytes all attempts transferred, not cache hits.
+	Bytes       int64     `json:"bytes,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+func (j *Job) Done() bool {
+	return j.State == JobCompleted || j.State == JobFailed || j.State == JobCanceled
+}
+
+func (j *Job) clone() Job {
+	c := *j
+	c.AllowPatterns = append([]string(nil), j.AllowPatterns...)
+	c.IgnorePatterns = append([]string(nil), j.IgnorePatterns...)
+	return c
+}
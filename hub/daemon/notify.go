@@ -0,0 +1,127 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Notification is sent to callbacks and webhooks once a job completes or finally fails.
+type Notification struct {
+	JobID      string   `json:"job_id"`
+	Repo       string   `json:"repo"`
+	RepoType   string   `json:"repo_type"`
+	Revision   string   `json:"revision"`
+	FileName   string   `json:"file_name,omitempty"`
+	State      JobState `json:"state"`
+	Path       string   `json:"path,omitempty"`
+	Bytes      int64    `json:"bytes"`
+	DurationMs int64    `json:"duration_ms"`
+	Attempts   int      `json:"attempts"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func newNotification(job Job) Notification {
+	n := Notification{
+		JobID:    job.ID,
+		Repo:     job.Repo,
+		RepoType: job.RepoType,
+		Revision: job.Revision,
+		FileName: job.FileName,
+		State:    job.State,
+		Path:     job.Path,
+		Bytes:    job.Bytes,
+		Attempts: job.Attempts,
+		Error:    job.Error,
+	}
+
+	if !job.StartedAt.IsZero() {
+		n.DurationMs = job.UpdatedAt.Sub(job.StartedAt).Milliseconds()
+	}
+
+	return n
+}
+
+func (d *Daemon) notifyFinished(job Job) {
+	n := newNotification(job)
+
+	for _, callback := range d.opts.Callbacks {
+		callback(n)
+	}
+
+	urls := append([]string(nil), d.opts.WebhookURLs...)
+	if job.CallbackURL != "" {
+		// checked again, as the queue may predate the allowlist
+		if d.callbackAllowed(job.CallbackURL) {
+			urls = append(urls, job.CallbackURL)
+		} else {
+			log.Printf("[Daemon] Not calling back %s for job %s: host not in CallbackHosts", job.CallbackURL, n.JobID)
+		}
+	}
+
+	for _, url := range urls {
+		go func(url string) {
+			if err := postWebhook(url, n); err != nil {
+				log.Printf("[Daemon] Failed to deliver webhook for job %s to %s: %v", n.JobID, url, err)
+			}
+		}(url)
+	}
+}
+
+func postWebhook(url string, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 2 * time.Minute
+	b.InitialInterval = 1 * time.Second
+	b.MaxInterval = 30 * time.Second
+
+	return backoff.Retry(func() error {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+		if resp.StatusCode >= 400 {
+			return backoff.Permanent(fmt.Errorf("webhook returned status %d", resp.StatusCode))
+		}
+		return nil
+	}, b)
+}
+
+// callbackAllowed reports whether rawURL is an http(s) URL on one of
+// Options.CallbackHosts.
+func (d *Daemon) callbackAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	for _, host := range d.opts.CallbackHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
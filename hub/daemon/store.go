@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type queueFile struct {
+	Jobs []*Job `json:"jobs"`
+}
+
+func loadQueue(path string) ([]*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read job queue: %w", err)
+	}
+
+	var queue queueFile
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return nil, fmt.Errorf("failed to parse job queue: %w", err)
+	}
+
+	return queue.Jobs, nil
+}
+
+func saveQueue(path string, jobs []*Job) error {
+	data, err := json.MarshalIndent(queueFile{Jobs: jobs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job queue: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated queue
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write job queue: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move job queue into place: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+)
+
+// PartialDownloadError is returned when a snapshot download stops at its
+// Deadline. Files in Completed are in the cache under SnapshotPath.
+type PartialDownloadError struct {
+	SnapshotPath string
+	Completed    []string
+	Remaining    []string
+	Err          error
+}
+
+func (e *PartialDownloadError) Error() string {
+	total := len(e.Completed) + len(e.Remaining)
+	return fmt.Sprintf("snapshot download incomplete, %d of %d files done: %v", len(e.Completed), total, e.Err)
+}
+
+func (e *PartialDownloadError) Unwrap() error {
+	return e.Err
+}
+
+// context returns the context bounding the whole call: Context, carrying the
+// Deadline once Download has set it up.
+func (params *DownloadParams) context() context.Context {
+	if params.ctx != nil {
+		return params.ctx
+	}
+	if params.Context != nil {
+		return params.Context
+	}
+	return context.Background()
+}
+
+// fileContext bounds a single file by PerFileTimeout.
+func (params *DownloadParams) fileContext() (context.Context, context.CancelFunc) {
+	if params.PerFileTimeout > 0 {
+		return context.WithTimeout(params.context(), params.PerFileTimeout)
+	}
+	return context.WithCancel(params.context())
+}
@@ -0,0 +1,176 @@
+package hub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DedupReport lists the blobs cached under more than one repo, such as a VAE
+// or text encoder shared by several pipelines, and the space a blob store
+// shared between repos would reclaim by keeping a single copy of each.
+type DedupReport struct {
+	Duplicates []DuplicateBlob `json:"duplicates"`
+	// bytes of every blob in the cache
+	TotalSize int64 `json:"total_size"`
+	// bytes held more than once, freed by sharing the blobs
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// DuplicateBlob is one content hash stored in several repos' blobs folders.
+// Copies counts the distinct files on disk; blobs already hardlinked together
+// count once and reclaim nothing.
+type DuplicateBlob struct {
+	Hash        string       `json:"hash"`
+	Size        int64        `json:"size"`
+	Copies      int          `json:"copies"`
+	Reclaimable int64        `json:"reclaimable"`
+	Repos       []BlobHolder `json:"repos"`
+}
+
+// BlobHolder is a repo holding a copy of a duplicate blob, with the snapshot
+// files that point at it.
+type BlobHolder struct {
+	RepoId   string   `json:"repo"`
+	RepoType string   `json:"repo_type"`
+	Path     string   `json:"path"`
+	Files    []string `json:"files,omitempty"`
+}
+
+// FindDuplicateBlobs walks the cache directory and reports the blobs stored
+// under more than one repo. Blobs are named after their content hash, the
+// sha256 of LFS files and the git oid of the others, so identical files share
+// a name and size without being read again.
+func FindDuplicateBlobs(cacheDir string) (*DedupReport, error) {
+	return (&Client{CacheDir: cacheDir}).FindDuplicateBlobs()
+}
+
+// FindDuplicateBlobs is FindDuplicateBlobs for the client's cache.
+func (client *Client) FindDuplicateBlobs() (*DedupReport, error) {
+	cacheDir := client.CacheDir
+	entries, err := client.fs().ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &DedupReport{}, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	type copyOf struct {
+		holder BlobHolder
+		info   os.FileInfo
+	}
+	type blobKey struct {
+		hash string
+		size int64
+	}
+
+	report := &DedupReport{}
+	copies := make(map[blobKey][]copyOf)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		repoId, repoType, ok := parseRepoFolderName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		storageFolder := filepath.Join(cacheDir, entry.Name())
+		blobs, _ := client.fs().ReadDir(filepath.Join(storageFolder, "blobs"))
+		for _, blob := range blobs {
+			info, err := blob.Info()
+			if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(blob.Name(), ".incomplete") {
+				continue
+			}
+			report.TotalSize += info.Size()
+
+			key := blobKey{hash: blob.Name(), size: info.Size()}
+			copies[key] = append(copies[key], copyOf{
+				holder: BlobHolder{
+					RepoId:   repoId,
+					RepoType: repoType,
+					Path:     filepath.Join(storageFolder, "blobs", blob.Name()),
+				},
+				info: info,
+			})
+		}
+	}
+
+	// snapshot files by blob, walked once per repo holding a duplicate
+	filesByBlob := make(map[string]map[string][]string)
+	for key, held := range copies {
+		if len(held) < 2 {
+			continue
+		}
+
+		duplicate := DuplicateBlob{Hash: key.hash, Size: key.size}
+		var distinct []os.FileInfo
+		for _, c := range held {
+			shared := false
+			for _, info := range distinct {
+				if os.SameFile(info, c.info) {
+					shared = true
+					break
+				}
+			}
+			if !shared {
+				distinct = append(distinct, c.info)
+			}
+
+			storageFolder := filepath.Dir(filepath.Dir(c.holder.Path))
+			if _, ok := filesByBlob[storageFolder]; !ok {
+				filesByBlob[storageFolder] = client.snapshotFilesByBlob(storageFolder)
+			}
+			c.holder.Files = filesByBlob[storageFolder][c.holder.Path]
+			duplicate.Repos = append(duplicate.Repos, c.holder)
+		}
+		duplicate.Copies = len(distinct)
+		duplicate.Reclaimable = int64(len(distinct)-1) * key.size
+		report.Reclaimable += duplicate.Reclaimable
+
+		sort.Slice(duplicate.Repos, func(i, j int) bool {
+			return duplicate.Repos[i].Path < duplicate.Repos[j].Path
+		})
+		report.Duplicates = append(report.Duplicates, duplicate)
+	}
+
+	// largest savings first
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		a, b := report.Duplicates[i], report.Duplicates[j]
+		if a.Reclaimable != b.Reclaimable {
+			return a.Reclaimable > b.Reclaimable
+		}
+		return a.Hash < b.Hash
+	})
+
+	return report, nil
+}
+
+// snapshotFilesByBlob maps the blobs of a repo to the snapshot files that
+// point at them, as <commit>/<file>.
+func (client *Client) snapshotFilesByBlob(storageFolder string) map[string][]string {
+	blobsDir := filepath.Join(storageFolder, "blobs")
+	snapshotsDir := filepath.Join(storageFolder, "snapshots")
+	resolvedBlobsDir, err := evalSymlinks(client.fs(), blobsDir)
+	if err != nil {
+		return nil
+	}
+
+	files := make(map[string][]string)
+	client.walkSnapshot(snapshotsDir, func(path string, target string, _ os.FileInfo) {
+		if filepath.Dir(target) != resolvedBlobsDir {
+			return
+		}
+		blobPath := filepath.Join(blobsDir, filepath.Base(target))
+		if rel, err := filepath.Rel(snapshotsDir, path); err == nil {
+			files[blobPath] = append(files[blobPath], filepath.ToSlash(rel))
+		}
+	})
+	for _, names := range files {
+		sort.Strings(names)
+	}
+	return files
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RevisionDiff is what changed in a repo from one revision to another.
+// Files are matched by path; a file whose etag or size differs is Changed.
+type RevisionDiff struct {
+	From    string
+	To      string
+	Added   []TreeEntry
+	Removed []TreeEntry
+	Changed []FileChange
+}
+
+// FileChange is a file present in both revisions with different content.
+type FileChange struct {
+	Path string
+	From TreeEntry
+	To   TreeEntry
+}
+
+// Fetch lists the added and changed files, the ones a download of To
+// transfers when From is cached.
+func (d *RevisionDiff) Fetch() []string {
+	files := make([]string, 0, len(d.Added)+len(d.Changed))
+	for _, entry := range d.Added {
+		files = append(files, entry.Path)
+	}
+	for _, change := range d.Changed {
+		files = append(files, change.Path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// FetchSize is the bytes of the files Fetch lists.
+func (d *RevisionDiff) FetchSize() int64 {
+	var size int64
+	for _, entry := range d.Added {
+		size += entry.FileSize()
+	}
+	for _, change := range d.Changed {
+		size += change.To.FileSize()
+	}
+	return size
+}
+
+// DiffRevisions compares the files of repo at two revisions, branches, tags
+// or commits, from their tree listings, without downloading either.
+func (client *Client) DiffRevisions(repo *Repo, revA string, revB string) (*RevisionDiff, error) {
+	var trees [2][]TreeEntry
+	var g errgroup.Group
+	for i, revision := range []string{revA, revB} {
+		g.Go(func() error {
+			var err error
+			if isCommitHash(revision) {
+				trees[i], err = cachedRepoTree(client, repo, revision)
+			} else {
+				trees[i], err = listRepoTree(client, repo, revision)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", revision, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	diff := &RevisionDiff{From: revA, To: revB}
+	from := make(map[string]TreeEntry, len(trees[0]))
+	for _, entry := range trees[0] {
+		from[entry.Path] = entry
+	}
+	for _, entry := range trees[1] {
+		old, ok := from[entry.Path]
+		delete(from, entry.Path)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, entry)
+		case treeEntryETag(old) != treeEntryETag(entry) || old.FileSize() != entry.FileSize():
+			diff.Changed = append(diff.Changed, FileChange{Path: entry.Path, From: old, To: entry})
+		}
+	}
+	for _, entry := range from {
+		diff.Removed = append(diff.Removed, entry)
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Path < diff.Added[j].Path })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Path < diff.Changed[j].Path })
+	return diff, nil
+}
@@ -0,0 +1,156 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Discussion is a discussion or pull request on a repo.
+type Discussion struct {
+	Num           int
+	Title         string
+	Status        string // "open", "closed", "merged" or "draft"
+	Author        string
+	IsPullRequest bool
+	NumComments   int
+	CreatedAt     time.Time
+}
+
+// GitReference is the revision a pull request's changes are pushed to and
+// can be downloaded from, e.g. "refs/pr/12". Discussions have none.
+func (d *Discussion) GitReference() string {
+	if !d.IsPullRequest {
+		return ""
+	}
+	return fmt.Sprintf("refs/pr/%d", d.Num)
+}
+
+// DiscussionFilter narrows ListDiscussions. The zero value lists every open
+// and closed discussion and pull request.
+type DiscussionFilter struct {
+	Type   string // "discussion" or "pull_request"; empty for both
+	Status string // "open" or "closed"; empty for both
+	Author string
+}
+
+// discussionResponse is a discussion as the API sends it.
+type discussionResponse struct {
+	Num    int    `json:"num"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Author struct {
+		Name string `json:"name"`
+	} `json:"author"`
+	IsPullRequest bool      `json:"isPullRequest"`
+	NumComments   int       `json:"numComments"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (r *discussionResponse) discussion() Discussion {
+	return Discussion{
+		Num:           r.Num,
+		Title:         r.Title,
+		Status:        r.Status,
+		Author:        r.Author.Name,
+		IsPullRequest: r.IsPullRequest,
+		NumComments:   r.NumComments,
+		CreatedAt:     r.CreatedAt,
+	}
+}
+
+// ListDiscussions lists the discussions and pull requests of a repo, newest
+// first, following the API's pages.
+func (client *Client) ListDiscussions(repo *Repo, filter *DiscussionFilter) ([]Discussion, error) {
+	query := url.Values{}
+	if filter != nil {
+		if filter.Type != "" {
+			query.Set("type", filter.Type)
+		}
+		if filter.Status != "" {
+			query.Set("status", filter.Status)
+		}
+		if filter.Author != "" {
+			query.Set("author", filter.Author)
+		}
+	}
+
+	var discussions []Discussion
+	for page := 0; ; page++ {
+		query.Set("p", strconv.Itoa(page))
+		var result struct {
+			Discussions []discussionResponse `json:"discussions"`
+			Count       int                  `json:"count"`
+		}
+		if err := apiRequest(client, "GET", discussionsURL(client, repo)+"?"+query.Encode(), nil, &result); err != nil {
+			return nil, fmt.Errorf("failed to list discussions of %s: %w", repo.Id, err)
+		}
+		for i := range result.Discussions {
+			discussions = append(discussions, result.Discussions[i].discussion())
+		}
+		if len(result.Discussions) == 0 || len(discussions) >= result.Count {
+			return discussions, nil
+		}
+	}
+}
+
+// GetDiscussion fetches a single discussion or pull request by number.
+func (client *Client) GetDiscussion(repo *Repo, num int) (*Discussion, error) {
+	var result discussionResponse
+	if err := apiRequest(client, "GET", fmt.Sprintf("%s/%d", discussionsURL(client, repo), num), nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to get discussion %d of %s: %w", num, repo.Id, err)
+	}
+	discussion := result.discussion()
+	return &discussion, nil
+}
+
+// CreateDiscussion opens a discussion on a repo. description is markdown.
+func (client *Client) CreateDiscussion(repo *Repo, title string, description string) (*Discussion, error) {
+	return createDiscussion(client, repo, title, description, false)
+}
+
+// CreatePullRequest opens a draft pull request on a repo. Changes are pushed
+// to the returned discussion's GitReference.
+func (client *Client) CreatePullRequest(repo *Repo, title string, description string) (*Discussion, error) {
+	return createDiscussion(client, repo, title, description, true)
+}
+
+func createDiscussion(client *Client, repo *Repo, title string, description string, pullRequest bool) (*Discussion, error) {
+	if client.Token == "" {
+		return nil, fmt.Errorf("creating a discussion on %s requires a token", repo.Id)
+	}
+
+	body := struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		PullRequest bool   `json:"pullRequest"`
+	}{title, description, pullRequest}
+	var result struct {
+		Num int `json:"num"`
+	}
+	if err := apiRequest(client, "POST", discussionsURL(client, repo), body, &result); err != nil {
+		return nil, fmt.Errorf("failed to create discussion on %s: %w", repo.Id, err)
+	}
+
+	return client.GetDiscussion(repo, result.Num)
+}
+
+// CommentDiscussion adds a markdown comment to a discussion or pull request.
+func (client *Client) CommentDiscussion(repo *Repo, num int, comment string) error {
+	if client.Token == "" {
+		return fmt.Errorf("commenting on %s requires a token", repo.Id)
+	}
+
+	body := struct {
+		Comment string `json:"comment"`
+	}{comment}
+	if err := apiRequest(client, "POST", fmt.Sprintf("%s/%d/comment", discussionsURL(client, repo), num), body, nil); err != nil {
+		return fmt.Errorf("failed to comment on discussion %d of %s: %w", num, repo.Id, err)
+	}
+	return nil
+}
+
+func discussionsURL(client *Client, repo *Repo) string {
+	return fmt.Sprintf("%s/api/%s/%s/discussions", client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id)
+}
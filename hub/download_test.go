@@ -0,0 +1,286 @@
+package hub_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-vault/model-cache/hub"
+	"github.com/go-vault/model-cache/hub/hubtest"
+)
+
+var (
+	testConfig  = []byte(`{"model_type": "test"}`)
+	testWeights = bytes.Repeat([]byte("weights!"), 4096)
+)
+
+func newTestServer(t *testing.T) (*hubtest.Server, *hub.Client) {
+	t.Helper()
+	srv := hubtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddRepo(hub.ModelRepoType, "org/model", map[string][]byte{
+		"config.json":            testConfig,
+		"model.safetensors":      testWeights,
+		"tokenizer/vocab.json":   []byte(`{"a": 0}`),
+		"unet/model.safetensors": testWeights[:1024],
+	})
+	return srv, srv.NewClient(t.TempDir())
+}
+
+func assertFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s has %d bytes, want %d", path, len(got), len(want))
+	}
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadFile(t *testing.T) {
+	srv, client := newTestServer(t)
+
+	path, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "config.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, testConfig)
+
+	// a second call is served from the cache
+	before := srv.Requests()["GET /org/model/resolve/main/config.json"]
+	if _, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "config.json",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if after := srv.Requests()["GET /org/model/resolve/main/config.json"]; after != before {
+		t.Fatalf("cached file fetched again")
+	}
+}
+
+func TestDownloadLFSRedirect(t *testing.T) {
+	srv, client := newTestServer(t)
+
+	path, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "model.safetensors",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, testWeights)
+
+	sha := sha256Hex(testWeights)
+	if srv.Requests()["GET /lfs/"+sha] == 0 {
+		t.Fatalf("LFS redirect not followed, requests: %v", srv.Requests())
+	}
+	// the blob is named after the LFS sha256
+	blob, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(blob) != sha {
+		t.Fatalf("blob named %s, want %s", filepath.Base(blob), sha)
+	}
+}
+
+func TestDownloadLFSFromCDN(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.StartCDN()
+	client.Token = "hf_secret"
+
+	path, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "model.safetensors",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, testWeights)
+
+	if got := srv.RequestHeader("HEAD /org/model/resolve/main/model.safetensors").Get("Authorization"); got != "Bearer hf_secret" {
+		t.Fatalf("hub request sent Authorization %q", got)
+	}
+	cdn := srv.RequestHeader("GET /lfs/" + sha256Hex(testWeights))
+	if cdn == nil {
+		t.Fatalf("file not fetched from the CDN, requests: %v", srv.Requests())
+	}
+	if got := cdn.Get("Authorization"); got != "" {
+		t.Fatalf("token sent to the CDN: %q", got)
+	}
+}
+
+func TestSnapshotDownload(t *testing.T) {
+	_, client := newTestServer(t)
+
+	snapshotPath, err := client.Download(&hub.DownloadParams{
+		Repo:          &hub.Repo{Id: "org/model"},
+		AllowPatterns: []string{"*.json", "unet/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(snapshotPath, "config.json"), testConfig)
+	assertFile(t, filepath.Join(snapshotPath, "tokenizer", "vocab.json"), []byte(`{"a": 0}`))
+	assertFile(t, filepath.Join(snapshotPath, "unet", "model.safetensors"), testWeights[:1024])
+	if _, err := os.Stat(filepath.Join(snapshotPath, "model.safetensors")); !os.IsNotExist(err) {
+		t.Fatalf("file outside the allow patterns downloaded: %v", err)
+	}
+
+	complete, err := client.IsSnapshotComplete(&hub.Repo{Id: "org/model"}, "main", []string{"*.json", "unet/"})
+	if err != nil || !complete {
+		t.Fatalf("IsSnapshotComplete = %v, %v; want true", complete, err)
+	}
+}
+
+// rangeRecorder records the Range header of every request.
+type rangeRecorder struct {
+	mu     sync.Mutex
+	ranges []string
+	next   http.RoundTripper
+}
+
+func (r *rangeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if value := req.Header.Get("Range"); value != "" {
+		r.mu.Lock()
+		r.ranges = append(r.ranges, value)
+		r.mu.Unlock()
+	}
+	return r.next.RoundTrip(req)
+}
+
+func TestDownloadResume(t *testing.T) {
+	_, client := newTestServer(t)
+	recorder := &rangeRecorder{next: client.HTTPClient.Transport}
+	client.HTTPClient.Transport = recorder
+
+	// an interrupted download left the first half behind
+	sha := sha256Hex(testWeights)
+	blobsDir := filepath.Join(client.CacheDir, "models--org--model", "blobs")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	half := len(testWeights) / 2
+	if err := os.WriteFile(filepath.Join(blobsDir, sha+".incomplete"), testWeights[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "model.safetensors",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, testWeights)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	want := "bytes=" + strconv.Itoa(half) + "-"
+	for _, r := range recorder.ranges {
+		if r == want {
+			return
+		}
+	}
+	t.Fatalf("no %q request, got %v", want, recorder.ranges)
+}
+
+// afterAPI calls fn once the first hub API response, the repo info of a
+// snapshot download, has arrived.
+type afterAPI struct {
+	once sync.Once
+	fn   func()
+	next http.RoundTripper
+}
+
+func (a *afterAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := a.next.RoundTrip(req)
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		a.once.Do(a.fn)
+	}
+	return resp, err
+}
+
+func TestSnapshotDownloadRateLimited(t *testing.T) {
+	srv, client := newTestServer(t)
+	// the files' requests are limited, and retried within the budget
+	client.HTTPClient.Transport = &afterAPI{fn: func() { srv.RateLimit(2) }, next: client.HTTPClient.Transport}
+
+	snapshotPath, err := client.Download(&hub.DownloadParams{
+		Repo:          &hub.Repo{Id: "org/model"},
+		AllowPatterns: []string{"config.json"},
+		RetryBudget:   &hub.RetryBudget{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(snapshotPath, "config.json"), testConfig)
+}
+
+func TestDownloadRateLimited(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.RateLimit(1)
+
+	_, err := client.Download(&hub.DownloadParams{
+		Repo:     &hub.Repo{Id: "org/model"},
+		FileName: "config.json",
+	})
+	var httpErr *hub.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429 HTTPError", err)
+	}
+	if kind := hub.ErrorKindOf(err); kind != hub.ErrorKindNetwork {
+		t.Fatalf("got error kind %s, want %s", kind, hub.ErrorKindNetwork)
+	}
+}
+
+func TestResolutionOrderSkipsPartialSnapshot(t *testing.T) {
+	srv, client := newTestServer(t)
+	if _, err := client.Download(&hub.DownloadParams{
+		Repo:          &hub.Repo{Id: "org/model"},
+		AllowPatterns: []string{"config.json"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheOnly := client.WithResolutionOrder(hub.SourceCache)
+	if _, err := cacheOnly.Download(&hub.DownloadParams{
+		Repo:          &hub.Repo{Id: "org/model"},
+		AllowPatterns: []string{"config.json"},
+	}); err != nil {
+		t.Fatalf("cached file not served: %v", err)
+	}
+	if _, err := cacheOnly.Download(&hub.DownloadParams{Repo: &hub.Repo{Id: "org/model"}}); err == nil {
+		t.Fatal("partial snapshot served as the whole repo")
+	}
+
+	// the origin fills in the rest
+	before := srv.Requests()["GET /lfs/"+sha256Hex(testWeights)]
+	snapshotPath, err := client.WithResolutionOrder(hub.SourceCache, hub.SourceOrigin).Download(&hub.DownloadParams{Repo: &hub.Repo{Id: "org/model"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(snapshotPath, "model.safetensors"), testWeights)
+	if srv.Requests()["GET /lfs/"+sha256Hex(testWeights)] == before {
+		t.Fatal("missing file not fetched from the origin")
+	}
+}
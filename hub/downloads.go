@@ -2,13 +2,19 @@
 package hub
 
 import (
+   "context"
    "fmt"
+   "hash"
    "io"
+   "mime"
    "net/http"
    "net/url"
    "os"
+   "path"
    "path/filepath"
    "regexp"
+   "strconv"
+   "strings"
    "time"
    "net"
    "sync"
@@ -33,16 +39,36 @@ type FileInfo struct {
 type CivitaiSource struct {
    url       string
    apiKey    string
+   sidecar   bool
+   connections int
+   limiter   *Limiter
    progressMu sync.Mutex
 }
 
 type DirectURLSource struct {
    url       string
+   checksum  string
+   connections int
+   limiter   *Limiter
    progressMu sync.Mutex
 }
 
 func NewCivitaiSource(url string, apiKey string) *CivitaiSource {
-   return &CivitaiSource{url: url, apiKey: apiKey}
+   return &CivitaiSource{url: url, apiKey: apiKey, connections: defaultSourceConnections}
+}
+
+// WithConnections sets how many ranges of a large file are fetched at once;
+// 1 downloads over a single connection.
+func (s *CivitaiSource) WithConnections(n int) *CivitaiSource {
+   s.connections = n
+   return s
+}
+
+// WithLimiter makes each connection of the download wait for a slot of l,
+// usually the Client.Limiter shared with hub downloads.
+func (s *CivitaiSource) WithLimiter(l *Limiter) *CivitaiSource {
+   s.limiter = l
+   return s
 }
 
 func (s *CivitaiSource) GetFileInfo() (*FileInfo, error) {
@@ -110,40 +136,141 @@ func (s *CivitaiSource) Download(destPath string, progress *mpb.Progress) error
    b.InitialInterval = 1 * time.Second
    b.MaxInterval = 30 * time.Second
 
-   return backoff.Retry(func() error {
-       if err := downloadWithResume(s.url, destPath, tmpPath, s.apiKey, progress, &s.progressMu); err != nil {
+   err := backoff.Retry(func() error {
+       if err := downloadChunked(s.url, destPath, tmpPath, s.apiKey, s.connections, s.limiter, progress, &s.progressMu, nil); err != nil {
            log.Printf("[Download] Retry error: %v", err)
            return err
        }
        return nil
    }, b)
+   if err != nil {
+       return err
+   }
+
+   // the model is usable without its card, so a failed sidecar doesn't fail the download
+   if s.sidecar {
+       if err := s.WriteSidecar(destPath); err != nil {
+           log.Printf("[Download] Failed to write Civitai sidecar for %s: %v", filepath.Base(destPath), err)
+       }
+   }
+   return nil
 }
 
 func NewDirectURLSource(url string) *DirectURLSource {
-   return &DirectURLSource{url: url}
+   return &DirectURLSource{url: url, connections: defaultSourceConnections}
+}
+
+// WithConnections sets how many ranges of a large file are fetched at once;
+// 1 downloads over a single connection.
+func (s *DirectURLSource) WithConnections(n int) *DirectURLSource {
+   s.connections = n
+   return s
+}
+
+// WithLimiter makes each connection of the download wait for a slot of l,
+// usually the Client.Limiter shared with hub downloads.
+func (s *DirectURLSource) WithLimiter(l *Limiter) *DirectURLSource {
+   s.limiter = l
+   return s
 }
 
+// WithChecksum pins the file's hash, "sha256:<hex>" or "blake3:<hex>". It is
+// computed as the bytes arrive and a mismatch fails Download without retries.
+func (s *DirectURLSource) WithChecksum(checksum string) *DirectURLSource {
+   s.checksum = checksum
+   return s
+}
+
+// GetFileInfo probes the URL with HEAD, or a one-byte ranged GET for servers
+// that reject HEAD, for the size, the URL after redirects and the
+// Content-Disposition file name.
 func (s *DirectURLSource) GetFileInfo() (*FileInfo, error) {
-   return &FileInfo{
-       URL: s.url,
-       Filename: filepath.Base(s.url),
-   }, nil
+   resp, err := probeURL("HEAD", s.url)
+   if err != nil || resp.StatusCode >= 400 {
+       if err == nil {
+           resp.Body.Close()
+       }
+       resp, err = probeURL("GET", s.url)
+       if err != nil {
+           return nil, fmt.Errorf("failed to probe %s: %w", s.url, err)
+       }
+   }
+   defer resp.Body.Close()
+
+   if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+       return nil, fmt.Errorf("failed to probe %s: %w", s.url, newHTTPError(resp))
+   }
+
+   info := &FileInfo{
+       URL: resp.Request.URL.String(),
+       Size: -1,
+       Filename: path.Base(resp.Request.URL.Path),
+   }
+
+   if resp.StatusCode == http.StatusPartialContent {
+       // Content-Range: bytes 0-0/12345
+       if i := strings.LastIndex(resp.Header.Get("Content-Range"), "/"); i >= 0 {
+           if size, err := strconv.ParseInt(resp.Header.Get("Content-Range")[i+1:], 10, 64); err == nil {
+               info.Size = size
+           }
+       }
+   } else if resp.Header.Get("Content-Encoding") == "" {
+       info.Size = resp.ContentLength
+   }
+
+   if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+       info.Filename = filepath.Base(params["filename"])
+   }
+   if info.Filename == "" || info.Filename == "/" || info.Filename == "." {
+       info.Filename = filepath.Base(s.url)
+   }
+
+   return info, nil
+}
+
+func probeURL(method, url string) (*http.Response, error) {
+   req, err := http.NewRequest(method, url, nil)
+   if err != nil {
+       return nil, err
+   }
+   req.Header.Set("Accept-Encoding", "identity")
+   if method == "GET" {
+       req.Header.Set("Range", "bytes=0-0")
+   }
+   return defaultHTTPClient.Do(req)
 }
 
 func (s *DirectURLSource) Download(destPath string, progress *mpb.Progress) error {
    tmpPath := destPath + ".tmp"
-   
+
+   var checksum *Checksum
+   if s.checksum != "" {
+       parsed, err := ParseChecksum(s.checksum)
+       if err != nil {
+           return err
+       }
+       checksum = parsed
+   }
+
    b := backoff.NewExponentialBackOff()
    b.MaxElapsedTime = 5 * time.Minute
    b.InitialInterval = 1 * time.Second
    b.MaxInterval = 30 * time.Second
 
    return backoff.Retry(func() error { 
-       return downloadWithResume(s.url, destPath, tmpPath, "", progress, &s.progressMu)
+       return downloadChunked(s.url, destPath, tmpPath, "", s.connections, s.limiter, progress, &s.progressMu, checksum)
    }, b)
 }
 
-func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Progress, progressMu *sync.Mutex) error {
+// downloadWithResume appends to tmpPath and moves it to destPath when done. A
+// checksum is checked over the whole file, including resumed bytes.
+func downloadWithResume(url, destPath, tmpPath, apiKey string, limiter *Limiter, progress *mpb.Progress, progressMu *sync.Mutex, checksum *Checksum) error {
+   release, err := limiter.acquire(context.Background())
+   if err != nil {
+       return err
+   }
+   defer release()
+
    var initialSize int64 = 0
    if info, err := os.Stat(tmpPath); err == nil {
        initialSize = info.Size()
@@ -183,6 +310,7 @@ func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Pro
    if apiKey != "" {
        req.Header.Set("Authorization", "Bearer " + apiKey)
    }
+   req.Header.Set("Accept-Encoding", "identity")
 
    if initialSize > 0 {
        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", initialSize))
@@ -208,17 +336,25 @@ func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Pro
        } else {
            log.Printf("resume failed with status %d", resp.StatusCode)
            fmt.Printf("resume failed with status (fmt) %d", resp.StatusCode)
-           return fmt.Errorf("resume failed with status %d", resp.StatusCode)
+           return fmt.Errorf("resume failed: %w", newHTTPError(resp))
        }
    } else {
        if resp.StatusCode != http.StatusOK {
            log.Printf("download failed with status %d", resp.StatusCode)
            fmt.Printf("download failed with status (fmt) %d", resp.StatusCode)
-           return fmt.Errorf("download failed with status %d", resp.StatusCode)
+           return fmt.Errorf("download failed: %w", newHTTPError(resp))
        }
        totalSize = resp.ContentLength
    }
 
+   if err := decodeBody(resp); err != nil {
+       return backoff.Permanent(err)
+   }
+   if resp.ContentLength < 0 {
+       // decoded, so the compressed length no longer applies
+       totalSize = 0
+   }
+
    progressMu.Lock()
    bar := progress.AddBar(totalSize,
        mpb.BarRemoveOnComplete(),
@@ -238,6 +374,16 @@ func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Pro
        bar.SetCurrent(initialSize)
    }
 
+   var hasher hash.Hash
+   if checksum != nil {
+       hasher = checksum.newHash()
+       if initialSize > 0 {
+           if err := hashPrefix(hasher, tmpPath, initialSize); err != nil {
+               return fmt.Errorf("failed to hash partial download: %w", err)
+           }
+       }
+   }
+
    downloadedSize := initialSize
    lastUpdate := time.Now()
    stallTimer := time.Duration(0)
@@ -245,35 +391,34 @@ func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Pro
    reader := bar.ProxyReader(resp.Body)
    defer reader.Close()
 
-   buf := make([]byte, 32*1024)
+   buf := newAdaptiveBuffer()
 
-   for {
-       n, err := reader.Read(buf)
-       if n > 0 {
-           if _, werr := out.Write(buf[:n]); werr != nil {
-               return fmt.Errorf("write failed: %w", werr)
-           }
+   body := &countingReader{r: reader, count: func(p []byte) error {
+       if hasher != nil {
+           hasher.Write(p)
+       }
+       buf.record(len(p))
 
-           downloadedSize += int64(n)
-
-           now := time.Now()
-           if now.Sub(lastUpdate) > 30*time.Second {
-               stallTimer += now.Sub(lastUpdate)
-               if stallTimer > 2*time.Minute {
-                   return fmt.Errorf("download stalled for too long")
-               }
-           } else {
-               stallTimer = 0
-               lastUpdate = now
+       now := time.Now()
+       if now.Sub(lastUpdate) > 30*time.Second {
+           stallTimer += now.Sub(lastUpdate)
+           if stallTimer > 2*time.Minute {
+               return fmt.Errorf("download stalled for too long")
            }
+       } else {
+           stallTimer = 0
+           lastUpdate = now
        }
+       return nil
+   }}
 
-       if err == io.EOF {
-           break
-       }
-       if err != nil {
-           return fmt.Errorf("read failed: %w", err)
-       }
+   n, err := copyBody(out, body, buf)
+   downloadedSize += n
+   if body.readFailed(err) {
+       return fmt.Errorf("read failed: %w", err)
+   }
+   if err != nil {
+       return err
    }
 
    if totalSize > 0 && downloadedSize != totalSize {
@@ -282,9 +427,28 @@ func downloadWithResume(url, destPath, tmpPath, apiKey string, progress *mpb.Pro
 
    out.Close()
 
+   if hasher != nil {
+       if err := checksum.verify(hasher, filepath.Base(destPath)); err != nil {
+           // the bytes on disk are wrong, so resuming from them can't help
+           discardPartial(tmpPath)
+           return backoff.Permanent(err)
+       }
+   }
+
    if err := os.Rename(tmpPath, destPath); err != nil {
        return fmt.Errorf("failed to move file: %w", err)
    }
+   os.Remove(tmpPath + ".source")
 
    return nil
-}
\ No newline at end of file
+}
+
+func hashPrefix(h hash.Hash, path string, size int64) error {
+   f, err := os.Open(path)
+   if err != nil {
+       return err
+   }
+   defer f.Close()
+   _, err = io.CopyN(h, f, size)
+   return err
+}
@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Durability controls whether cache writes are flushed to disk before they
+// become visible.
+type Durability int
+
+const (
+	// DurabilityDefault renames finished blobs into place and leaves flushing
+	// to the OS. After a power loss a blob can exist with missing content.
+	DurabilityDefault Durability = iota
+	// DurabilityFsync syncs blobs and refs and their directories before and
+	// after the rename, so a crash leaves either the complete file or none.
+	DurabilityFsync
+)
+
+func (client *Client) durable() bool {
+	return client.Durability == DurabilityFsync
+}
+
+// finalizeBlob moves a downloaded and verified file into blobs/.
+func (client *Client) finalizeBlob(tmpPath string, blobPath string) error {
+	if client.durable() {
+		if err := client.syncFile(tmpPath); err != nil {
+			return err
+		}
+	}
+	if err := client.fs().Rename(tmpPath, blobPath); err != nil {
+		return err
+	}
+	if client.durable() {
+		return client.syncDir(filepath.Dir(blobPath))
+	}
+	return nil
+}
+
+// writeRef points refs/<revision> at commitHash. The ref is replaced by a
+// rename, so readers never see a partly written hash.
+func (client *Client) writeRef(storageFolder string, revision string, commitHash string) error {
+	refPath, err := refFilePath(storageFolder, revision)
+	if err != nil {
+		return err
+	}
+	client.clearRefPath(storageFolder, refPath)
+	if err := client.mkdirAll(filepath.Dir(refPath)); err != nil {
+		return err
+	}
+
+	tmpPath := refTempPath(refPath)
+	if err := client.writeFile(tmpPath, []byte(commitHash)); err != nil {
+		return err
+	}
+	if client.durable() {
+		if err := client.syncFile(tmpPath); err != nil {
+			client.fs().Remove(tmpPath)
+			return err
+		}
+	}
+	if err := client.fs().Rename(tmpPath, refPath); err != nil {
+		client.fs().Remove(tmpPath)
+		return err
+	}
+	if client.durable() {
+		return client.syncDir(filepath.Dir(refPath))
+	}
+	return nil
+}
+
+func (client *Client) syncFile(path string) error {
+	f, err := client.fs().OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir persists the renames inside dir. Windows can't sync directories
+// and commits renames with the file system journal.
+func (client *Client) syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := client.fs().OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,47 @@
+package hub
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrContentEncoded is returned when a proxy compresses a file response even
+// though identity encoding was requested, and the body can't be decoded.
+var ErrContentEncoded = errors.New("response is content-encoded")
+
+// decodeBody makes sure only the file's own bytes reach the blob. A full gzip
+// response is decompressed on the fly; a compressed range response can't be
+// appended to a partial file, so it is rejected.
+func decodeBody(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: %s on a range response", ErrContentEncoded, encoding)
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", encoding, err)
+		}
+		resp.Body = &gzipBody{Reader: gz, body: resp.Body}
+		resp.ContentLength = -1
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrContentEncoded, encoding)
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,204 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// HTTPError is a failed hub or CDN request. RequestID is the server's
+// X-Request-Id (or the CDN's X-Amz-Cf-Id), which HF support can look up.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	RequestID  string
+	// ErrorCode is the hub's X-Error-Code, e.g. "RepoNotFound"
+	ErrorCode string
+	// Message is the error the server sent in the body, if any
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request id: " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Unwrap maps the hub's error code to ErrRepoNotFound and friends, so
+// errors.Is works on any failed request.
+func (e *HTTPError) Unwrap() error {
+	switch e.ErrorCode {
+	case "RepoNotFound":
+		return ErrRepoNotFound
+	case "RevisionNotFound":
+		return ErrRevisionNotFound
+	case "EntryNotFound":
+		return ErrEntryNotFound
+	case "GatedRepo":
+		return ErrGatedRepo
+	}
+	return nil
+}
+
+// newHTTPError builds an HTTPError from a failed response, reading at most a
+// short error message from the body.
+func newHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+		ErrorCode:  resp.Header.Get("X-Error-Code"),
+		Message:    resp.Header.Get("X-Error-Message"),
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Amz-Cf-Id")
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = redactURL(resp.Request.URL)
+	}
+
+	if e.Message == "" && resp.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		var payload struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+			e.Message = payload.Error
+		} else {
+			e.Message = strings.TrimSpace(string(body))
+		}
+	}
+
+	return e
+}
+
+// ErrorKind is the likely cause of a failed file, so callers can react
+// differently, e.g. ask for a token on ErrorKindAuth and retry later on
+// ErrorKindNetwork.
+type ErrorKind string
+
+const (
+	// 401 or 403 from the hub, or a gated repo
+	ErrorKindAuth    ErrorKind = "auth"
+	// a repo, revision or file that doesn't exist, or a 404
+	ErrorKindNotFound ErrorKind = "not_found"
+	// connection failures, timeouts, dropped streams and 429/5xx responses
+	ErrorKindNetwork ErrorKind = "network"
+	// failed writes, renames and full disks in the cache
+	ErrorKindDisk    ErrorKind = "disk"
+	ErrorKindOther   ErrorKind = "other"
+)
+
+// FileStage is the step a file failed at.
+type FileStage string
+
+const (
+	// resolving the file's commit, etag and size, before any transfer
+	StageMetadata FileStage = "metadata"
+	StageDownload FileStage = "download"
+)
+
+// FileError is a file that failed to download. Snapshot downloads return it
+// wrapped, and several of them joined when all files are attempted; FileErrors
+// collects them.
+type FileError struct {
+	FileName string
+	Stage    FileStage
+	Kind     ErrorKind
+	Err      error
+}
+
+func newFileError(fileName string, stage FileStage, err error) *FileError {
+	return &FileError{FileName: fileName, Stage: stage, Kind: classifyError(err), Err: err}
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s of %s failed (%s): %v", e.Stage, e.FileName, e.Kind, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// FileErrors returns every FileError in err, including those joined by
+// errors.Join.
+func FileErrors(err error) []*FileError {
+	var found []*FileError
+	var walk func(err error)
+	walk = func(err error) {
+		for err != nil {
+			if fileErr, ok := err.(*FileError); ok {
+				found = append(found, fileErr)
+				return
+			}
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, e := range joined.Unwrap() {
+					walk(e)
+				}
+				return
+			}
+			err = errors.Unwrap(err)
+		}
+	}
+	walk(err)
+	return found
+}
+
+// ErrorKindOf returns the likely cause of a failed call: the Kind of the first
+// FileError in err, or else the kind of err itself.
+func ErrorKindOf(err error) ErrorKind {
+	if fileErrs := FileErrors(err); len(fileErrs) > 0 {
+		return fileErrs[0].Kind
+	}
+	return classifyError(err)
+}
+
+func classifyError(err error) ErrorKind {
+	if errors.Is(err, ErrGatedRepo) {
+		return ErrorKindAuth
+	}
+	// the hub answers 401 for repos that don't exist, to not reveal private ones
+	if errors.Is(err, ErrRepoNotFound) || errors.Is(err, ErrRevisionNotFound) || errors.Is(err, ErrEntryNotFound) {
+		return ErrorKindNotFound
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch {
+		case httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden:
+			return ErrorKindAuth
+		case httpErr.StatusCode == http.StatusNotFound:
+			return ErrorKindNotFound
+		case httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500:
+			return ErrorKindNetwork
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, ErrDownloadTooSlow) || errors.Is(err, ErrDownloadTimeout) {
+		return ErrorKindNetwork
+	}
+
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, ErrQuotaExceeded) {
+		return ErrorKindDisk
+	}
+
+	return ErrorKindOther
+}
@@ -0,0 +1,119 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var (
+	ErrRepoNotFound     = errors.New("repository not found")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrEntryNotFound    = errors.New("file not found")
+	// ErrGatedRepo is returned when the repo exists but the token has no access to it.
+	ErrGatedRepo = errors.New("gated repository")
+)
+
+// RepoExists reports whether the repo exists and is visible with the client's
+// token. Private repos without access look like missing ones, gated repos exist.
+func (client *Client) RepoExists(repo *Repo) (bool, error) {
+	infoURL := fmt.Sprintf("%s/api/%s/%s", client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id)
+
+	err := checkExists(client, "GET", infoURL)
+	switch {
+	case err == nil, errors.Is(err, ErrGatedRepo):
+		return true, nil
+	case errors.Is(err, ErrRepoNotFound):
+		return false, nil
+	}
+	return false, err
+}
+
+// RevisionExists reports whether a branch, tag or commit exists in the repo.
+// A missing repo is reported as ErrRepoNotFound.
+func (client *Client) RevisionExists(repo *Repo, revision string) (bool, error) {
+	infoURL := fmt.Sprintf("%s/api/%s/%s/revision/%s",
+		client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id, url.PathEscape(revision))
+
+	err := checkExists(client, "GET", infoURL)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrRevisionNotFound):
+		return false, nil
+	}
+	return false, err
+}
+
+// FileExists reports whether the file exists at revision (repo.Revision, or
+// main, when empty) with a single HEAD request. A missing repo or revision is
+// reported as ErrRepoNotFound or ErrRevisionNotFound, a repo the token can't
+// read as ErrGatedRepo.
+func (client *Client) FileExists(repo *Repo, fileName string, revision string) (bool, error) {
+	if revision == "" {
+		revision = repo.Revision
+	}
+	if revision == "" {
+		revision = DefaultRevision
+	}
+
+	fileURL := hubFileURL(client.Endpoint, repo, "resolve", revision, fileName)
+
+	err := checkExists(client, "HEAD", fileURL)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrEntryNotFound):
+		return false, nil
+	}
+	return false, err
+}
+
+// checkExists sends a request without following redirects and maps the hub's
+// X-Error-Code header to the typed errors above.
+func checkExists(client *Client, method string, target string) error {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = *getHeaders(client)
+
+	base := client.httpClient()
+	httpClient := &http.Client{
+		Transport: base.Transport,
+		Jar:       base.Jar,
+		Timeout:   base.Timeout,
+		// a redirect to the CDN already proves the file exists
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	// the returned *HTTPError unwraps to the typed errors above; infer the
+	// code when a proxy in between dropped the header
+	httpErr := newHTTPError(resp)
+	if httpErr.ErrorCode == "" {
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			// the hub answers 401 for repos that don't exist or are private
+			httpErr.ErrorCode = "RepoNotFound"
+		case http.StatusForbidden:
+			httpErr.ErrorCode = "GatedRepo"
+		case http.StatusNotFound:
+			httpErr.ErrorCode = "EntryNotFound"
+		}
+	}
+
+	return httpErr
+}
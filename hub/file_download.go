@@ -1,8 +1,9 @@
 package hub
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -10,7 +11,6 @@ import (
 	"time"
 	"log"
 
-	"github.com/gofrs/flock"
 	"github.com/vbauerster/mpb/v7"
 	"github.com/vbauerster/mpb/v7/decor"
 )
@@ -23,10 +23,78 @@ const (
 
 
 func (client *Client) Download(params *DownloadParams) (string, error) {
+	client, params, cancel, err := client.prepareDownload(params)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	if len(client.ResolutionOrder) > 0 {
+		return downloadInOrder(client, params)
+	}
+
+	// if no filename is specified, use snapshot downloader
+	if params.FileName == "" {
+		return snapshotDownload(client, params)
+	}
+
+	// otherwise, download the file
+	return fileDownload(client, params)
+}
+
+// prepareDownload copies params with their defaults filled in, and the client
+// the call's overrides apply to. cancel releases the call's deadline.
+func (client *Client) prepareDownload(params *DownloadParams) (*Client, *DownloadParams, context.CancelFunc, error) {
+	// work on copies so callers can share params and repos across goroutines
+	paramsCopy := *params
+	repoCopy := *params.Repo
+	paramsCopy.Repo = &repoCopy
+	params = &paramsCopy
+
+	// accept hf:// URIs, hub URLs and type-prefixed ids in place of a repo id
+	if isRepoURI(params.Repo.Id) {
+		repo, fileName, err := parseRepoURI(params.Repo.Id, client.Endpoint)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		params.Repo.Id = repo.Id
+		params.Repo.Type = repo.Type
+		if params.Repo.Revision == "" {
+			params.Repo.Revision = repo.Revision
+		}
+		if params.FileName == "" {
+			params.FileName = fileName
+		}
+	}
+
+	if params.CacheDir != "" {
+		cacheDir, err := expandPath(params.CacheDir)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to expand cache directory: %w", err)
+		}
+		client = client.clone()
+		client.CacheDir = cacheDir
+	}
+	if params.Limiter != nil {
+		client = client.WithLimiter(params.Limiter)
+	}
+	if params.LinkMode != "" {
+		switch params.LinkMode {
+		case LinkSymlink, MaterializeCopy, MaterializeHardlink, MaterializeReflink:
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown link mode %q", params.LinkMode)
+		}
+		client = client.clone()
+		client.LinkMode = params.LinkMode
+	}
+
 	// set defaults if not provided
 	if params.Repo.Type == "" {
 		params.Repo.Type = ModelRepoType
 	}
+	if params.Revision == "" {
+		params.Revision = params.Repo.Revision
+	}
 	if params.Revision == "" {
 		params.Revision = DefaultRevision
 	}
@@ -34,27 +102,56 @@ func (client *Client) Download(params *DownloadParams) (string, error) {
 		params.Repo.Revision = params.Revision
 	}
 
-	// if no filename is specified, use snapshot downloader
-	if params.FileName == "" {
-		return snapshotDownload(client, params)
+	// files are held to their declared size while a quota applies
+	if limit := blobLimit(client, params); limit > 0 {
+		client = client.clone()
+		client.maxBlobSize = limit
 	}
 
-	// otherwise, download the file
-	return fileDownload(client, params)
+	cancel := context.CancelFunc(func() {})
+	if !params.Deadline.IsZero() {
+		var ctx context.Context
+		ctx, cancel = context.WithDeadline(params.context(), params.Deadline)
+		params.ctx = ctx
+	}
+
+	return client, params, cancel, nil
 }
 
 func fileDownload(client *Client, params *DownloadParams) (string, error) {
+	return fileDownloadWithMetadata(client, params, nil)
+}
+
+// fileDownloadWithMetadata downloads a file using already resolved metadata
+// when available, falling back to a HEAD request otherwise.
+func fileDownloadWithMetadata(client *Client, params *DownloadParams, fileMetadata *FileMetadata) (_ string, err error) {
 	repoId := params.Repo.Id
 	fileName := params.FileName
 	repoType := params.Repo.Type
 
+	if client.SafeTensorsOnly && isPickleFile(fileName) {
+		return "", fmt.Errorf("refusing to download pickle-based file %s in safetensors-only mode", fileName)
+	}
+	if err := checkRepoFilePath(fileName); err != nil {
+		return "", err
+	}
+	if params.SubFolder != "" {
+		if err := checkRepoFilePath(params.SubFolder); err != nil {
+			return "", err
+		}
+	}
 
 	// check if we can download
-	if err := checkConnectivity(params.LocalFilesOnly); err != nil {
-		cachedPath, err := findInCache(client.CacheDir, repoId, repoType, fileName, params.Revision)
+	if err := checkConnectivity(client, params.LocalFilesOnly); err != nil {
+		cachedPath, err := findInCache(client, repoId, repoType, fileName, params.Revision)
 		if err != nil {
 			return "", fmt.Errorf("file not found in cache and downloads are disabled: %w", err)
 		}
+		blob := client.resolveBlob(cachedPath)
+		if err := client.checkCachedFile(cachedPath, blob, filepath.Base(blob), 0); err != nil {
+			return "", fmt.Errorf("cached file is unusable and downloads are disabled: %w", err)
+		}
+		params.summary.recordCacheHit(cachedFileSize(client, cachedPath))
 		return cachedPath, nil
 	}
 
@@ -69,29 +166,71 @@ func fileDownload(client *Client, params *DownloadParams) (string, error) {
 
 	// setup storage folder
 	storageFolder := filepath.Join(client.CacheDir, repoFolderName(repoId, repoType))
-	if err := os.MkdirAll(storageFolder, 0755); err != nil {
+	if err := client.mkdirAll(storageFolder); err != nil {
 		return "", err
 	}
 
-	// check for commmmit hash revision
+	// a commit hash revision, which every file of a snapshot gets, finds a
+	// cached file without a metadata request
 	if regexp.MustCompile("^[0-9a-f]{40}$").MatchString(params.Revision) {
 		pointerPath := filepath.Join(storageFolder, "snapshots", params.Revision, fileName)
-		if _, err := os.Stat(pointerPath); err == nil && !params.ForceDownload {
-			return pointerPath, nil
+		if !params.ForceDownload && client.checkPointer(storageFolder, pointerPath) && !(client.realFiles() && isSymlink(client, pointerPath)) {
+			var size int64
+			if fileMetadata != nil {
+				size = int64(fileMetadata.Size)
+			}
+			// a corrupt file was removed and is downloaded again below
+			blob := client.resolveBlob(pointerPath)
+			if client.checkCachedFile(pointerPath, blob, filepath.Base(blob), size) == nil {
+				params.summary.recordCacheHit(cachedFileSize(client, pointerPath))
+				client.indexAccess(params.Repo, params.Revision, fileName)
+				return pointerPath, nil
+			}
 		}
 	}
 
+	ctx, cancel := params.fileContext()
+	defer cancel()
+
 	// prepare headers for request
-	headers := &http.Header{}
-	headers.Set("User-Agent", client.UserAgent)
-	if client.Token != "" {
-		headers.Set("Authorization", "Bearer "+client.Token)
-	}
+	headers := resolveHeaders(client)
 
 	// get file metadata
-	fileMetadata, err := getFileMetadata(client, params.Repo.Id, fileName, headers)
-	if err != nil {
-		return "", fmt.Errorf("failed to get file metadata: %w", err)
+	if fileMetadata == nil {
+		cacheKey := fileMetadataKey(params.Revision, fileName)
+		if entry := loadMetadata(client, params.Repo, cacheKey, params.Revision); entry != nil && entry.File != nil {
+			fileMetadata = entry.File
+		} else {
+			// a file already cached at this revision is only revalidated,
+			// which the hub shouldn't count as another download
+			metadataHeaders := headers
+			etag := cachedETag(client, params.Repo, params.Revision, fileName)
+			if etag != "" {
+				revalidate := headers.Clone()
+				revalidate.Set("If-None-Match", `"`+etag+`"`)
+				metadataHeaders = &revalidate
+			}
+
+			var err error
+			fileMetadata, err = getFileMetadata(client, params.Repo, params.Revision, fileName, metadataHeaders)
+			if err != nil {
+				return "", newFileError(fileName, StageMetadata, fmt.Errorf("failed to get file metadata: %w", err))
+			}
+			// a 304 may leave out the size, which the cached blob still has
+			if fileMetadata.Size == 0 && etag != "" && fileMetadata.ETag == etag {
+				fileMetadata.Size = int(cachedFileSize(client, filepath.Join(storageFolder, "blobs", etag)))
+			}
+
+			// the redirect location is signed and expires, cache the resolve URL
+			cached := *fileMetadata
+			cached.Location = hubFileURL(client.Endpoint, params.Repo, "resolve", cached.CommitHash, fileName)
+			storeMetadata(client, params.Repo, &metadataEntry{Key: cacheKey, Commit: cached.CommitHash, File: &cached})
+		}
+	}
+
+	// the commit and etag come from the server and name folders in the cache
+	if err := checkMetadataPaths(fileMetadata); err != nil {
+		return "", newFileError(fileName, StageMetadata, err)
 	}
 
 	// setup paths
@@ -99,47 +238,81 @@ func fileDownload(client *Client, params *DownloadParams) (string, error) {
 	pointerPath := filepath.Join(storageFolder, "snapshots", fileMetadata.CommitHash, fileName)
 
 	//create directories
-	os.MkdirAll(filepath.Dir(blobPath), 0755)
-	os.MkdirAll(filepath.Dir(pointerPath), 0755)
+	client.mkdirAll(filepath.Dir(blobPath))
+	client.mkdirAll(filepath.Dir(pointerPath))
 
 	// cache commit hash
 	if params.Revision != fileMetadata.CommitHash {
-		refPath := filepath.Join(storageFolder, "refs", params.Revision)
-		os.MkdirAll(filepath.Dir(refPath), 0755)
-		if err := os.WriteFile(refPath, []byte(fileMetadata.CommitHash), 0644); err != nil {
+		if err := client.writeRef(storageFolder, params.Revision, fileMetadata.CommitHash); err != nil {
 			return "", fmt.Errorf("failed to cache commit hash: %w", err)
 		}
 	}
 
-	// return early if file exists
+	// return early if file exists, unless the cache check removed it
 	if !params.ForceDownload {
-		if _, err := os.Stat(pointerPath); err == nil {
+		// a broken link is removed or linked again before it is reused
+		var err error
+		if !client.checkPointer(storageFolder, pointerPath) {
+			err = os.ErrNotExist
+		}
+		// a symlink is placed again as a real file when the link mode asks for one
+		if err == nil && client.realFiles() && isSymlink(client, pointerPath) {
+			err = os.ErrNotExist
+		}
+		if err == nil && client.checkCachedFile(pointerPath, client.resolveBlob(pointerPath), fileMetadata.ETag, int64(fileMetadata.Size)) == nil {
+			params.summary.recordCacheHit(int64(fileMetadata.Size))
+			client.indexAccess(params.Repo, fileMetadata.CommitHash, fileName)
 			return pointerPath, nil
 		}
-		if _, err := os.Stat(blobPath); err == nil {
-			if err := createSymlink(blobPath, pointerPath); err != nil {
+		_, err = client.fs().Stat(blobPath)
+		if err == nil && client.checkCachedFile(pointerPath, blobPath, fileMetadata.ETag, int64(fileMetadata.Size)) == nil {
+			if err := createSymlink(client, blobPath, pointerPath); err != nil {
 				return "", err
 			}
+			params.summary.recordCacheHit(int64(fileMetadata.Size))
+			client.indexFile(params.Repo, params.Revision, fileName, fileMetadata)
 			return pointerPath, nil
 		}
 	}
 
+	if !params.planned {
+		_, err = confirmDownload(client, params, fileMetadata.CommitHash, []string{fileName}, map[string]*FileMetadata{fileName: fileMetadata})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// from here on the call changes the cache, whatever its outcome
+	defer func() {
+		var size int64
+		if err == nil {
+			size = int64(fileMetadata.Size)
+		}
+		client.audit(&AuditEvent{
+			Action:     AuditDownload,
+			RepoId:     repoId,
+			RepoType:   repoType,
+			Revision:   params.Revision,
+			CommitHash: fileMetadata.CommitHash,
+			Files:      []string{filepath.ToSlash(fileName)},
+			Bytes:      size,
+		}, err)
+	}()
+
 	// lock directory for concurrent downloads
 	locksDir := filepath.Join(client.CacheDir, ".locks")
-	if err := os.MkdirAll(locksDir, 0755); err != nil {
+	if err := client.mkdirAll(locksDir); err != nil {
 		return "", fmt.Errorf("failed to create locks directory: %w", err)
 	}
 
 	modelLockDir := filepath.Join(locksDir, repoFolderName(repoId, repoType))
-	if err := os.MkdirAll(modelLockDir, 0755); err != nil {
+	if err := client.mkdirAll(modelLockDir); err != nil {
 		return "", fmt.Errorf("failed to create model locks directory: %w", err)
 	}
 
 
 	lockPath := filepath.Join(modelLockDir, fmt.Sprintf("%s.lock", fileMetadata.ETag))
-	fileLock := flock.New(lockPath)
-
-	locked, err := fileLock.TryLock()
+	fileLock, locked, err := client.tryLock(lockPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -151,30 +324,91 @@ func fileDownload(client *Client, params *DownloadParams) (string, error) {
 
 	// download file
 	tmpPath := blobPath + ".incomplete"
-	if err := downloadFile(client, fileMetadata.Location, tmpPath, headers, fileMetadata.Size, fileName); err != nil {
-		return "", fmt.Errorf("failed to download file: %w", err)
+	var resumed int64
+	if info, err := client.fs().Stat(tmpPath); err == nil {
+		resumed = info.Size()
+	}
+
+	if err := downloadBlob(ctx, client, params.Repo, fileName, fileMetadata, tmpPath, headers); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			// what was written past the declared size can't be resumed
+			client.fs().Remove(tmpPath)
+		}
+		return "", newFileError(fileName, StageDownload, fmt.Errorf("failed to download file: %w", err))
+	}
+
+	if err := verifyArtifact(client, params.Repo, fileMetadata.CommitHash, fileName, tmpPath); err != nil {
+		client.fs().Remove(tmpPath)
+		return "", err
 	}
 
 	// move temporary file to final destination
-	if err := os.Rename(tmpPath, blobPath); err != nil {
-		return "", fmt.Errorf("failed to move temporary file to final destination: %w", err)
+	if err := client.finalizeBlob(tmpPath, blobPath); err != nil {
+		return "", newFileError(fileName, StageDownload, fmt.Errorf("failed to move temporary file to final destination: %w", err))
 	}
 
 	// create symlink
-	if err := createSymlink(blobPath, pointerPath); err != nil {
+	if err := createSymlink(client, blobPath, pointerPath); err != nil {
 		log.Printf("[Download] Failed to create symlink: %v", err)
 		fmt.Printf("[Download] Failed to create symlink: %v", err)
 		return "", err
 	}
 
+	params.summary.recordDownload(int64(fileMetadata.Size) - resumed)
+	client.indexFile(params.Repo, params.Revision, fileName, fileMetadata)
+
+	err = runHooks(client, params, &HookEvent{
+		Stage:      AfterFile,
+		RepoId:     repoId,
+		RepoType:   repoType,
+		CommitHash: fileMetadata.CommitHash,
+		FileName:   fileName,
+		Path:       pointerPath,
+		BlobPath:   blobPath,
+		Size:       int64(fileMetadata.Size),
+	})
+	if err != nil {
+		return "", err
+	}
+
 	return pointerPath, nil
 }
 
 
-func downloadFile(client *Client, url, destPath string, headers *http.Header, expectedSize int, displayName string) error {
+// downloadBlob fetches a blob from the mirror and the endpoint together when a
+// mirror is configured, and from the endpoint alone otherwise.
+func downloadBlob(ctx context.Context, client *Client, repo *Repo, fileName string, metadata *FileMetadata, tmpPath string, headers *http.Header) error {
+	// an interrupted download is resumed from its single source
+	_, statErr := client.fs().Stat(tmpPath)
+	if statErr != nil && useMultiSource(client, metadata) {
+		urls := []string{
+			mirrorURL(client, repo, metadata.CommitHash, fileName),
+			metadata.Location,
+		}
+		err := multiSourceDownload(ctx, client, urls, tmpPath, headers, int64(metadata.Size), metadata.ETag, fileName)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[Download] Multi-source download of %s failed, retrying from %s: %v", fileName, client.Endpoint, err)
+		client.fs().Remove(tmpPath)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := downloadFile(ctx, client, metadata.Location, tmpPath, headers, metadata.Size, fileName)
+		if errors.Is(err, ErrDownloadTooSlow) && attempt < maxSlowReconnects {
+			// the next attempt resumes from the partial file
+			log.Printf("[Download] Reconnecting to download %s: %v", fileName, err)
+			continue
+		}
+		return err
+	}
+}
+
+
+func downloadFile(ctx context.Context, client *Client, url, destPath string, headers *http.Header, expectedSize int, displayName string) error {
 	// try to get existing file for resume
 	var resumeSize int64 = 0
-	if stat, err := os.Stat(destPath); err == nil {
+	if stat, err := client.fs().Stat(destPath); err == nil {
 		resumeSize = stat.Size()
 	}
 
@@ -184,25 +418,30 @@ func downloadFile(client *Client, url, destPath string, headers *http.Header, ex
 		flag |= os.O_APPEND
 	}
 
-	out, err := os.OpenFile(destPath, flag, 0644)
+	out, err := client.openFile(destPath, flag)
 	if err != nil {
 		return err
 	}
 
 	defer out.Close()
 
-	httpClient := &http.Client{
-		Timeout: time.Minute * 30,
+	release, err := client.Limiter.acquire(ctx)
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	req, err := http.NewRequest("GET", url, nil)
+	ctx, watchdog := client.watchReads(ctx)
+	defer watchdog.stop()
+
+	httpClient := client.downloadHTTPClient(time.Minute * 30)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	if headers != nil {
-		req.Header = *headers
-	}
+	req.Header = headersFor(client, url, headers)
 
 	if resumeSize > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeSize))
@@ -210,10 +449,11 @@ func downloadFile(client *Client, url, destPath string, headers *http.Header, ex
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return watchdog.wrap(ctx, err)
 	}
 
-	defer resp.Body.Close()
+	// resp and reader are replaced when reconnecting mid-stream
+	defer func() { resp.Body.Close() }()
 
 	if resumeSize > 0 && resp.StatusCode != http.StatusPartialContent {
 		// server doesn't support resume, start over
@@ -223,8 +463,18 @@ func downloadFile(client *Client, url, destPath string, headers *http.Header, ex
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		log.Printf("[Download] Bad status: %s", resp.Status)
-		return fmt.Errorf("bad status: %s", resp.Status)
+		err := newHTTPError(resp)
+		log.Printf("[Download] Bad status: %v", err)
+		return err
+	}
+
+	if err := decodeBody(resp); err != nil {
+		return err
+	}
+
+	guard := newSizeGuard(client, displayName, int64(expectedSize), resumeSize)
+	if err := guard.expect(resp.ContentLength); err != nil {
+		return err
 	}
 
 	// progress bar
@@ -233,7 +483,9 @@ func downloadFile(client *Client, url, destPath string, headers *http.Header, ex
 		description = fmt.Sprintf("Resuming download of %s", displayName)
 	}
 
-	bar := client.Progress.AddBar(
+	// the bar is set to absolute positions, so a retried or resumed attempt
+	// never counts bytes twice
+	bar := client.addRetriedBar(displayName,
         int64(expectedSize),
 		mpb.BarRemoveOnComplete(),
         mpb.PrependDecorators(
@@ -247,62 +499,109 @@ func downloadFile(client *Client, url, destPath string, headers *http.Header, ex
         ),
     )
 
-	// set initial progress if resuming
-	if resumeSize > 0 {
-		bar.SetCurrent(resumeSize)
+	bar.SetCurrent(resumeSize)
+	if resp.ContentLength >= 0 {
+		bar.SetTotal(resumeSize+resp.ContentLength, false)
 	}
 
 	reader := bar.ProxyReader(resp.Body)
-	defer reader.Close()
-
-	buf := make([]byte, 64*1024) // 64KB buffer
-
-	for {
-		n, err := reader.Read(buf)
-		if n > 0 {
-			// write to file
-			if _, werr := out.Write(buf[:n]); werr != nil {
-				return werr
-			}
-
-			// bar.Add(n)
+	defer func() { reader.Close() }()
+
+	buf := client.newCopyBuffer()
+	flush := client.newFlusher(out)
+	monitor := newSpeedMonitor(client)
+	offset := resumeSize
+	reconnects := 0
+
+	body := &countingReader{r: reader, count: func(p []byte) error {
+		buf.record(len(p))
+		watchdog.touch()
+		if err := guard.add(len(p)); err != nil {
+			return err
+		}
+		if err := flush.written(); err != nil {
+			return err
 		}
+		return monitor.add(len(p))
+	}}
 
-		if err == io.EOF {
+	for {
+		n, err := copyBody(out, body, buf)
+		offset += n
+		if err == nil {
 			break
 		}
-		if err != nil {
-			return err
+		if body.readFailed(err) && reconnects < maxStreamReconnects && canReconnect(ctx, err) {
+			// continue from what was written; the bar already counts it
+			reconnects++
+			log.Printf("[Download] Connection to %s dropped at byte %d (%d/%d): %v", displayName, offset, reconnects, maxStreamReconnects, err)
+			reader.Close()
+			newResp, rerr := reconnectAt(ctx, client, url, headers, offset)
+			if rerr != nil {
+				bar.Abort(true)
+				return fmt.Errorf("%w (reconnect failed: %v)", err, rerr)
+			}
+			resp = newResp
+			reader = bar.ProxyReader(resp.Body)
+			body.r = reader
+			continue
 		}
+		bar.Abort(true)
+		return watchdog.wrap(ctx, err)
 	}
 
-	bar.SetTotal(bar.Current(), true)
+	// the bytes written are the file, whatever the metadata said
+	bar.SetCurrent(offset)
+	bar.SetTotal(-1, true)
 
 	return nil
 }
 
 
-func findInCache(cacheDir, repoId, repoType, fileName, revision string) (string, error) {
-	storageFolder := filepath.Join(cacheDir, repoFolderName(repoId, repoType))
+// cachedETag is the blob name, and so the etag, of the file cached at
+// revision, or "" when it isn't cached or is a real file in the snapshot.
+func cachedETag(client *Client, repo *Repo, revision string, fileName string) string {
+	pointerPath, err := findInCache(client, repo.Id, repo.Type, fileName, revision)
+	if err != nil {
+		return ""
+	}
+	blob := client.resolveBlob(pointerPath)
+	if blob == pointerPath {
+		return ""
+	}
+	return filepath.Base(blob)
+}
+
+// cachedFileSize is the size of the blob behind a snapshot pointer, 0 when it
+// can't be read.
+func cachedFileSize(client *Client, pointerPath string) int64 {
+	if info, err := client.fs().Stat(pointerPath); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+
+func findInCache(client *Client, repoId, repoType, fileName, revision string) (string, error) {
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(repoId, repoType))
 
 	// if revision is a commit hash, look for it in snapshots
 	if regexp.MustCompile("^[0-9a-f]{40}$").MatchString(revision) {
 		path := filepath.Join(storageFolder, "snapshots", revision, fileName)
-		if _, err := os.Stat(path); err == nil {
+		if client.checkPointer(storageFolder, path) {
 			return path, nil
 		}
 		return "", fmt.Errorf("file not found in cache at revision %s", revision)
 	}
 
 	// else, try to resolve the revision from refs
-	refPath := filepath.Join(storageFolder, "refs", revision)
-	commitHash, err := os.ReadFile(refPath)
+	commitHash, err := readRef(client.fs(), storageFolder, revision)
 	if err != nil {
-		return "", fmt.Errorf("revision %s not found in cache", revision)
+		return "", err
 	}
 
-	path := filepath.Join(storageFolder, "snapshots", string(commitHash), fileName)
-	if _, err := os.Stat(path); err == nil {
+	path := filepath.Join(storageFolder, "snapshots", commitHash, fileName)
+	if client.checkPointer(storageFolder, path) {
 		return path, nil
 	}
 
@@ -0,0 +1,190 @@
+package hub
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FS is the filesystem the cache's blobs, pointers and refs are written to.
+// OSFS is used when Client.FS is nil; other implementations let the cache be
+// kept in memory for tests or on a remote volume.
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+	Lchown(name string, uid, gid int) error
+}
+
+// File is an open file of an FS. *os.File implements it.
+type File interface {
+	io.ReadWriteSeeker
+	io.WriterAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// OSFS is the local filesystem.
+type OSFS struct{}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error)  { return os.Stat(name) }
+func (OSFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+func (OSFS) ReadFile(name string) ([]byte, error)   { return os.ReadFile(name) }
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+func (OSFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (OSFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (OSFS) Remove(name string) error                     { return os.Remove(name) }
+func (OSFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (OSFS) Symlink(oldname, newname string) error        { return os.Symlink(oldname, newname) }
+func (OSFS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (OSFS) Chmod(name string, mode os.FileMode) error    { return os.Chmod(name, mode) }
+func (OSFS) Lchown(name string, uid, gid int) error       { return os.Lchown(name, uid, gid) }
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// avoid returning a non-nil File holding a nil *os.File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (client *Client) fs() FS {
+	if client.FS != nil {
+		return client.FS
+	}
+	return OSFS{}
+}
+
+func (client *Client) exists(path string) bool {
+	_, err := client.fs().Lstat(path)
+	return err == nil
+}
+
+// CacheFS is the filesystem the client's cache is on, for packages building
+// on the cache layout such as pipeline.
+func (client *Client) CacheFS() FS {
+	return client.fs()
+}
+
+// links followed while resolving a path before giving up, as on Linux
+const maxSymlinks = 40
+
+// evalSymlinks is filepath.EvalSymlinks on fsys.
+func evalSymlinks(fsys FS, path string) (string, error) {
+	if _, ok := fsys.(OSFS); ok {
+		return filepath.EvalSymlinks(path)
+	}
+
+	sep := string(filepath.Separator)
+	resolved := "."
+	if filepath.IsAbs(path) {
+		resolved = sep
+	}
+	parts := strings.Split(filepath.Clean(path), sep)
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: "lstat", Path: path, Err: errors.New("too many links")}
+		}
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+		}
+		parts = append(strings.Split(filepath.Clean(target), sep), parts...)
+	}
+	return resolved, nil
+}
+
+// walkDir is filepath.WalkDir on fsys.
+func walkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	if _, ok := fsys.(OSFS); ok {
+		return filepath.WalkDir(root, fn)
+	}
+
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDirEntry(fsys FS, path string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, entry, nil); err != nil || !entry.IsDir() {
+		if err == filepath.SkipDir && entry.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		// a second call reports the error, as filepath.WalkDir does
+		if err := fn(path, entry, err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, child := range entries {
+		if err := walkDirEntry(fsys, filepath.Join(path, child.Name()), child, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type AccessStatus string
+
+const (
+	// AccessNotGated means the repo can be downloaded without accepting terms.
+	AccessNotGated AccessStatus = "not_gated"
+	// AccessGranted means the conditions were accepted and downloads are allowed.
+	AccessGranted AccessStatus = "granted"
+	// AccessPending means the request awaits manual approval by the repo authors.
+	AccessPending AccessStatus = "pending"
+)
+
+// AcceptConditions accepts the terms of a gated repo on behalf of the token's
+// user. Repos gated in "auto" mode grant access immediately; "manual" repos
+// return AccessPending until an author approves. Some repos require extra
+// form fields (e.g. name, affiliation), which are sent as given.
+func (client *Client) AcceptConditions(repo *Repo, fields map[string]string) (AccessStatus, error) {
+	if client.Token == "" {
+		return "", fmt.Errorf("accepting conditions of %s requires a token", repo.Id)
+	}
+
+	gated, err := fetchGatedMode(client, repo)
+	if err != nil {
+		return "", err
+	}
+	if gated == "" {
+		return AccessNotGated, nil
+	}
+
+	form := url.Values{}
+	for key, value := range fields {
+		form.Set(key, value)
+	}
+
+	accessURL := fmt.Sprintf("%s/%s/ask-access", client.Endpoint, repoURLPath(repo))
+	req, err := http.NewRequest("POST", accessURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = *getHeaders(client)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request access: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "", fmt.Errorf("token is not allowed to request access to %s (status %d), a token with access to public gated repos is required", repo.Id, resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return "", fmt.Errorf("access request for %s failed: %w", repo.Id, newHTTPError(resp))
+	}
+
+	if gated == "manual" {
+		log.Printf("[Download] Access request for %s is pending manual approval by the repo authors", repo.Id)
+		return AccessPending, nil
+	}
+	return AccessGranted, nil
+}
+
+// fetchGatedMode returns "auto" or "manual" for gated repos and "" otherwise.
+func fetchGatedMode(client *Client, repo *Repo) (string, error) {
+	infoURL := fmt.Sprintf("%s/api/%s/%s", client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id)
+
+	req, err := http.NewRequest("GET", infoURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = *getHeaders(client)
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API request failed: %w", newHTTPError(resp))
+	}
+
+	var info struct {
+		// false, "auto" or "manual"
+		Gated json.RawMessage `json:"gated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to parse repository info: %w", err)
+	}
+
+	var mode string
+	if err := json.Unmarshal(info.Gated, &mode); err != nil {
+		return "", nil
+	}
+	return mode, nil
+}
+
+// repoURLPath is the repo's path on the website, e.g. "datasets/org/name".
+func repoURLPath(repo *Repo) string {
+	if repo.Type == "" || repo.Type == ModelRepoType {
+		return repo.Id
+	}
+	return repoTypeAPIPath(repo.Type) + "/" + repo.Id
+}
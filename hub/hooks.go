@@ -0,0 +1,219 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HookStage says what a hook runs after.
+type HookStage string
+
+const (
+	// a downloaded file was moved into the cache and linked into its snapshot
+	AfterFile HookStage = "file"
+	// every file of a snapshot download is in the cache
+	AfterSnapshot HookStage = "snapshot"
+)
+
+// ErrRejected is wrapped by hooks that found a downloaded file unusable. The
+// file is removed from the cache so the next download fetches it again.
+var ErrRejected = errors.New("rejected by hook")
+
+// HookEvent describes what was finalized. For AfterFile, Path is the file in
+// the snapshot and BlobPath its blob; for AfterSnapshot, Path is the snapshot
+// folder and Files the files that were requested.
+type HookEvent struct {
+	Stage      HookStage `json:"stage"`
+	RepoId     string    `json:"repo"`
+	RepoType   string    `json:"repo_type"`
+	CommitHash string    `json:"commit"`
+	FileName   string    `json:"file_name,omitempty"`
+	Path       string    `json:"path"`
+	BlobPath   string    `json:"blob_path,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Files      []string  `json:"files,omitempty"`
+
+	fs FS
+}
+
+// Open opens Path on the client's FS, which hooks should read through rather
+// than the os package.
+func (e *HookEvent) Open() (File, error) {
+	fsys := e.fs
+	if fsys == nil {
+		fsys = OSFS{}
+	}
+	return fsys.OpenFile(e.Path, os.O_RDONLY, 0)
+}
+
+// Hook runs after a file or snapshot is finalized, e.g. to validate it, start
+// a conversion or notify a queue. Hooks check event.Stage themselves. An
+// error fails the download.
+type Hook func(event *HookEvent) error
+
+// HookError is returned when a hook fails. Downloads don't retry it.
+type HookError struct {
+	Stage HookStage
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.Stage, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// runHooks runs the client's hooks and then the call's, stopping at the
+// first error.
+func runHooks(client *Client, params *DownloadParams, event *HookEvent) error {
+	hooks := append(append([]Hook(nil), client.Hooks...), params.Hooks...)
+	event.fs = client.fs()
+	for _, hook := range hooks {
+		if err := hook(event); err != nil {
+			if event.Stage == AfterFile && errors.Is(err, ErrRejected) {
+				client.fs().Remove(event.Path)
+				client.fs().Remove(event.BlobPath)
+				log.Printf("[Download] Removed %s from the cache: %v", event.FileName, err)
+				client.audit(&AuditEvent{
+					Action:     AuditEvict,
+					RepoId:     event.RepoId,
+					RepoType:   event.RepoType,
+					CommitHash: event.CommitHash,
+					Files:      []string{event.FileName},
+					Bytes:      event.Size,
+					Reason:     err.Error(),
+				}, nil)
+			}
+			return &HookError{Stage: event.Stage, Err: err}
+		}
+	}
+	return nil
+}
+
+// ValidateSafetensors is a hook that checks every downloaded .safetensors file
+// has a well-formed header whose tensors fit in the file.
+func ValidateSafetensors(event *HookEvent) error {
+	if event.Stage != AfterFile || !strings.HasSuffix(event.FileName, ".safetensors") {
+		return nil
+	}
+	f, err := event.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := checkSafetensors(f); err != nil {
+		return fmt.Errorf("%s: %w: %v", event.FileName, ErrRejected, err)
+	}
+	return nil
+}
+
+// safetensors header limit used by the reference implementation
+const maxSafetensorsHeader = 100 << 20
+
+func checkSafetensors(f File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var headerLen uint64
+	if err := binary.Read(f, binary.LittleEndian, &headerLen); err != nil {
+		return fmt.Errorf("missing header length: %w", err)
+	}
+	if headerLen > maxSafetensorsHeader || int64(headerLen)+8 > info.Size() {
+		return fmt.Errorf("header length %d out of range", headerLen)
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fmt.Errorf("truncated header: %w", err)
+	}
+
+	var tensors map[string]json.RawMessage
+	if err := json.Unmarshal(header, &tensors); err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+
+	dataSize := info.Size() - 8 - int64(headerLen)
+	for name, raw := range tensors {
+		if name == "__metadata__" {
+			continue
+		}
+		var tensor struct {
+			DataOffsets [2]int64 `json:"data_offsets"`
+		}
+		if err := json.Unmarshal(raw, &tensor); err != nil {
+			return fmt.Errorf("invalid entry for tensor %s: %w", name, err)
+		}
+		begin, end := tensor.DataOffsets[0], tensor.DataOffsets[1]
+		if begin < 0 || end < begin || end > dataSize {
+			return fmt.Errorf("tensor %s at [%d, %d) is outside the %d data bytes", name, begin, end, dataSize)
+		}
+	}
+	return nil
+}
+
+// CommandHook runs a command for every event of the stage, with the event in
+// HF_HOOK_* environment variables, e.g. to queue a quantization job after a
+// snapshot completes.
+func CommandHook(stage HookStage, name string, args ...string) Hook {
+	return func(event *HookEvent) error {
+		if event.Stage != stage {
+			return nil
+		}
+
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(),
+			"HF_HOOK_STAGE="+string(event.Stage),
+			"HF_HOOK_REPO="+event.RepoId,
+			"HF_HOOK_REPO_TYPE="+event.RepoType,
+			"HF_HOOK_COMMIT="+event.CommitHash,
+			"HF_HOOK_FILE="+event.FileName,
+			"HF_HOOK_PATH="+event.Path,
+		)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", filepath.Base(name), err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+}
+
+// WebhookHook posts every event of the stage as JSON to url. Delivery
+// failures are logged, not returned, so a down queue doesn't fail downloads.
+func WebhookHook(stage HookStage, url string) Hook {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	return func(event *HookEvent) error {
+		if event.Stage != stage {
+			return nil
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[Download] Failed to deliver %s hook to %s: %v", event.Stage, url, err)
+			return nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			log.Printf("[Download] Webhook %s returned status %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
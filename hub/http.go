@@ -0,0 +1,233 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// shared by every client that doesn't bring its own HTTPClient, so connections
+// to the hub and CDN are pooled across downloads
+var defaultHTTPClient = newHTTPClient(newTransport(nil))
+
+// TransportOptions tune the connection layer used for hub and CDN requests.
+type TransportOptions struct {
+	// Proxy overrides HTTPS_PROXY/HTTP_PROXY/NO_PROXY, which are honored otherwise.
+	Proxy *url.URL
+	// Resolver replaces the system DNS resolver.
+	Resolver *net.Resolver
+	// PreferIPv4 dials IPv4 addresses first and only falls back to IPv6 when that fails.
+	PreferIPv4 bool
+	// DialTimeout bounds each connection attempt. Defaults to 60 seconds.
+	DialTimeout time.Duration
+	// DialContext replaces the dialer entirely; Resolver, PreferIPv4 and DialTimeout are ignored.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewHTTPClient builds an HTTP client suitable for Client.HTTPClient.
+func NewHTTPClient(opts *TransportOptions) *http.Client {
+	return newHTTPClient(newTransport(opts))
+}
+
+func newTransport(opts *TransportOptions) *http.Transport {
+	if opts == nil {
+		opts = &TransportOptions{}
+	}
+
+	dialTimeout := opts.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 60 * time.Second
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+		Resolver:  opts.Resolver,
+	}
+
+	dialContext := dialer.DialContext
+	if opts.PreferIPv4 {
+		dialContext = preferIPv4Dialer(dialer)
+	}
+	if opts.DialContext != nil {
+		dialContext = opts.DialContext
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   16,
+		TLSHandshakeTimeout:   60 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		IdleConnTimeout:       60 * time.Second,
+	}
+
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+
+	return transport
+}
+
+// preferIPv4Dialer works around networks with broken IPv6 routes to the CDN,
+// where every IPv6 attempt would otherwise burn the full dial timeout.
+func preferIPv4Dialer(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp4", addr)
+		if err == nil {
+			return conn, nil
+		}
+
+		// the host may only have IPv6 addresses
+		if conn, err6 := dialer.DialContext(ctx, "tcp6", addr); err6 == nil {
+			return conn, nil
+		}
+
+		return nil, err
+	}
+}
+
+func newHTTPClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+func (client *Client) httpClient() *http.Client {
+	base := defaultHTTPClient
+	if client.HTTPClient != nil {
+		base = client.HTTPClient
+	}
+	useNetrc := client.Token == "" && client.Netrc != nil
+	if !client.DebugHTTP && !useNetrc && len(client.EndpointAuth) == 0 {
+		return base
+	}
+
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if useNetrc {
+		transport = newNetrcTransport(client, transport)
+	}
+	if len(client.EndpointAuth) > 0 {
+		transport = &authTransport{base: transport, endpoints: client.EndpointAuth}
+	}
+	if client.DebugHTTP {
+		transport = &traceTransport{base: transport, logger: client.Logger}
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: base.CheckRedirect,
+		Jar:           base.Jar,
+		Timeout:       base.Timeout,
+	}
+}
+
+// downloadHTTPClient shares the client's transport but applies a whole-request timeout.
+func (client *Client) downloadHTTPClient(timeout time.Duration) *http.Client {
+	base := client.httpClient()
+	return &http.Client{
+		Transport:     base.Transport,
+		CheckRedirect: base.CheckRedirect,
+		Jar:           base.Jar,
+		Timeout:       timeout,
+	}
+}
+
+// metadataHTTPClient follows redirects within the hub, e.g. for renamed repos,
+// but stops at redirects to the CDN so the hub's X-Linked-* headers are kept.
+func (client *Client) metadataHTTPClient() *http.Client {
+	base := client.httpClient()
+	timeout := base.Timeout
+	if client.EtagTimeout > 0 {
+		timeout = client.EtagTimeout
+	}
+	return &http.Client{
+		Transport: base.Transport,
+		Jar:       base.Jar,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Host != via[0].URL.Host {
+				return http.ErrUseLastResponse
+			}
+			// an LFS redirect is the file itself, served from wherever it points
+			if req.Response != nil && req.Response.Header.Get("X-Linked-Etag") != "" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// traceHeaders are logged for every response in debug mode.
+var traceHeaders = []string{"ETag", "X-Linked-Etag", "X-Repo-Commit", "Location", "X-Request-Id", "X-Error-Code"}
+
+// traceTransport logs each request with its status, the headers useful for
+// diagnosing mirrors and proxies, and its duration.
+type traceTransport struct {
+	base   http.RoundTripper
+	logger *log.Logger
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(started).Round(time.Millisecond)
+
+	if err != nil {
+		t.logf("[HTTP] %s %s failed after %s: %v", req.Method, redactURL(req.URL), elapsed, err)
+		return resp, err
+	}
+
+	var fields []string
+	if r := req.Header.Get("Range"); r != "" {
+		fields = append(fields, "range="+r)
+	}
+	for _, name := range traceHeaders {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if name == "Location" {
+			// signed CDN URLs carry credentials in the query
+			if u, err := url.Parse(value); err == nil {
+				value = redactURL(u)
+			}
+		}
+		fields = append(fields, strings.ToLower(name)+"="+value)
+	}
+
+	t.logf("[HTTP] %s %s -> %s in %s %s", req.Method, redactURL(req.URL), resp.Status, elapsed, strings.Join(fields, " "))
+	return resp, nil
+}
+
+func (t *traceTransport) logf(format string, args ...any) {
+	if t.logger != nil {
+		t.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = "<redacted>"
+	return c.String()
+}
@@ -1,9 +1,14 @@
 package hub
 
 import (
-	"os"
-	"path/filepath"
+	"context"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 	"github.com/vbauerster/mpb/v7"
 )
 
@@ -17,84 +22,219 @@ const (
 )
 
 
+// Client holds the settings used for downloads. A Client is safe for concurrent
+// use as long as its fields are not modified once it is shared; the With*
+// methods return modified copies instead of changing the receiver.
 type Client struct {
 	Endpoint        string
 	Token           string
 	CacheDir        string
 	UserAgent       string
 	Progress        *mpb.Progress
+
+	// optional HTTP client for all hub requests; a shared pooled client is used when nil
+	HTTPClient      *http.Client
+
+	// serve from the cache only, never touching the network
+	Offline         bool
+
+	// serve from the cache, as if Offline, while Endpoint doesn't answer a
+	// quick probe; the answer is reused for 30s
+	AutoOffline     bool
+
+	// signature checks run on every downloaded blob before it is moved into the cache
+	Verification    *VerificationPolicy
+
+	// refuse pickle-based weights and files flagged by the hub's security scanner
+	SafeTensorsOnly bool
+
+	// optional hub mirror that large blobs are fetched from alongside Endpoint
+	MirrorEndpoint  string
+
+	// sources tried in turn for each call, e.g. SourceCache, SourceMirror,
+	// SourceOrigin; the one that served it is in the DownloadSummary. Empty
+	// uses the cache when it is current, and Endpoint together with the mirror
+	ResolutionOrder []Source
+
+	// reconnect and resume when the average speed over SpeedWindow (60s by
+	// default) drops below MinDownloadSpeed bytes per second; 0 disables it
+	MinDownloadSpeed int64
+	SpeedWindow      time.Duration
+
+	// read buffer size for downloads and concurrent range requests per source
+	// for multi-source downloads; tuned from the measured speed when 0
+	BufferSize          int
+	RangeWorkers        int
+
+	// sync partial downloads to disk this often, so a crash loses at most
+	// that much of one; 0 leaves flushing to the OS
+	FlushInterval       time.Duration
+
+	// how often progress is logged when stdout is not a terminal (10s by
+	// default, negative disables it)
+	ProgressLogInterval time.Duration
+
+	// modes and group for everything written to the cache, e.g.
+	// os.ModeSetgid|0775, 0664 and a shared group on multi-user servers;
+	// defaults to 0755/0644 narrowed by the umask
+	DirMode         os.FileMode
+	FileMode        os.FileMode
+	Group           string
+
+	// auth schemes by URL prefix, e.g. basic auth for a private mirror at
+	// "https://artifactory.example.com/api/huggingfaceml/hf"; requests under a
+	// prefix never carry Token
+	EndpointAuth    map[string]Auth
+
+	// credentials used when Token is empty, per host; loaded from $NETRC or
+	// ~/.netrc by Config.NewClient
+	Netrc           *Netrc
+
+	// log every hub request with its status, etag/commit/location/request id
+	// headers and timing, to Logger or the standard logger when nil
+	DebugHTTP       bool
+	Logger          *log.Logger
+
+	// keep model info and file metadata from the API under CacheDir/.metadata
+	// for this long, or until the ref moves to another commit; 0 disables it
+	MetadataTTL     time.Duration
+
+	// journal downloads and cache hits to the index under CacheDir/.index, once
+	// RebuildCacheIndex has created it, for fast scans and eviction
+	CacheIndex      bool
+
+	// run after every downloaded file and completed snapshot, before the
+	// hooks of the call's DownloadParams
+	Hooks           []Hook
+
+	// receives every change made to the cache, downloads, deleted revisions
+	// and evicted files, e.g. AuditFile's log; AuditUser names who made them,
+	// the OS user when empty
+	Audit           AuditFunc
+	AuditUser       string
+
+	// DurabilityFsync syncs blobs and refs to disk before and after they
+	// are renamed into place, at some cost in speed
+	Durability      Durability
+
+	// filesystem holding the cache, OSFS when nil; lock files for concurrent
+	// downloads are only shared between processes on OSFS
+	FS              FS
+
+	// bounds the transfers running at once across every download made through
+	// this client and its copies; unbounded when nil
+	Limiter         *Limiter
+
+	// most bytes of blobs a repo may hold in the cache; a download that would
+	// go past it fails with ErrQuotaExceeded before anything is transferred.
+	// 0 disables it
+	RepoQuota       int64
+
+	// check cached files by size or hash before returning them; corrupt ones
+	// are removed and downloaded again
+	CacheCheck      CacheCheck
+
+	// places real files in snapshots, copied, hardlinked or reflinked from
+	// blobs/, instead of symlinks; empty keeps symlinks
+	LinkMode        MaterializeMode
+
+	// bound the metadata request resolving each file, and the wait for a
+	// connection or the next data of a download; 0 waits indefinitely
+	EtagTimeout     time.Duration
+	DownloadTimeout time.Duration
+
+	// the library built on this client, named first in the User-Agent so the
+	// hub attributes its downloads
+	LibraryName     string
+	LibraryVersion  string
+
+	// leave the Go version and session id out of the User-Agent
+	DisableTelemetry bool
+
+	// groups this client's bars in the shared container; see WithProgressSection
+	section         *progressSection
+
+	// most bytes one blob may take, set for a call by its quotas
+	maxBlobSize     int64
 }
 
 
+func (client *Client) clone() *Client {
+	c := *client
+	return &c
+}
+
 func (client *Client) WithToken(token string) *Client {
-    client.Token = token
-    return client
+	c := client.clone()
+	c.Token = token
+	return c
 }
 
-func NewClient(endpoint string, token string, cacheDir string) *Client {
+func (client *Client) WithEndpoint(endpoint string) *Client {
+	c := client.clone()
+	c.Endpoint = strings.TrimSuffix(endpoint, "/")
+	return c
+}
+
+func (client *Client) WithCacheDir(cacheDir string) *Client {
 	expandedCache, err := expandPath(cacheDir)
 	if err != nil {
 		panic(err)
 	}
 
-	return &Client{
-		Endpoint: 	endpoint,
-		Token:   	token,
-		CacheDir:   expandedCache,
-		UserAgent:  "huggingface-go/0.0.1",
-	}
+	c := client.clone()
+	c.CacheDir = expandedCache
+	return c
 }
 
-func DefaultClient() *Client {
-	var cacheDir string
-
-	if xdgCache := os.Getenv("XDG_CACHE_HOME"); xdgCache != "" {
-		cacheDir = filepath.Join(xdgCache, "huggingface", "hub")
-	}
-
-	if cacheDir == "" {
-		if hfCache := os.Getenv("HF_HUB_CACHE"); hfCache != "" {
-			cacheDir = hfCache
-		}
-	}
+func (client *Client) WithUserAgent(userAgent string) *Client {
+	c := client.clone()
+	c.UserAgent = userAgent
+	return c
+}
 
-	if cacheDir == "" {
-		if hfHome := os.Getenv("HF_HOME"); hfHome != "" {
-			cacheDir = filepath.Join(hfHome, "hub")
-		}
-	}
+// WithLibrary names the library or application using the client in the
+// User-Agent, like library_name and library_version in the python package.
+func (client *Client) WithLibrary(name string, version string) *Client {
+	c := client.clone()
+	c.LibraryName = name
+	c.LibraryVersion = version
+	return c
+}
 
-	if cacheDir == "" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			panic(fmt.Errorf("failed to get user home directory: %w", err))
-		}
-		cacheDir = filepath.Join(homeDir, ".cache", "huggingface", "hub")
-	}
+func (client *Client) WithProgress(progress *mpb.Progress) *Client {
+	c := client.clone()
+	c.Progress = progress
+	return c
+}
 
+func NewClient(endpoint string, token string, cacheDir string) *Client {
 	expandedCache, err := expandPath(cacheDir)
 	if err != nil {
-		panic(fmt.Errorf("failed to expand cache directory: %w", err))
+		panic(err)
 	}
 
-	// create cache directory if it doesn't exist
-	if err := os.MkdirAll(expandedCache, 0755); err != nil {
-		panic(fmt.Errorf("failed to create cache directory: %w", err))
+	return &Client{
+		Endpoint: 	endpoint,
+		Token:   	token,
+		CacheDir:   expandedCache,
+		UserAgent:  defaultUserAgent,
+		Offline:    IsOfflineMode(),
 	}
+}
 
-	endpoint := os.Getenv("HF_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "https://huggingface.co"
+func DefaultClient() *Client {
+	cfg, err := LoadConfig()
+	if err != nil {
+		panic(fmt.Errorf("failed to load config: %w", err))
 	}
 
-	token := GetToken()
-
-	return &Client{
-		Endpoint: endpoint,
-		Token: token,
-		CacheDir: expandedCache,
-		UserAgent: "huggingface-go/0.0.1",
+	client, err := cfg.NewClient()
+	if err != nil {
+		panic(err)
 	}
+
+	return client
 }
 
 
@@ -107,12 +247,92 @@ type DownloadParams struct {
 	LocalFilesOnly 	bool
 	AllowPatterns   []string
 	IgnorePatterns  []string
+	AllowRegex      []*regexp.Regexp
+	IgnoreRegex     []*regexp.Regexp
+	// match AllowPatterns and IgnorePatterns ignoring case, e.g. "readme.md"
+	// selects README.md
+	CaseInsensitivePatterns bool
+	// skip files larger than MaxFileSize or smaller than MinFileSize bytes (0 disables the bound)
+	MaxFileSize     int64
+	MinFileSize     int64
 	Components      map[string]ComponentDef
+
+	// write a checksum manifest once a snapshot completes (into ChecksumDir, or the snapshot folder)
+	ChecksumFormat  ChecksumFormat
+	ChecksumDir     string
+
+	// shared retry limits for snapshot downloads, DefaultRetryBudget when nil
+	RetryBudget     *RetryBudget
+
+	// files of a snapshot fetched at once, 8 when 0; the first failure cancels
+	// the others unless CollectErrors, which attempts every file and returns
+	// all their errors together
+	Workers         int
+	CollectErrors   bool
+
+	// overrides Client.CacheDir for this call, e.g. to put hot models on a faster volume
+	CacheDir        string
+
+	// bound the whole call and each file; a snapshot download that runs past
+	// Deadline returns a *PartialDownloadError listing the files still missing
+	Deadline        time.Time
+	PerFileTimeout  time.Duration
+
+	// cancels the call, e.g. when the caller shuts down; a cancelled snapshot
+	// download returns a *PartialDownloadError like one past its Deadline
+	Context         context.Context
+
+	// called with the resolved files and sizes before anything is transferred;
+	// returning false fails the call with ErrDownloadDeclined
+	ConfirmFunc     func(plan DownloadPlan) bool
+
+	// most bytes the call may download, checked against the plan before
+	// anything is transferred; 0 disables it
+	MaxDownloadSize int64
+
+	// run after the client's hooks for this call only
+	Hooks           []Hook
+
+	// replaces Client.Limiter for this call, e.g. to give a large snapshot its
+	// own pool next to the shared one
+	Limiter         *Limiter
+
+	// replaces Client.LinkMode for this call, e.g. to get real files in a
+	// snapshot that is bind-mounted into a container; LinkSymlink undoes a
+	// client's real files for new links
+	LinkMode        MaterializeMode
+
+	// filled in by DownloadWithSummary
+	summary         *DownloadSummary
+
+	// set on the files of a snapshot, whose plan was already checked against
+	// the quotas and confirmed as a whole
+	planned         bool
+
+	// set up by Download from Deadline
+	ctx             context.Context
 }
 
+// ComponentDef overrides how a pipeline component is fetched and checked. A
+// nil entry in the components map skips the component.
 type ComponentDef struct {
-    ClassName string `json:"class_name"`
-    Source    string `json:"source"`
+    // class to use instead of the model index's, which decides whether the
+    // component has weights
+    ClassName string `json:"class_name,omitempty"`
+
+    // repo to fetch the component from instead of the pipeline's, from
+    // SubFolder there (the component name when empty)
+    Source    string `json:"source,omitempty"`
+    SubFolder string `json:"subfolder,omitempty"`
+
+    // weights variant for this component, e.g. "fp16"; the pipeline's when empty
+    Variant   string `json:"variant,omitempty"`
+
+    // Skip leaves the component out entirely. Required fails the download
+    // when its weights are missing, even for components such as the safety
+    // checker that are normally optional.
+    Skip      bool   `json:"skip,omitempty"`
+    Required  bool   `json:"required,omitempty"`
 }
 
 
@@ -122,6 +342,25 @@ type Repo struct {
 	Revision string
 }
 
+func NewRepo(id string) *Repo {
+	return &Repo{
+		Id:   id,
+		Type: ModelRepoType,
+	}
+}
+
+func (repo *Repo) WithType(repoType string) *Repo {
+	r := *repo
+	r.Type = repoType
+	return &r
+}
+
+func (repo *Repo) WithRevision(revision string) *Repo {
+	r := *repo
+	r.Revision = revision
+	return &r
+}
+
 type FileMetadata struct {
 	CommitHash string
 	ETag       string
@@ -0,0 +1,532 @@
+// Package hubtest provides an in-process fake of the Hugging Face Hub for
+// exercising download logic without network access.
+//
+//	srv := hubtest.NewServer()
+//	defer srv.Close()
+//	srv.AddRepo(hub.ModelRepoType, "org/model", map[string][]byte{
+//		"config.json":       []byte(`{}`),
+//		"model.safetensors": weights,
+//	})
+//	client := srv.NewClient(t.TempDir())
+package hubtest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-vault/model-cache/hub"
+)
+
+// lfsExtensions are stored as LFS objects, like the hub's default .gitattributes.
+var lfsExtensions = []string{".safetensors", ".bin", ".ckpt", ".pt", ".pth", ".onnx", ".gguf", ".h5", ".msgpack", ".zip", ".tar", ".gz"}
+
+type file struct {
+	content []byte
+	gitOid  string
+	sha256  string
+	lfs     bool
+}
+
+type commit struct {
+	sha   string
+	files map[string]*file
+}
+
+type repo struct {
+	commits map[string]*commit
+	// branch and tag names to commit hashes
+	refs  map[string]string
+	gated bool
+}
+
+// Server is a fake hub serving the model info, tree, resolve and raw
+// endpoints. LFS files redirect to a CDN path that supports range requests,
+// on the same host unless StartCDN was called.
+type Server struct {
+	*httptest.Server
+	// CDN serves the LFS files from another host once StartCDN was called.
+	CDN *httptest.Server
+
+	// TreePageSize splits tree listings into pages linked by a Link header;
+	// 0 returns everything at once.
+	TreePageSize int
+
+	mu          sync.Mutex
+	repos       map[string]*repo
+	rateLimited int
+	failures    int
+	failStatus  int
+	requests    map[string]int
+	headers     map[string]http.Header
+}
+
+func NewServer() *Server {
+	s := &Server{
+		repos:    map[string]*repo{},
+		requests: map[string]int{},
+		headers:  map[string]http.Header{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// StartCDN serves LFS files from a second server, on another host like the
+// hub's CDN, and redirects LFS downloads to it.
+func (s *Server) StartCDN() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.CDN == nil {
+		s.CDN = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	}
+}
+
+// Close shuts down the server and its CDN.
+func (s *Server) Close() {
+	if s.CDN != nil {
+		s.CDN.Close()
+	}
+	s.Server.Close()
+}
+
+// NewClient returns a client talking to the server with its cache in cacheDir.
+func (s *Server) NewClient(cacheDir string) *hub.Client {
+	return &hub.Client{
+		Endpoint:   s.URL,
+		CacheDir:   cacheDir,
+		UserAgent:  "hubtest",
+		HTTPClient: s.Client(),
+	}
+}
+
+// AddRepo commits files to the repo's main branch, creating the repo when
+// needed, and returns the commit hash. Files with weight and archive
+// extensions are served as LFS objects.
+func (s *Server) AddRepo(repoType string, id string, files map[string][]byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := repoKey(repoType, id)
+	r, ok := s.repos[key]
+	if !ok {
+		r = &repo{commits: map[string]*commit{}, refs: map[string]string{}}
+		s.repos[key] = r
+	}
+
+	c := &commit{files: map[string]*file{}}
+	hash := sha1.New()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := files[name]
+		sum := sha256.Sum256(content)
+		f := &file{
+			content: content,
+			gitOid:  gitBlobOid(content),
+			sha256:  hex.EncodeToString(sum[:]),
+			lfs:     isLFS(name),
+		}
+		c.files[name] = f
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, f.gitOid)
+	}
+	fmt.Fprintf(hash, "%d", len(r.commits))
+
+	c.sha = hex.EncodeToString(hash.Sum(nil))
+	r.commits[c.sha] = c
+	r.refs[hub.DefaultRevision] = c.sha
+
+	return c.sha
+}
+
+// SetRef points a branch or tag, e.g. "v1.0" or "refs/pr/1", at a commit.
+func (s *Server) SetRef(repoType string, id string, ref string, commitHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.repos[repoKey(repoType, id)]; ok {
+		r.refs[ref] = commitHash
+	}
+}
+
+// SetGated makes the repo answer 403 GatedRepo to file requests.
+func (s *Server) SetGated(repoType string, id string, gated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.repos[repoKey(repoType, id)]; ok {
+		r.gated = gated
+	}
+}
+
+// RateLimit answers the next n requests with 429 Too Many Requests.
+func (s *Server) RateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited = n
+}
+
+// FailNext answers the next n requests with the given status code.
+func (s *Server) FailNext(n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.failStatus = status
+}
+
+// Requests returns the number of requests received per method and path,
+// e.g. "HEAD /org/model/resolve/main/config.json".
+func (s *Server) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int, len(s.requests))
+	for k, v := range s.requests {
+		counts[k] = v
+	}
+	return counts
+}
+
+// RequestHeader returns the headers of the last request with the method and
+// path, as counted by Requests, or nil if there was none.
+func (s *Server) RequestHeader(key string) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headers[key].Clone()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.Method+" "+r.URL.Path]++
+	s.headers[r.Method+" "+r.URL.Path] = r.Header.Clone()
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		s.mu.Unlock()
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	if s.failures > 0 {
+		s.failures--
+		status := s.failStatus
+		s.mu.Unlock()
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	s.mu.Unlock()
+
+	// keep escaped slashes so revisions like refs%2Fpr%2F1 stay one segment
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segments[i] = unescaped
+		}
+	}
+
+	switch {
+	case len(segments) >= 2 && segments[0] == "lfs":
+		s.serveLFS(w, r, segments[1])
+	case len(segments) >= 1 && segments[0] == "api":
+		s.serveAPI(w, r, segments[1:])
+	default:
+		s.serveFile(w, r, segments)
+	}
+}
+
+// serveAPI handles /api/{type}s/{org}/{name}[/revision/{rev}|/tree/{rev}].
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) < 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	repoType := strings.TrimSuffix(segments[0], "s")
+	id := segments[1] + "/" + segments[2]
+	rest := segments[3:]
+
+	revision := hub.DefaultRevision
+	action := "info"
+	if len(rest) >= 2 {
+		action = rest[0]
+		revision = strings.Join(rest[1:], "/")
+	}
+
+	c, ok := s.resolve(w, repoType, id, revision)
+	if !ok {
+		return
+	}
+
+	switch action {
+	case "info", "revision", "resolve":
+		s.serveInfo(w, r, id, c)
+	case "tree":
+		s.serveTree(w, r, c)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request, id string, c *commit) {
+	type lfs struct {
+		Sha256      string `json:"sha256"`
+		Size        int    `json:"size"`
+		PointerSize int    `json:"pointerSize"`
+	}
+	type sibling struct {
+		RFileName string `json:"rfilename"`
+		Size      int    `json:"size,omitempty"`
+		BlobId    string `json:"blobId,omitempty"`
+		LFS       *lfs   `json:"lfs,omitempty"`
+	}
+
+	blobs := r.URL.Query().Get("blobs") == "true"
+	siblings := []sibling{}
+	for _, name := range sortedNames(c) {
+		f := c.files[name]
+		sib := sibling{RFileName: name}
+		if blobs {
+			sib.Size = len(f.content)
+			sib.BlobId = f.gitOid
+			if f.lfs {
+				sib.LFS = &lfs{Sha256: f.sha256, Size: len(f.content), PointerSize: len(lfsPointer(f))}
+			}
+		}
+		siblings = append(siblings, sib)
+	}
+
+	writeJSON(w, map[string]any{
+		"id":           id,
+		"sha":          c.sha,
+		"private":      false,
+		"gated":        false,
+		"lastModified": time.Now().UTC().Format(time.RFC3339),
+		"siblings":     siblings,
+	})
+}
+
+func (s *Server) serveTree(w http.ResponseWriter, r *http.Request, c *commit) {
+	type lfs struct {
+		Oid         string `json:"oid"`
+		Size        int    `json:"size"`
+		PointerSize int    `json:"pointerSize"`
+	}
+	type entry struct {
+		Type string `json:"type"`
+		Oid  string `json:"oid"`
+		Size int    `json:"size"`
+		Path string `json:"path"`
+		LFS  *lfs   `json:"lfs,omitempty"`
+	}
+
+	names := sortedNames(c)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	end := len(names)
+	if s.TreePageSize > 0 && start+s.TreePageSize < end {
+		end = start + s.TreePageSize
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", strconv.Itoa(end))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="next"`, s.URL, next.RequestURI()))
+	}
+	if start > end {
+		start = end
+	}
+
+	entries := []entry{}
+	for _, name := range names[start:end] {
+		f := c.files[name]
+		e := entry{Type: "file", Oid: f.gitOid, Size: len(f.content), Path: name}
+		if f.lfs {
+			e.Size = len(lfsPointer(f))
+			e.LFS = &lfs{Oid: f.sha256, Size: len(f.content), PointerSize: e.Size}
+		}
+		entries = append(entries, e)
+	}
+
+	writeJSON(w, entries)
+}
+
+// serveFile handles /[{type}s/]{org}/{name}/{resolve|raw}/{rev}/{path}.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, segments []string) {
+	repoType := hub.ModelRepoType
+	if len(segments) > 0 && (segments[0] == "datasets" || segments[0] == "spaces") {
+		repoType = strings.TrimSuffix(segments[0], "s")
+		segments = segments[1:]
+	}
+	if len(segments) < 5 || (segments[2] != "resolve" && segments[2] != "raw") {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := segments[0] + "/" + segments[1]
+	revision, fileName := segments[3], strings.Join(segments[4:], "/")
+
+	// refs/pr/N revisions may arrive unescaped
+	if revision == "refs" && len(segments) >= 7 && segments[4] == "pr" {
+		revision = strings.Join(segments[3:6], "/")
+		fileName = strings.Join(segments[6:], "/")
+	}
+
+	c, ok := s.resolve(w, repoType, id, revision)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	gated := s.repos[repoKey(repoType, id)].gated
+	s.mu.Unlock()
+	if gated {
+		w.Header().Set("X-Error-Code", "GatedRepo")
+		http.Error(w, "gated repository", http.StatusForbidden)
+		return
+	}
+
+	f, ok := c.files[fileName]
+	if !ok {
+		w.Header().Set("X-Error-Code", "EntryNotFound")
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Repo-Commit", c.sha)
+
+	if segments[2] == "raw" {
+		content := f.content
+		if f.lfs {
+			content = lfsPointer(f)
+		}
+		w.Header().Set("ETag", `"`+gitBlobOid(content)+`"`)
+		http.ServeContent(w, r, path.Base(fileName), time.Time{}, bytes.NewReader(content))
+		return
+	}
+
+	if f.lfs {
+		w.Header().Set("X-Linked-Etag", `"`+f.sha256+`"`)
+		w.Header().Set("X-Linked-Size", strconv.Itoa(len(f.content)))
+		w.Header().Set("ETag", `"`+gitBlobOid(lfsPointer(f))+`"`)
+		w.Header().Set("Location", s.cdnURL()+"/lfs/"+f.sha256)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+f.gitOid+`"`)
+	http.ServeContent(w, r, path.Base(fileName), time.Time{}, bytes.NewReader(f.content))
+}
+
+func (s *Server) cdnURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.CDN != nil {
+		return s.CDN.URL
+	}
+	return s.URL
+}
+
+// serveLFS plays the CDN LFS files are redirected to.
+func (s *Server) serveLFS(w http.ResponseWriter, r *http.Request, sha string) {
+	s.mu.Lock()
+	var content []byte
+	found := false
+	for _, repo := range s.repos {
+		for _, c := range repo.commits {
+			for _, f := range c.files {
+				if f.lfs && f.sha256 == sha {
+					content, found = f.content, true
+				}
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+sha+`"`)
+	http.ServeContent(w, r, sha, time.Time{}, bytes.NewReader(content))
+}
+
+// resolve looks up the commit of a revision, writing the hub's error response
+// when the repo or revision doesn't exist.
+func (s *Server) resolve(w http.ResponseWriter, repoType string, id string, revision string) (*commit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.repos[repoKey(repoType, id)]
+	if !ok {
+		w.Header().Set("X-Error-Code", "RepoNotFound")
+		http.Error(w, "repository not found", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if sha, ok := r.refs[revision]; ok {
+		revision = sha
+	}
+	c, ok := r.commits[revision]
+	if !ok {
+		w.Header().Set("X-Error-Code", "RevisionNotFound")
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return c, true
+}
+
+func repoKey(repoType string, id string) string {
+	if repoType == "" {
+		repoType = hub.ModelRepoType
+	}
+	return repoType + "/" + id
+}
+
+func isLFS(name string) bool {
+	for _, ext := range lfsExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func gitBlobOid(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func lfsPointer(f *file) []byte {
+	return []byte(fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", f.sha256, len(f.content)))
+}
+
+func sortedNames(c *commit) []string {
+	names := make([]string, 0, len(c.files))
+	for name := range c.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,589 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrBlockHashMismatch is returned when a gateway sends a block that doesn't
+// hash to its CID.
+var ErrBlockHashMismatch = errors.New("block does not match its CID")
+
+// DefaultIPFSGateways serve raw blocks (?format=raw) for trustless retrieval.
+var DefaultIPFSGateways = []string{
+	"https://trustless-gateway.link",
+	"https://ipfs.io",
+	"https://dweb.link",
+}
+
+// IPFSSource downloads a UnixFS file from IPFS gateways block by block. Each
+// block is checked against the sha2-256 multihash in its CID, so a gateway
+// can't serve different content than the CID names. IPNS names and DNSLink
+// domains are resolved by the gateway, which has to be trusted for that step.
+type IPFSSource struct {
+	// ipfs://<cid>[/path], ipns://<name>[/path], /ipfs/..., /ipns/... or a bare CID
+	location string
+	gateways []string
+	client   *http.Client
+	workers  int
+	limiter  *Limiter
+
+	progressMu sync.Mutex
+	root       *ipfsBlock
+	rootCID    string
+}
+
+// NewIPFSSource fetches location from gateways, DefaultIPFSGateways when none
+// are given.
+func NewIPFSSource(location string, gateways ...string) *IPFSSource {
+	if len(gateways) == 0 {
+		gateways = DefaultIPFSGateways
+	}
+	return &IPFSSource{location: location, gateways: gateways, client: defaultHTTPClient, workers: 16}
+}
+
+// WithLimiter makes each block request wait for a slot of l, usually the
+// Client.Limiter shared with hub downloads.
+func (s *IPFSSource) WithLimiter(l *Limiter) *IPFSSource {
+	s.limiter = l
+	return s
+}
+
+func (s *IPFSSource) GetFileInfo() (*FileInfo, error) {
+	root, err := s.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(strings.TrimRight(s.location, "/"))
+	if name == "" || name == "." || name == "/" || strings.HasSuffix(s.location, s.rootCID) {
+		name = s.rootCID
+	}
+	return &FileInfo{
+		URL:      strings.TrimSuffix(s.gateways[0], "/") + "/ipfs/" + s.rootCID,
+		Size:     root.fileSize(),
+		Filename: name,
+	}, nil
+}
+
+func (s *IPFSSource) Download(destPath string, progress *mpb.Progress) error {
+	root, err := s.resolve()
+	if err != nil {
+		return err
+	}
+	if root.unixfsType != unixfsFile && root.unixfsType != unixfsRaw {
+		return fmt.Errorf("%s is not a file", s.location)
+	}
+
+	tmpPath := destPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	size := root.fileSize()
+	s.progressMu.Lock()
+	bar := progress.AddBar(size,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(path.Base(destPath), decor.WC{W: 40, C: decor.DidentRight}),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(
+			decor.EwmaETA(decor.ET_STYLE_GO, 90),
+			decor.Name(" ] "),
+			decor.EwmaSpeed(decor.UnitKiB, "% .2f", 60),
+		),
+	)
+	s.progressMu.Unlock()
+
+	g := &errgroup.Group{}
+	g.SetLimit(s.workers)
+	var written int64
+	var writtenMu sync.Mutex
+
+	var visit func(block *ipfsBlock, offset int64) error
+	visit = func(block *ipfsBlock, offset int64) error {
+		if len(block.data) > 0 {
+			if _, err := out.WriteAt(block.data, offset); err != nil {
+				return err
+			}
+			bar.IncrInt64(int64(len(block.data)))
+			writtenMu.Lock()
+			written += int64(len(block.data))
+			writtenMu.Unlock()
+		}
+
+		offset += int64(len(block.data))
+		for i, link := range block.links {
+			childOffset := offset
+			if i < len(block.blockSizes) {
+				offset += int64(block.blockSizes[i])
+			} else if len(block.links) > 1 {
+				return fmt.Errorf("file node without block sizes")
+			}
+
+			cid := link.cid
+			fetch := func() error {
+				child, err := s.fetchBlock(cid)
+				if err != nil {
+					return err
+				}
+				return visit(child, childOffset)
+			}
+			// children run inline when every worker is busy, so a parent
+			// never waits for a slot its own children hold
+			if !g.TryGo(fetch) {
+				if err := fetch(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err = visit(root, 0)
+	if waitErr := g.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		bar.Abort(true)
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("size mismatch for %s: expected %d, got %d", s.location, size, written)
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	out.Close()
+	return os.Rename(tmpPath, destPath)
+}
+
+// resolve finds the CID of the file, following IPNS names and paths inside
+// directories.
+func (s *IPFSSource) resolve() (*ipfsBlock, error) {
+	if s.root != nil {
+		return s.root, nil
+	}
+
+	namespace, name, rest := splitIPFSLocation(s.location)
+	if namespace == "ipns" {
+		cid, err := s.resolveName(name)
+		if err != nil {
+			return nil, err
+		}
+		name = cid
+	}
+
+	block, err := s.fetchBlock(name)
+	if err != nil {
+		return nil, err
+	}
+	cid := name
+
+	for _, segment := range rest {
+		if block.unixfsType == unixfsHAMTShard {
+			return nil, fmt.Errorf("sharded directory in %s is not supported", s.location)
+		}
+		if block.unixfsType != unixfsDirectory {
+			return nil, fmt.Errorf("%s is not a directory in %s", segment, s.location)
+		}
+		var next *ipfsLink
+		for i := range block.links {
+			if block.links[i].name == segment {
+				next = &block.links[i]
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s not found in %s", segment, s.location)
+		}
+		if block, err = s.fetchBlock(next.cid); err != nil {
+			return nil, err
+		}
+		cid = next.cid
+	}
+
+	s.root, s.rootCID = block, cid
+	return block, nil
+}
+
+// resolveName asks a gateway for the CID an IPNS name or DNSLink points at.
+func (s *IPFSSource) resolveName(name string) (string, error) {
+	var lastErr error
+	for _, gateway := range s.gateways {
+		req, err := http.NewRequest("HEAD", strings.TrimSuffix(gateway, "/")+"/ipns/"+name+"/", nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		// X-Ipfs-Roots lists the CIDs along the path, the first is the name's target
+		if roots := resp.Header.Get("X-Ipfs-Roots"); roots != "" {
+			return strings.TrimSpace(strings.Split(roots, ",")[0]), nil
+		}
+		if p := resp.Header.Get("X-Ipfs-Path"); strings.HasPrefix(p, "/ipfs/") {
+			return strings.Split(strings.TrimPrefix(p, "/ipfs/"), "/")[0], nil
+		}
+		lastErr = fmt.Errorf("gateway %s did not resolve %s", gateway, name)
+	}
+	return "", fmt.Errorf("failed to resolve /ipns/%s: %w", name, lastErr)
+}
+
+// fetchBlock downloads a raw block from the first gateway that serves it and
+// checks it against the CID.
+func (s *IPFSSource) fetchBlock(cidString string) (*ipfsBlock, error) {
+	cid, err := parseCID(cidString)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, gateway := range s.gateways {
+		req, err := http.NewRequest("GET", strings.TrimSuffix(gateway, "/")+"/ipfs/"+cidString+"?format=raw", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+		data, err := s.get(req)
+		if err == nil {
+			if sum := sha256.Sum256(data); !bytes.Equal(sum[:], cid.digest) {
+				err = fmt.Errorf("%w: %s from %s", ErrBlockHashMismatch, cidString, gateway)
+			}
+		}
+		if err != nil {
+			lastErr = err
+			log.Printf("[Download] %v", err)
+			continue
+		}
+
+		if cid.codec == codecRaw {
+			return &ipfsBlock{unixfsType: unixfsRaw, data: data}, nil
+		}
+		return decodeDagPB(data)
+	}
+	return nil, fmt.Errorf("failed to fetch block %s: %w", cidString, lastErr)
+}
+
+// largest block the IPFS network exchanges
+const maxIPFSBlockSize = 4 << 20
+
+func (s *IPFSSource) get(req *http.Request) ([]byte, error) {
+	release, err := s.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxIPFSBlockSize+1))
+}
+
+func splitIPFSLocation(location string) (namespace string, name string, rest []string) {
+	namespace = "ipfs"
+	switch {
+	case strings.HasPrefix(location, "ipfs://"):
+		location = strings.TrimPrefix(location, "ipfs://")
+	case strings.HasPrefix(location, "ipns://"):
+		namespace, location = "ipns", strings.TrimPrefix(location, "ipns://")
+	case strings.HasPrefix(location, "/ipfs/"):
+		location = strings.TrimPrefix(location, "/ipfs/")
+	case strings.HasPrefix(location, "/ipns/"):
+		namespace, location = "ipns", strings.TrimPrefix(location, "/ipns/")
+	}
+
+	for _, part := range strings.Split(location, "/") {
+		if part == "" {
+			continue
+		}
+		if name == "" {
+			name = part
+			continue
+		}
+		rest = append(rest, part)
+	}
+	return namespace, name, rest
+}
+
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	hashSHA2256 = 0x12
+)
+
+type ipfsCID struct {
+	codec  uint64
+	digest []byte
+}
+
+// parseCID decodes a CIDv0 (Qm...) or a base32/base58 CIDv1 string. Only
+// sha2-256 hashes can be verified.
+func parseCID(s string) (*ipfsCID, error) {
+	var raw []byte
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		raw = base58Decode(s)
+	case strings.HasPrefix(s, "b"):
+		decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(s[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID %s: %w", s, err)
+		}
+		raw = decoded
+	case strings.HasPrefix(s, "z"):
+		raw = base58Decode(s[1:])
+	default:
+		return nil, fmt.Errorf("unsupported CID encoding: %s", s)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("invalid CID %s", s)
+	}
+	return decodeBinaryCID(raw)
+}
+
+func decodeBinaryCID(raw []byte) (*ipfsCID, error) {
+	cid := &ipfsCID{codec: codecDagPB}
+	// CIDv0 is a bare sha2-256 multihash
+	if len(raw) != 34 || raw[0] != hashSHA2256 {
+		version, n := binary.Uvarint(raw)
+		if n <= 0 || version != 1 {
+			return nil, fmt.Errorf("unsupported CID version")
+		}
+		raw = raw[n:]
+		codec, n := binary.Uvarint(raw)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid CID codec")
+		}
+		cid.codec = codec
+		raw = raw[n:]
+	}
+
+	hash, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid multihash")
+	}
+	raw = raw[n:]
+	length, n := binary.Uvarint(raw)
+	if n <= 0 || uint64(len(raw)-n) != length {
+		return nil, fmt.Errorf("invalid multihash length")
+	}
+	if hash != hashSHA2256 {
+		return nil, fmt.Errorf("unsupported multihash 0x%x, only sha2-256 is verified", hash)
+	}
+	if cid.codec != codecDagPB && cid.codec != codecRaw {
+		return nil, fmt.Errorf("unsupported CID codec 0x%x", cid.codec)
+	}
+	cid.digest = raw[n:]
+	return cid, nil
+}
+
+// encodeCID formats a binary CID from a dag-pb link the way gateways expect.
+func encodeCID(raw []byte) string {
+	if len(raw) == 34 && raw[0] == hashSHA2256 {
+		return base58Encode(raw)
+	}
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Decode(s string) []byte {
+	n := new(big.Int)
+	for _, c := range []byte(s) {
+		i := strings.IndexByte(base58Alphabet, c)
+		if i < 0 {
+			return nil
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	decoded := n.Bytes()
+	for i := 0; i < len(s) && s[i] == '1'; i++ {
+		decoded = append([]byte{0}, decoded...)
+	}
+	return decoded
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	var out []byte
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, big.NewInt(58), mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < len(data) && data[i] == 0; i++ {
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// UnixFS data types
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsHAMTShard = 5
+)
+
+// ipfsBlock is a decoded UnixFS node; data is the file content stored in the
+// node itself, followed by the content of links.
+type ipfsBlock struct {
+	unixfsType uint64
+	data       []byte
+	size       uint64
+	blockSizes []uint64
+	links      []ipfsLink
+}
+
+type ipfsLink struct {
+	cid  string
+	name string
+}
+
+func (b *ipfsBlock) fileSize() int64 {
+	if b.unixfsType == unixfsRaw && len(b.links) == 0 {
+		return int64(len(b.data))
+	}
+	return int64(b.size)
+}
+
+// decodeDagPB parses a dag-pb node (PBNode: Data = 1, Links = 2) and its
+// UnixFS Data message (Type = 1, Data = 2, filesize = 3, blocksizes = 4).
+func decodeDagPB(data []byte) (*ipfsBlock, error) {
+	block := &ipfsBlock{}
+	var unixfs []byte
+
+	err := walkProtobuf(data, func(field uint64, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			unixfs = value
+		case 2:
+			var link ipfsLink
+			err := walkProtobuf(value, func(field uint64, value []byte, _ uint64) error {
+				switch field {
+				case 1:
+					link.cid = encodeCID(value)
+				case 2:
+					link.name = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			block.links = append(block.links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid dag-pb node: %w", err)
+	}
+
+	err = walkProtobuf(unixfs, func(field uint64, value []byte, number uint64) error {
+		switch field {
+		case 1:
+			block.unixfsType = number
+		case 2:
+			block.data = value
+		case 3:
+			block.size = number
+		case 4:
+			if value == nil {
+				block.blockSizes = append(block.blockSizes, number)
+				return nil
+			}
+			// packed encoding
+			for len(value) > 0 {
+				size, n := binary.Uvarint(value)
+				if n <= 0 {
+					return fmt.Errorf("invalid block size")
+				}
+				block.blockSizes = append(block.blockSizes, size)
+				value = value[n:]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid unixfs data: %w", err)
+	}
+	if block.unixfsType == unixfsRaw {
+		// a raw node inside dag-pb is a file leaf
+		block.unixfsType = unixfsFile
+		block.size = uint64(len(block.data))
+	}
+	return block, nil
+}
+
+// walkProtobuf calls fn for each field with either its bytes (length-delimited
+// fields) or its number (varints).
+func walkProtobuf(data []byte, fn func(field uint64, value []byte, number uint64) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0:
+			number, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint")
+			}
+			data = data[n:]
+			if err := fn(field, nil, number); err != nil {
+				return err
+			}
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("invalid length")
+			}
+			value := data[n : n+int(length)]
+			data = data[n+int(length):]
+			if err := fn(field, value, 0); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build go1.23
+
+package hub
+
+import "iter"
+
+// Models ranges over the models matching filter, following the API's pages
+// as the loop advances. An error ends the sequence as its last pair.
+//
+//	for model, err := range client.Models(&hub.RepoFilter{Author: "black-forest-labs"}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(model.Id)
+//	}
+func (client *Client) Models(filter *RepoFilter) iter.Seq2[ModelInfo, error] {
+	return func(yield func(ModelInfo, error) bool) {
+		walkSeq(yield, func(visit func(ModelInfo) bool) error {
+			return walkModels(client, filter, visit)
+		})
+	}
+}
+
+// Datasets ranges over the datasets matching filter like Models.
+func (client *Client) Datasets(filter *RepoFilter) iter.Seq2[DatasetInfo, error] {
+	return func(yield func(DatasetInfo, error) bool) {
+		walkSeq(yield, func(visit func(DatasetInfo) bool) error {
+			return walkDatasets(client, filter, visit)
+		})
+	}
+}
+
+// Tree ranges over the files of a repo at revision.
+func (client *Client) Tree(repo *Repo, revision string) iter.Seq2[TreeEntry, error] {
+	return func(yield func(TreeEntry, error) bool) {
+		walkSeq(yield, func(visit func(TreeEntry) bool) error {
+			return walkRepoTree(client, repo, revision, visit)
+		})
+	}
+}
+
+// Commits ranges over the history of revision, newest first.
+func (client *Client) Commits(repo *Repo, revision string) iter.Seq2[Commit, error] {
+	return func(yield func(Commit, error) bool) {
+		walkSeq(yield, func(visit func(Commit) bool) error {
+			return walkCommits(client, repo, revision, visit)
+		})
+	}
+}
+
+// walkSeq feeds a walker's items to yield, then its error, if any.
+func walkSeq[T any](yield func(T, error) bool, walk func(visit func(T) bool) error) {
+	stopped := false
+	err := walk(func(item T) bool {
+		if !yield(item, nil) {
+			stopped = true
+		}
+		return !stopped
+	})
+	if err != nil && !stopped {
+		var zero T
+		yield(zero, err)
+	}
+}
@@ -0,0 +1,65 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// LikedRepo is a repo a user liked.
+type LikedRepo struct {
+	Repo    *Repo
+	LikedAt time.Time
+}
+
+// Like likes a repo on behalf of the token's user. Liking a repo twice is not
+// an error.
+func (client *Client) Like(repo *Repo) error {
+	if client.Token == "" {
+		return fmt.Errorf("liking %s requires a token", repo.Id)
+	}
+	if err := apiRequest(client, "POST", likeURL(client, repo), nil, nil); err != nil {
+		return fmt.Errorf("failed to like %s: %w", repo.Id, err)
+	}
+	return nil
+}
+
+// Unlike removes the token's user's like from a repo.
+func (client *Client) Unlike(repo *Repo) error {
+	if client.Token == "" {
+		return fmt.Errorf("unliking %s requires a token", repo.Id)
+	}
+	if err := apiRequest(client, "DELETE", likeURL(client, repo), nil, nil); err != nil {
+		return fmt.Errorf("failed to unlike %s: %w", repo.Id, err)
+	}
+	return nil
+}
+
+// ListLikes lists the models, datasets and Spaces a user liked, most recent
+// first.
+func (client *Client) ListLikes(user string) ([]LikedRepo, error) {
+	var result []struct {
+		CreatedAt time.Time `json:"createdAt"`
+		Repo      struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"repo"`
+	}
+	likesURL := fmt.Sprintf("%s/api/users/%s/likes", client.Endpoint, url.PathEscape(user))
+	if err := apiRequest(client, "GET", likesURL, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to list likes of %s: %w", user, err)
+	}
+
+	likes := make([]LikedRepo, 0, len(result))
+	for _, like := range result {
+		likes = append(likes, LikedRepo{
+			Repo:    &Repo{Id: like.Repo.Name, Type: like.Repo.Type},
+			LikedAt: like.CreatedAt,
+		})
+	}
+	return likes, nil
+}
+
+func likeURL(client *Client, repo *Repo) string {
+	return fmt.Sprintf("%s/api/%s/%s/like", client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id)
+}
@@ -0,0 +1,41 @@
+package hub
+
+import "context"
+
+// Limiter bounds the transfers running at once across every download that
+// shares it: hub files, mirror ranges, and Civitai, URL, torrent and IPFS
+// sources. Each HTTP body being read takes one slot, so a file fetched in
+// four ranges holds four.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter allows n transfers at once.
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		n = 1
+	}
+	return &Limiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a slot. A nil Limiter never blocks.
+func (l *Limiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WithLimiter shares l with every download made through the returned client,
+// so separate Download calls, pipelines and snapshots running at once stay
+// within its slots.
+func (client *Client) WithLimiter(l *Limiter) *Client {
+	c := client.clone()
+	c.Limiter = l
+	return c
+}
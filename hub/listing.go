@@ -0,0 +1,119 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RepoFilter narrows model and dataset listings. The zero value lists every
+// public repo.
+type RepoFilter struct {
+	Search string
+	Author string
+	// Tags the repos must all have, e.g. "text-to-image" or "gguf"
+	Tags []string
+	// Sort is a field such as "downloads", "likes" or "lastModified", in
+	// descending order; empty keeps the API's order
+	Sort string
+	// Limit stops the listing after that many repos; 0 lists all
+	Limit int
+}
+
+func (f *RepoFilter) query() url.Values {
+	query := url.Values{}
+	if f == nil {
+		return query
+	}
+	if f.Search != "" {
+		query.Set("search", f.Search)
+	}
+	if f.Author != "" {
+		query.Set("author", f.Author)
+	}
+	for _, tag := range f.Tags {
+		query.Add("filter", tag)
+	}
+	if f.Sort != "" {
+		query.Set("sort", f.Sort)
+		query.Set("direction", "-1")
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return query
+}
+
+// DatasetInfo is a dataset as listed by the API.
+type DatasetInfo struct {
+	Id           string    `json:"id"`
+	Author       string    `json:"author,omitempty"`
+	Sha          string    `json:"sha,omitempty"`
+	Private      bool      `json:"private"`
+	Gated        GatedMode `json:"gated"`
+	Downloads    int64     `json:"downloads"`
+	Likes        int64     `json:"likes"`
+	Tags         []string  `json:"tags,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Commit is an entry of a revision's history.
+type Commit struct {
+	Id      string    `json:"id"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+	Authors []struct {
+		User string `json:"user"`
+	} `json:"authors"`
+}
+
+// walkModels calls visit for every model matching filter until it returns
+// false. Listed models carry no siblings.
+func walkModels(client *Client, filter *RepoFilter, visit func(ModelInfo) bool) error {
+	return walkRepos(client, ModelRepoType, filter, visit)
+}
+
+// walkDatasets calls visit for every dataset matching filter until it returns
+// false.
+func walkDatasets(client *Client, filter *RepoFilter, visit func(DatasetInfo) bool) error {
+	return walkRepos(client, DatasetRepoType, filter, visit)
+}
+
+func walkRepos[T any](client *Client, repoType string, filter *RepoFilter, visit func(T) bool) error {
+	listURL := fmt.Sprintf("%s/api/%s", client.Endpoint, repoTypeAPIPath(repoType))
+	if query := filter.query(); len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+
+	// the API pages past a limit, so it is enforced here as well
+	seen := 0
+	err := apiPages(client, listURL, func(item T) bool {
+		seen++
+		if !visit(item) {
+			return false
+		}
+		return filter == nil || filter.Limit <= 0 || seen < filter.Limit
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", repoTypeAPIPath(repoType), err)
+	}
+	return nil
+}
+
+// walkCommits calls visit for every commit in the history of revision,
+// newest first, until it returns false.
+func walkCommits(client *Client, repo *Repo, revision string, visit func(Commit) bool) error {
+	if revision == "" {
+		revision = DefaultRevision
+	}
+
+	commitsURL := fmt.Sprintf("%s/api/%s/%s/commits/%s",
+		client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id, url.PathEscape(revision))
+	if err := apiPages(client, commitsURL, visit); err != nil {
+		return fmt.Errorf("failed to list commits of %s: %w", repo.Id, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+)
+
+// MaterializeMode is how MaterializeSnapshot places files in the target
+// directory.
+type MaterializeMode string
+
+const (
+	// MaterializeCopy writes an independent copy of every file.
+	MaterializeCopy MaterializeMode = "copy"
+	// MaterializeHardlink links the cached blobs. Editing the exported files
+	// in place changes the cache as well.
+	MaterializeHardlink MaterializeMode = "hardlink"
+	// MaterializeReflink clones the blobs on filesystems with copy-on-write
+	// (btrfs, XFS, ...), so the export costs no space until it's modified.
+	MaterializeReflink MaterializeMode = "reflink"
+	// LinkSymlink is the default LinkMode of snapshots, a symlink into blobs/.
+	// It can't be used to materialize snapshots.
+	LinkSymlink MaterializeMode = "symlink"
+)
+
+// MaterializeSnapshot exports the files of a snapshot into destDir as regular
+// files, resolving the symlinks into blobs, for tools that refuse symlinked
+// layouts. Files keep their paths relative to the snapshot. Hardlinks across
+// filesystems and reflinks on filesystems that can't clone fall back to a
+// copy.
+func MaterializeSnapshot(snapshotPath string, destDir string, mode MaterializeMode) error {
+	switch mode {
+	case MaterializeCopy, MaterializeHardlink, MaterializeReflink:
+	case "":
+		mode = MaterializeCopy
+	default:
+		return fmt.Errorf("unknown materialize mode %q", mode)
+	}
+
+	type exportFile struct {
+		source string
+		rel    string
+		size   int64
+	}
+
+	var files []exportFile
+	var total int64
+	err := filepath.WalkDir(snapshotPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		source, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(snapshotPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, exportFile{source: source, rel: rel, size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list snapshot: %w", err)
+	}
+
+	progress := NewProgress()
+	bar := progress.AddBar(total,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(filepath.Base(destDir), decor.WC{W: 40, C: decor.DidentRight}),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(decor.Percentage()),
+	)
+
+	for _, file := range files {
+		dest := filepath.Join(destDir, file.rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			bar.Abort(true)
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := materializeFile(file.source, dest, mode, bar); err != nil {
+			bar.Abort(true)
+			return fmt.Errorf("failed to export %s: %w", file.rel, err)
+		}
+	}
+
+	progress.Wait()
+	return nil
+}
+
+// materializeFile replaces dest with src, counting the bytes on bar.
+func materializeFile(src string, dest string, mode MaterializeMode, bar *mpb.Bar) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	// never write through an existing hardlink into the cache
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if mode == MaterializeHardlink {
+		if err := os.Link(src, dest); err == nil {
+			bar.IncrInt64(info.Size())
+			return nil
+		} else {
+			log.Printf("[Download] Hardlink of %s failed, copying: %v", filepath.Base(dest), err)
+		}
+	}
+
+	tmpPath := dest + ".tmp"
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cloned := false
+	if mode == MaterializeReflink {
+		if err := reflink(out, in); err == nil {
+			cloned = true
+			bar.IncrInt64(info.Size())
+		} else {
+			log.Printf("[Download] Reflink of %s failed, copying: %v", filepath.Base(dest), err)
+		}
+	}
+	if !cloned {
+		if _, err := io.Copy(out, bar.ProxyReader(in)); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dest)
+}
+
+// realFiles reports whether snapshots get real files rather than symlinks.
+func (client *Client) realFiles() bool {
+	return client.LinkMode != "" && client.LinkMode != LinkSymlink
+}
+
+func isSymlink(client *Client, path string) bool {
+	info, err := client.fs().Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// placeSnapshotFile puts blobPath at pointerPath as a real file following
+// client.LinkMode. Hardlinks and reflinks that fail fall back to a copy.
+func placeSnapshotFile(client *Client, blobPath string, pointerPath string) error {
+	if err := client.mkdirAll(filepath.Dir(pointerPath)); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	// never write through an existing hardlink into the blob
+	if _, err := client.fs().Lstat(pointerPath); err == nil {
+		client.fs().Remove(pointerPath)
+	}
+
+	if client.LinkMode == MaterializeHardlink {
+		if err := os.Link(blobPath, pointerPath); err == nil {
+			return nil
+		} else {
+			log.Printf("[Download] Hardlink of %s failed, copying: %v", filepath.Base(pointerPath), err)
+		}
+	}
+
+	in, err := client.fs().OpenFile(blobPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open blob: %w", err)
+	}
+	defer in.Close()
+
+	tmpPath := pointerPath + ".tmp"
+	out, err := client.openFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer out.Close()
+
+	cloned := false
+	if client.LinkMode == MaterializeReflink {
+		src, srcOK := in.(*os.File)
+		dst, dstOK := out.(*os.File)
+		if srcOK && dstOK && reflink(dst, src) == nil {
+			cloned = true
+		} else {
+			log.Printf("[Download] Reflink of %s failed, copying", filepath.Base(pointerPath))
+		}
+	}
+	if !cloned {
+		if _, err := io.Copy(out, in); err != nil {
+			client.fs().Remove(tmpPath)
+			return fmt.Errorf("failed to copy blob: %w", err)
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		client.fs().Remove(tmpPath)
+		return err
+	}
+	return client.fs().Rename(tmpPath, pointerPath)
+}
@@ -0,0 +1,523 @@
+package hub
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an FS held in memory, so code using the cache can be tested
+// without touching the disk, e.g. Client{CacheDir: "/cache", FS: NewMemFS()}.
+// Symlinks are followed like on disk, with relative targets resolved against
+// the link's folder. Ownership isn't tracked, so Lchown only checks the path.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	data    []byte
+	target  string
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{nodes: map[string]*memNode{
+		string(filepath.Separator): {mode: fs.ModeDir | 0755, modTime: now},
+		".":                        {mode: fs.ModeDir | 0755, modTime: now},
+	}}
+}
+
+// resolve returns the key of name with the symlinks along it followed, and
+// the last one too when followLast. The key may not exist yet.
+func (m *MemFS) resolve(op string, name string, followLast bool) (string, error) {
+	sep := string(filepath.Separator)
+	resolved := "."
+	if filepath.IsAbs(name) {
+		resolved = sep
+	}
+	parts := strings.Split(filepath.Clean(name), sep)
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		node := m.nodes[next]
+		if node != nil && node.mode&fs.ModeSymlink != 0 && (len(parts) > 0 || followLast) {
+			links++
+			if links > maxSymlinks {
+				return "", &fs.PathError{Op: op, Path: name, Err: errors.New("too many links")}
+			}
+			if filepath.IsAbs(node.target) {
+				resolved = sep
+			}
+			parts = append(strings.Split(filepath.Clean(node.target), sep), parts...)
+			continue
+		}
+		if len(parts) > 0 && (node == nil || !node.mode.IsDir()) {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		resolved = next
+	}
+	return resolved, nil
+}
+
+// lookup returns the node at name, with errors worded like the os package's.
+func (m *MemFS) lookup(op string, name string, followLast bool) (string, *memNode, error) {
+	key, err := m.resolve(op, name, followLast)
+	if err != nil {
+		return "", nil, err
+	}
+	node := m.nodes[key]
+	if node == nil {
+		return key, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return key, node, nil
+}
+
+// parentDir checks that the folder key would be created in exists.
+func (m *MemFS) parentDir(op string, name string, key string) error {
+	if parent := m.nodes[filepath.Dir(key)]; parent == nil || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// children lists the keys directly in the folder key.
+func (m *MemFS) children(key string) []string {
+	var keys []string
+	for k := range m.nodes {
+		if k != key && filepath.Dir(k) == key {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// descendants lists the keys below the folder key, at any depth.
+func (m *MemFS) descendants(key string) []string {
+	prefix := key + string(filepath.Separator)
+	if strings.HasSuffix(key, string(filepath.Separator)) {
+		prefix = key
+	}
+	var keys []string
+	for k := range m.nodes {
+		// relative keys have no "./" prefix
+		below := strings.HasPrefix(k, prefix) || (key == "." && !filepath.IsAbs(k))
+		if k != key && below {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(filepath.Base(key)), nil
+}
+
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(filepath.Base(key)), nil
+}
+
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range m.children(key) {
+		entries = append(entries, fs.FileInfoToDirEntry(m.nodes[child].info(filepath.Base(child))))
+	}
+	return entries, nil
+}
+
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if key, err := m.resolve("mkdir", dir, true); err == nil {
+			if node := m.nodes[key]; node != nil {
+				if !node.mode.IsDir() {
+					return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+				}
+				break
+			}
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		key, err := m.resolve("mkdir", missing[i], true)
+		if err != nil {
+			return err
+		}
+		m.nodes[key] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldKey, node, err := m.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	newKey, err := m.resolve("rename", newpath, false)
+	if err != nil {
+		return err
+	}
+	if err := m.parentDir("rename", newpath, newKey); err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if existing := m.nodes[newKey]; existing != nil {
+		if existing.mode.IsDir() != node.mode.IsDir() || len(m.children(newKey)) > 0 {
+			return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrExist}
+		}
+	}
+
+	moved := m.descendants(oldKey)
+	for _, k := range moved {
+		m.nodes[newKey+strings.TrimPrefix(k, oldKey)] = m.nodes[k]
+		delete(m.nodes, k)
+	}
+	delete(m.nodes, oldKey)
+	m.nodes[newKey] = node
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() && len(m.children(key)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	delete(m.nodes, key)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("removeall", path, false)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if node.mode.IsDir() {
+		for _, k := range m.descendants(key) {
+			delete(m.nodes, k)
+		}
+	}
+	delete(m.nodes, key)
+	return nil
+}
+
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.resolve("symlink", newname, false)
+	if err != nil {
+		return err
+	}
+	if m.nodes[key] != nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	if err := m.parentDir("symlink", newname, key); err != nil {
+		return err
+	}
+	m.nodes[key] = &memNode{mode: fs.ModeSymlink | 0777, target: oldname, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode&^fs.ModePerm | mode.Perm()
+	return nil
+}
+
+func (m *MemFS) Lchown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, _, err := m.lookup("lchown", name, false)
+	return err
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	node := m.nodes[key]
+	switch {
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case node == nil:
+		if err := m.parentDir("open", name, key); err != nil {
+			return nil, err
+		}
+		node = &memNode{mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[key] = node
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case node.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if flag&os.O_TRUNC != 0 && !node.mode.IsDir() {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+
+	return &memFile{fs: m, node: node, name: filepath.Base(key), flag: flag}, nil
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memFile is an open file of a MemFS. Writes go straight to the node, so
+// other handles see them at once, as with files on disk.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	writable := f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+	readable := f.flag&os.O_WRONLY == 0
+	if (write && !writable) || (!write && !readable) {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	f.writeAt(p, f.offset)
+	f.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.writeAt(p, off)
+	return len(p), nil
+}
+
+func (f *memFile) writeAt(p []byte, off int64) {
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error { return nil }
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
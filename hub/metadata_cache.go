@@ -0,0 +1,186 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// metadataEntry is one cached API answer. Model info and file metadata for a
+// branch or tag are trusted for MetadataTTL and only while the repo's ref
+// still points at Commit; tree listings are keyed by commit and never expire.
+// Files is the listing of a snapshot, recorded whatever MetadataTTL.
+type metadataEntry struct {
+	Key       string        `json:"key"`
+	FetchedAt time.Time     `json:"fetched_at"`
+	Commit    string        `json:"commit"`
+	ModelInfo *ModelInfo    `json:"model_info,omitempty"`
+	File      *FileMetadata `json:"file,omitempty"`
+	Tree      []TreeEntry   `json:"tree,omitempty"`
+	Files     []string      `json:"files,omitempty"`
+}
+
+func metadataCacheDir(client *Client, repo *Repo) string {
+	return filepath.Join(client.CacheDir, ".metadata", repoFolderName(repo.Id, repo.Type))
+}
+
+func metadataEntryPath(client *Client, repo *Repo, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(metadataCacheDir(client, repo), hex.EncodeToString(sum[:16])+".json")
+}
+
+// InvalidateMetadata drops every cached API answer for the repo, e.g. after
+// pushing to it.
+func (client *Client) InvalidateMetadata(repo *Repo) error {
+	return client.fs().RemoveAll(metadataCacheDir(client, repo))
+}
+
+// loadMetadata returns the entry for key if it is still fresh. An empty
+// revision skips the TTL and ref checks, for entries keyed by commit.
+func loadMetadata(client *Client, repo *Repo, key string, revision string) *metadataEntry {
+	if client.MetadataTTL <= 0 {
+		return nil
+	}
+
+	entry := readMetadata(client, repo, key)
+	if entry == nil || revision == "" {
+		return entry
+	}
+
+	if time.Since(entry.FetchedAt) > client.MetadataTTL {
+		return nil
+	}
+	if isCommitHash(revision) {
+		if entry.Commit != revision {
+			return nil
+		}
+		return entry
+	}
+
+	// the ref moves whenever a download sees a newer commit
+	if !validRefName(revision) {
+		return nil
+	}
+	if commit, err := readRef(client.fs(), filepath.Join(client.CacheDir, repoFolderName(repo.Id, repo.Type)), revision); err == nil && commit != entry.Commit {
+		return nil
+	}
+	return entry
+}
+
+func readMetadata(client *Client, repo *Repo, key string) *metadataEntry {
+	data, err := client.fs().ReadFile(metadataEntryPath(client, repo, key))
+	if err != nil {
+		return nil
+	}
+	var entry metadataEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		return nil
+	}
+	return &entry
+}
+
+// storeMetadata writes the entry next to the cache; failures only cost a
+// request next time.
+func storeMetadata(client *Client, repo *Repo, entry *metadataEntry) {
+	if client.MetadataTTL <= 0 {
+		return
+	}
+	writeMetadata(client, repo, entry)
+}
+
+func writeMetadata(client *Client, repo *Repo, entry *metadataEntry) {
+	entry.FetchedAt = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	path := metadataEntryPath(client, repo, entry.Key)
+	if err := client.mkdirAll(filepath.Dir(path)); err != nil {
+		log.Printf("[Download] Failed to cache metadata: %v", err)
+		return
+	}
+	tmpPath := path + ".tmp"
+	if err := client.writeFile(tmpPath, data); err != nil {
+		log.Printf("[Download] Failed to cache metadata: %v", err)
+		return
+	}
+	if err := client.fs().Rename(tmpPath, path); err != nil {
+		client.fs().Remove(tmpPath)
+		log.Printf("[Download] Failed to cache metadata: %v", err)
+	}
+}
+
+func modelInfoKey(repo *Repo, blobs bool, securityStatus bool) string {
+	revision := repo.Revision
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	key := "info:" + revision
+	if blobs {
+		key += ":blobs"
+	}
+	if securityStatus {
+		key += ":security"
+	}
+	return key
+}
+
+func fileMetadataKey(revision string, fileName string) string {
+	return "file:" + revision + ":" + fileName
+}
+
+func treeKey(commitHash string) string {
+	return "tree:" + commitHash
+}
+
+func snapshotFilesKey(commitHash string) string {
+	return "files:" + commitHash
+}
+
+// recordSnapshotFiles keeps every file of the repo at a commit, so the cache
+// can later tell a complete snapshot from one only some files were fetched
+// into. A commit's listing never changes, so it is written once.
+func recordSnapshotFiles(client *Client, repo *Repo, commitHash string, files []string) {
+	key := snapshotFilesKey(commitHash)
+	if readMetadata(client, repo, key) != nil {
+		return
+	}
+	writeMetadata(client, repo, &metadataEntry{Key: key, Commit: commitHash, Files: files})
+}
+
+// recordedSnapshotFiles returns the listing recordSnapshotFiles kept, or the
+// cached tree listing, or nil when neither is known.
+func recordedSnapshotFiles(client *Client, repo *Repo, commitHash string) []string {
+	if entry := readMetadata(client, repo, snapshotFilesKey(commitHash)); entry != nil && entry.Files != nil {
+		return entry.Files
+	}
+	if entry := readMetadata(client, repo, treeKey(commitHash)); entry != nil && entry.Tree != nil {
+		var files []string
+		for _, e := range entry.Tree {
+			if e.Type == "file" {
+				files = append(files, e.Path)
+			}
+		}
+		return files
+	}
+	return nil
+}
+
+// cachedRepoTree lists the repo at a commit, which never changes once listed.
+func cachedRepoTree(client *Client, repo *Repo, commitHash string) ([]TreeEntry, error) {
+	key := treeKey(commitHash)
+	if entry := loadMetadata(client, repo, key, ""); entry != nil && entry.Tree != nil {
+		return entry.Tree, nil
+	}
+
+	tree, err := listRepoTree(client, repo, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	storeMetadata(client, repo, &metadataEntry{Key: key, Commit: commitHash, Tree: tree})
+	return tree, nil
+}
@@ -0,0 +1,195 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+)
+
+const (
+	// blobs smaller than this are fetched from a single source
+	multiSourceMinSize   = 256 * 1024 * 1024
+	multiSourceChunkSize = 32 * 1024 * 1024
+	// a source is dropped after this many failed ranges
+	multiSourceMaxFailures = 3
+)
+
+var sha256Pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// WithMirror sets a mirror of the hub, e.g. "https://hf-mirror.com". Large LFS
+// blobs are then fetched in byte ranges from the mirror and the endpoint at the
+// same time, and checked against their sha256 once complete.
+func (client *Client) WithMirror(endpoint string) *Client {
+	c := client.clone()
+	c.MirrorEndpoint = strings.TrimSuffix(endpoint, "/")
+	return c
+}
+
+// useMultiSource reports whether a blob qualifies for multi-source fetching.
+// Only LFS blobs can be checked after assembly since their etag is a sha256.
+func useMultiSource(client *Client, metadata *FileMetadata) bool {
+	return client.MirrorEndpoint != "" &&
+		metadata.Size >= multiSourceMinSize &&
+		sha256Pattern.MatchString(metadata.ETag)
+}
+
+func mirrorURL(client *Client, repo *Repo, commitHash string, fileName string) string {
+	return hubFileURL(client.MirrorEndpoint, repo, "resolve", commitHash, fileName)
+}
+
+type byteRange struct {
+	start int64
+	end   int64 // inclusive
+}
+
+// multiSourceDownload fetches destPath in chunks from every source. Sources
+// pull chunks from a shared queue, so a throttled source simply ends up serving
+// fewer of them; chunks that fail are handed back to the queue.
+func multiSourceDownload(ctx context.Context, client *Client, urls []string, destPath string, headers *http.Header, size int64, sha256sum string, displayName string) error {
+	out, err := client.openFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := out.Truncate(size); err != nil {
+		return fmt.Errorf("failed to preallocate %s: %w", destPath, err)
+	}
+
+	var ranges []byteRange
+	for start := int64(0); start < size; start += multiSourceChunkSize {
+		end := min(start+multiSourceChunkSize, size) - 1
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+
+	// buffered for every chunk so requeueing never blocks
+	chunks := make(chan byteRange, len(ranges))
+	for _, r := range ranges {
+		chunks <- r
+	}
+
+	var remaining atomic.Int64
+	remaining.Store(int64(len(ranges)))
+
+	description := fmt.Sprintf("Downloading %s (%d sources)", displayName, len(urls))
+	bar := &sharedBar{Bar: client.addBar(displayName,
+		size,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(description+": ", decor.WC{W: len(description) + 2, C: decor.DidentRight}),
+			decor.Percentage(decor.WCSyncSpace),
+		),
+		mpb.AppendDecorators(
+			decor.CountersKibiByte("%.2f / %.2f"),
+			decor.EwmaETA(decor.ET_STYLE_GO, 60),
+			decor.EwmaSpeed(decor.UnitKiB, "%.2f", 60),
+		),
+	)}
+
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		lastErr error
+	)
+
+	for _, url := range urls {
+		var failures atomic.Int32
+		for i := 0; i < client.rangeWorkers(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range chunks {
+					if err := fetchRange(ctx, client, url, headers, r, out, bar); err != nil {
+						chunks <- r
+						errMu.Lock()
+						lastErr = err
+						errMu.Unlock()
+						if failures.Add(1) >= multiSourceMaxFailures {
+							log.Printf("[Download] Dropping source %s: %v", url, err)
+							return
+						}
+						continue
+					}
+
+					if remaining.Add(-1) == 0 {
+						close(chunks)
+					}
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	if remaining.Load() > 0 {
+		bar.Abort(true)
+		return fmt.Errorf("all sources failed: %w", lastErr)
+	}
+	bar.SetTotal(size, true)
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	sum, err := sha256File(client.fs(), destPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", destPath, err)
+	}
+	if sum != sha256sum {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", displayName, sha256sum, sum)
+	}
+
+	return nil
+}
+
+func fetchRange(ctx context.Context, client *Client, url string, headers *http.Header, r byteRange, out io.WriterAt, bar *sharedBar) error {
+	release, err := client.Limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	// the hub token only goes to the Endpoint, a mirror gets its EndpointAuth
+	req.Header = headersFor(client, url, headers)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.start, r.end))
+
+	resp, err := client.downloadHTTPClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request failed: %w", newHTTPError(resp))
+	}
+	if err := decodeBody(resp); err != nil {
+		return err
+	}
+
+	length := r.end - r.start + 1
+	written, err := io.Copy(io.NewOffsetWriter(out, r.start), bar.proxyReader(io.LimitReader(resp.Body, length)))
+	if err == nil && written != length {
+		err = fmt.Errorf("short range from %s: got %d of %d bytes", url, written, length)
+	}
+	if err != nil {
+		// the chunk is fetched again from scratch
+		bar.IncrInt64(-written)
+		return err
+	}
+
+	return nil
+}
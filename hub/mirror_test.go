@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultiSourceDownloadAuth(t *testing.T) {
+	content := bytes.Repeat([]byte("mirrored"), 1024)
+	sum := sha256.Sum256(content)
+
+	var mu sync.Mutex
+	auth := map[string][]string{}
+	serve := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			auth[name] = append(auth[name], r.Header.Get("Authorization"))
+			mu.Unlock()
+			http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+		}))
+	}
+	endpoint, mirror, privateMirror := serve("endpoint"), serve("mirror"), serve("private")
+	defer endpoint.Close()
+	defer mirror.Close()
+	defer privateMirror.Close()
+
+	client := (&Client{Endpoint: endpoint.URL, Token: "hf_secret", CacheDir: t.TempDir()}).
+		WithEndpointAuth(privateMirror.URL, BasicAuth{Username: "user", Password: "pass"})
+	headers := resolveHeaders(client)
+	dest := filepath.Join(client.CacheDir, "blob.incomplete")
+	// the blob is a single range, so fetch it from each source in turn
+	for _, srv := range []*httptest.Server{endpoint, mirror, privateMirror} {
+		if err := multiSourceDownload(context.Background(), client, []string{srv.URL + "/blob"}, dest, headers, int64(len(content)), hex.EncodeToString(sum[:]), "blob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name, want := range map[string]string{"endpoint": "Bearer hf_secret", "mirror": "", "private": "Basic dXNlcjpwYXNz"} {
+		if len(auth[name]) == 0 {
+			t.Fatalf("no request to %s", name)
+		}
+		for _, got := range auth[name] {
+			if got != want {
+				t.Fatalf("%s got Authorization %q, want %q", name, got, want)
+			}
+		}
+	}
+}
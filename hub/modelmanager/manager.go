@@ -0,0 +1,315 @@
+package modelmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-vault/model-cache/hub"
+	"github.com/vbauerster/mpb/v7"
+)
+
+var ErrModelNotFound = errors.New("model not found")
+
+var sha256Pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+const civitaiDownloadURL = "https://civitai.com/api/download/models/%s"
+
+// Spec describes where a model comes from. Exactly one of Repo,
+// CivitaiVersionID or URL must be set.
+type Spec struct {
+	// Repo is a Hugging Face repo id. FileName selects a single file, the
+	// whole snapshot is downloaded otherwise.
+	Repo     string `json:"repo,omitempty"`
+	RepoType string `json:"repo_type,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Revision string `json:"revision,omitempty"`
+
+	// CivitaiVersionID is a Civitai model version id.
+	CivitaiVersionID string `json:"civitai_version_id,omitempty"`
+
+	// URL is downloaded directly. Checksum, "sha256:<hex>" or "blake3:<hex>",
+	// pins its content.
+	URL      string `json:"url,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+func (s Spec) validate() error {
+	set := 0
+	for _, field := range []string{s.Repo, s.CivitaiVersionID, s.URL} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("spec must set exactly one of Repo, CivitaiVersionID or URL")
+	}
+	return nil
+}
+
+type Options struct {
+	// StoreDir holds Civitai and URL downloads. Defaults to <CacheDir>/.models.
+	StoreDir      string
+	CivitaiAPIKey string
+	// CivitaiSidecars stores each Civitai model's metadata and preview image
+	// next to it, see hub.CivitaiSource.WriteSidecar.
+	CivitaiSidecars bool
+}
+
+// Manager downloads models by name from Hugging Face, Civitai or plain URLs.
+// Files are stored once per sha256, so the same checkpoint published on
+// several sites takes up space once. Hugging Face files stay in the hub cache.
+type Manager struct {
+	client   *hub.Client
+	dir      string
+	apiKey   string
+	sidecars bool
+	progress *mpb.Progress
+
+	mu    sync.Mutex
+	index *indexFile
+}
+
+func New(client *hub.Client, opts *Options) (*Manager, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.StoreDir == "" {
+		o.StoreDir = filepath.Join(client.CacheDir, ".models")
+	}
+
+	index, err := loadIndex(filepath.Join(o.StoreDir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	progress := client.Progress
+	if progress == nil {
+		progress = mpb.New(mpb.WithOutput(nil))
+		client = client.WithProgress(progress)
+	}
+
+	return &Manager{
+		client:   client,
+		dir:      o.StoreDir,
+		apiKey:   o.CivitaiAPIKey,
+		sidecars: o.CivitaiSidecars,
+		progress: progress,
+		index:    index,
+	}, nil
+}
+
+// Add downloads the model described by spec, unless it is already stored, and
+// registers it under name. Returns the local path.
+func (m *Manager) Add(name string, spec Spec) (string, error) {
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	if err := spec.validate(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	entry, ok := m.index.Models[name]
+	m.mu.Unlock()
+	if ok && entry.Spec == spec && exists(entry.Path) {
+		return entry.Path, nil
+	}
+
+	var err error
+	if spec.Repo != "" {
+		entry, err = m.fetchHub(spec)
+	} else {
+		entry, err = m.fetchSource(name, spec)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.index.Models[name] = entry
+	if entry.SHA256 != "" {
+		if _, ok := m.index.Blobs[entry.SHA256]; !ok {
+			m.index.Blobs[entry.SHA256] = entry.Path
+		}
+	}
+	if err := saveIndex(filepath.Join(m.dir, "index.json"), m.index); err != nil {
+		return "", err
+	}
+
+	return entry.Path, nil
+}
+
+// Resolve returns the local path of a registered model, downloading it again
+// if it was removed from disk.
+func (m *Manager) Resolve(name string) (string, error) {
+	m.mu.Lock()
+	entry, ok := m.index.Models[name]
+	m.mu.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrModelNotFound, name)
+	}
+	if exists(entry.Path) {
+		return entry.Path, nil
+	}
+
+	return m.Add(name, entry.Spec)
+}
+
+// List returns the registered models by name.
+func (m *Manager) List() map[string]Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	models := make(map[string]Entry, len(m.index.Models))
+	for name, entry := range m.index.Models {
+		models[name] = *entry
+	}
+	return models
+}
+
+func (m *Manager) fetchHub(spec Spec) (*Entry, error) {
+	repo := hub.NewRepo(spec.Repo).WithRevision(spec.Revision)
+	if spec.RepoType != "" {
+		repo = repo.WithType(spec.RepoType)
+	}
+
+	path, err := m.client.Download(&hub.DownloadParams{
+		Repo:     repo,
+		FileName: spec.FileName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", spec.Repo, err)
+	}
+
+	entry := &Entry{Spec: spec, Path: path}
+
+	// LFS blobs in the hub cache are named by their sha256
+	if spec.FileName != "" {
+		if blob, err := filepath.EvalSymlinks(path); err == nil && sha256Pattern.MatchString(filepath.Base(blob)) {
+			entry.SHA256 = filepath.Base(blob)
+		}
+	}
+
+	return entry, nil
+}
+
+func (m *Manager) fetchSource(name string, spec Spec) (*Entry, error) {
+	var source hub.DownloadSource
+	var civitai *hub.CivitaiSource
+	if spec.CivitaiVersionID != "" {
+		// shares the client's transfer slots with hub downloads
+		civitai = hub.NewCivitaiSource(fmt.Sprintf(civitaiDownloadURL, spec.CivitaiVersionID), m.apiKey).WithLimiter(m.client.Limiter)
+		source = civitai
+	} else {
+		source = hub.NewDirectURLSource(spec.URL).WithChecksum(spec.Checksum).WithLimiter(m.client.Limiter)
+	}
+
+	info, err := source.GetFileInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	fileName := "model"
+	if info.Filename != "" {
+		// the name comes from the server's Content-Disposition or URL
+		if !hub.ValidRelativePath(info.Filename) {
+			return nil, fmt.Errorf("%w: file name %q", hub.ErrUnsafePath, info.Filename)
+		}
+		fileName = filepath.Base(filepath.FromSlash(info.Filename))
+	}
+
+	// a stable location lets an interrupted download resume, so it's only
+	// removed once the blob is stored
+	tmpDir := filepath.Join(m.dir, "tmp", name)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	tmpPath := filepath.Join(tmpDir, fileName)
+	if err := source.Download(tmpPath, m.progress); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+
+	sum, err := sha256File(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", tmpPath, err)
+	}
+
+	m.mu.Lock()
+	blobPath := m.index.Blobs[sum]
+	m.mu.Unlock()
+
+	if blobPath == "" || !exists(blobPath) {
+		blobPath = filepath.Join(m.dir, "blobs", sum)
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create blobs directory: %w", err)
+		}
+		if err := os.Rename(tmpPath, blobPath); err != nil {
+			return nil, fmt.Errorf("failed to store %s: %w", name, err)
+		}
+	}
+	os.RemoveAll(tmpDir)
+
+	// loaders often rely on the file extension, so point a named link at the blob
+	linkPath := filepath.Join(m.dir, "models", name, fileName)
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create model directory: %w", err)
+	}
+	os.Remove(linkPath)
+	if err := os.Symlink(blobPath, linkPath); err != nil {
+		return nil, fmt.Errorf("failed to link %s: %w", name, err)
+	}
+
+	if civitai != nil && m.sidecars {
+		if err := civitai.WriteSidecar(linkPath); err != nil {
+			log.Printf("[Download] Failed to write Civitai sidecar for %s: %v", name, err)
+		}
+	}
+
+	return &Entry{Spec: spec, Path: linkPath, SHA256: sum}, nil
+}
+
+// names become folders in the store, so they must stay inside it
+func validateName(name string) error {
+	if name == "" || filepath.IsAbs(name) {
+		return fmt.Errorf("invalid model name %q", name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid model name %q", name)
+		}
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
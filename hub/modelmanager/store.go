@@ -0,0 +1,71 @@
+package modelmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Entry records where a named model was stored.
+type Entry struct {
+	Spec   Spec   `json:"spec"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type indexFile struct {
+	Models map[string]*Entry `json:"models"`
+	// every known copy of a file by content hash, in the store or the hub cache
+	Blobs map[string]string `json:"blobs"`
+}
+
+func loadIndex(path string) (*indexFile, error) {
+	index := &indexFile{
+		Models: make(map[string]*Entry),
+		Blobs:  make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("failed to read model index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse model index: %w", err)
+	}
+	if index.Models == nil {
+		index.Models = make(map[string]*Entry)
+	}
+	if index.Blobs == nil {
+		index.Blobs = make(map[string]string)
+	}
+
+	return index, nil
+}
+
+func saveIndex(path string, index *indexFile) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode model index: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated index
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write model index: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move model index into place: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package hub
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Netrc holds credentials from a .netrc file, keyed by machine name.
+type Netrc struct {
+	machines map[string]netrcEntry
+	fallback *netrcEntry
+}
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// LoadNetrc parses a .netrc file. A missing file yields an empty Netrc.
+func LoadNetrc(path string) (*Netrc, error) {
+	n := &Netrc{machines: map[string]netrcEntry{}}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+
+	var (
+		current *netrcEntry
+		machine string
+		inMacro bool
+	)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if machine == "" {
+			entry := *current
+			n.fallback = &entry
+		} else if _, seen := n.machines[machine]; !seen {
+			// the first entry for a machine wins, like curl
+			n.machines[machine] = *current
+		}
+		current = nil
+	}
+
+	for scanner.Scan() {
+		token := scanner.Text()
+		if inMacro {
+			// macro bodies run until an empty line, which ScanWords can't
+			// see; skip to the next keyword that starts an entry instead
+			if token != "machine" && token != "default" {
+				continue
+			}
+			inMacro = false
+		}
+
+		switch token {
+		case "machine":
+			flush()
+			if !scanner.Scan() {
+				break
+			}
+			machine = scanner.Text()
+			current = &netrcEntry{}
+		case "default":
+			flush()
+			machine = ""
+			current = &netrcEntry{}
+		case "login", "password", "account":
+			if !scanner.Scan() || current == nil {
+				continue
+			}
+			switch token {
+			case "login":
+				current.login = scanner.Text()
+			case "password":
+				current.password = scanner.Text()
+			}
+		case "macdef":
+			flush()
+			inMacro = true
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return n, nil
+}
+
+// Credentials returns the login and password for host, falling back to the
+// default entry.
+func (n *Netrc) Credentials(host string) (string, string, bool) {
+	if n == nil {
+		return "", "", false
+	}
+	if entry, ok := n.machines[host]; ok {
+		return entry.login, entry.password, true
+	}
+	if n.fallback != nil {
+		return n.fallback.login, n.fallback.password, true
+	}
+	return "", "", false
+}
+
+// defaultNetrcPath is $NETRC, or ~/.netrc.
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcTransport adds basic auth from .netrc to requests to the Endpoint and
+// mirror hosts that carry no Authorization header. The hub accepts a token as
+// the password, so mirrors and the hub can each get their own credentials.
+// Other hosts, like the CDN or pre-signed S3 URLs the hub redirects to, get
+// none: the default entry would match them, and S3 rejects signed URLs that
+// also carry an Authorization header.
+type netrcTransport struct {
+	base  http.RoundTripper
+	netrc *Netrc
+	hosts map[string]bool
+}
+
+func newNetrcTransport(client *Client, base http.RoundTripper) *netrcTransport {
+	hosts := map[string]bool{}
+	for _, endpoint := range []string{client.Endpoint, client.MirrorEndpoint} {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	return &netrcTransport{base: base, netrc: client.Netrc, hosts: hosts}
+}
+
+func (t *netrcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if req.Header.Get("Authorization") != "" || !t.hosts[host] {
+		return t.base.RoundTrip(req)
+	}
+
+	login, password, ok := t.netrc.Credentials(host)
+	if _, machine := t.netrc.machines[host]; !machine && req.Response != nil {
+		// the default entry is for the hosts asked for, not where they redirect
+		ok = false
+	}
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(login, password)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,46 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetrcStaysOnEndpoint(t *testing.T) {
+	var cdnAuth, hubAuth string
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cdnAuth = r.Header.Get("Authorization")
+	}))
+	defer cdn.Close()
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hubAuth = r.Header.Get("Authorization")
+		http.Redirect(w, r, cdn.URL+"/blob", http.StatusFound)
+	}))
+	defer endpoint.Close()
+
+	// both servers are on 127.0.0.1, so only the default entry could match the CDN
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(path, []byte("default login user password secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	netrc, err := LoadNetrc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{Endpoint: endpoint.URL, Netrc: netrc}
+	resp, err := client.httpClient().Get(endpoint.URL + "/org/model/resolve/main/model.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if hubAuth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("hub got Authorization %q", hubAuth)
+	}
+	if cdnAuth != "" {
+		t.Fatalf("netrc credentials sent to the redirect target: %q", cdnAuth)
+	}
+}
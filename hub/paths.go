@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned for a file name, commit or etag, from the hub or
+// the caller, that would place a file outside its folder in the cache.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// validRelativePath reports whether p is a slash-separated path that stays
+// inside the folder it is joined to: not absolute, without a volume,
+// backslash or NUL, and without empty, "." or ".." segments.
+func validRelativePath(p string) bool {
+	if p == "" || strings.ContainsAny(p, "\\\x00") || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidRelativePath reports whether p, such as a file name a server sent, can
+// be joined to a folder without leaving it.
+func ValidRelativePath(p string) bool {
+	return validRelativePath(p)
+}
+
+// validPathSegment reports whether s is a single segment of such a path.
+func validPathSegment(s string) bool {
+	return validRelativePath(s) && !strings.Contains(s, "/")
+}
+
+// checkRepoFilePath rejects a file name that would escape the snapshot.
+func checkRepoFilePath(name string) error {
+	if !validRelativePath(name) {
+		return fmt.Errorf("%w: file name %q", ErrUnsafePath, name)
+	}
+	return nil
+}
+
+// checkMetadataPaths rejects metadata whose commit or etag, which name a
+// snapshot folder and a blob, aren't a single path segment.
+func checkMetadataPaths(metadata *FileMetadata) error {
+	if !validPathSegment(metadata.CommitHash) {
+		return fmt.Errorf("%w: commit %q", ErrUnsafePath, metadata.CommitHash)
+	}
+	if !validPathSegment(metadata.ETag) {
+		return fmt.Errorf("%w: etag %q", ErrUnsafePath, metadata.ETag)
+	}
+	return nil
+}
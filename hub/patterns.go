@@ -1,25 +1,43 @@
 package hub
 
 import (
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
 )
 
 
-func filterFilesByPattern(files []string, allowPatterns []string, ignorePatterns []string) []string {
-	if len(allowPatterns) == 0 && len(ignorePatterns) == 0 {
+// filterFilesByPattern keeps the files matching an allow pattern or regex,
+// when any are given, and no ignore pattern or regex. With foldCase the globs
+// ignore case; regexes opt in with (?i) instead.
+func filterFilesByPattern(files []string, allowPatterns []string, ignorePatterns []string, allowRegex []*regexp.Regexp, ignoreRegex []*regexp.Regexp, foldCase bool) []string {
+	if len(allowPatterns) == 0 && len(ignorePatterns) == 0 && len(allowRegex) == 0 && len(ignoreRegex) == 0 {
 		return files
 	}
 
+	hasAllow := len(allowPatterns) > 0 || len(allowRegex) > 0
+	if foldCase {
+		allowPatterns = lowerPatterns(allowPatterns)
+		ignorePatterns = lowerPatterns(ignorePatterns)
+	}
+
 	var filtered []string
 	for _, file := range files{
+		name := file
+		if foldCase {
+			name = strings.ToLower(file)
+		}
+
 		// skip if matches ignore patterns
-		if matchesAnyPattern(file, ignorePatterns) {
+		if matchesAnyPattern(name, ignorePatterns) || matchesAnyRegex(file, ignoreRegex) {
 			continue
 		}
 
 		// include if no allow patterns or matches any allow pattern
-		if len(allowPatterns) == 0 || matchesAnyPattern(file, allowPatterns) {
+		if !hasAllow || matchesAnyPattern(name, allowPatterns) || matchesAnyRegex(file, allowRegex) {
 			filtered = append(filtered, file)
 		}
 	}
@@ -28,21 +46,63 @@ func filterFilesByPattern(files []string, allowPatterns []string, ignorePatterns
 }
 
 
+// MatchesPatterns reports whether file, a slash-separated path relative to the
+// repo root, matches any of the patterns the way AllowPatterns and
+// IgnorePatterns are matched.
+func MatchesPatterns(file string, patterns []string) bool {
+	return matchesAnyPattern(file, patterns)
+}
+
+
+func lowerPatterns(patterns []string) []string {
+	lowered := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		lowered[i] = strings.ToLower(pattern)
+	}
+	return lowered
+}
+
+
+// regexes are matched against the slash-separated path relative to the repo root
+func matchesAnyRegex(file string, regexes []*regexp.Regexp) bool {
+	for _, re := range regexes {
+		if re != nil && re.MatchString(filepath.ToSlash(file)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// matchesAnyPattern reports whether file, relative to the repo root, matches
+// one of the patterns. A pattern without a slash is tried against the base
+// name as well as the full path, so "*.json" selects "unet/config.json" as
+// with the python client's fnmatch filtering; a pattern with a slash is
+// matched against the full path only. Unlike fnmatch, "*" never crosses a
+// slash: "unet/*" selects "unet/config.json" but not "unet/sub/x.bin", which
+// "unet/**" or "unet/" select.
 func matchesAnyPattern(file string, patterns []string) bool {
 	if len(patterns) == 0 {
 		return false
 	}
 
+	file = filepath.ToSlash(file)
 	for _, pattern := range patterns {
-		matched, err := filepath.Match(pattern, file)
-		if err == nil && matched {
-			return true
+		// "**" and trailing-slash directory patterns use gitignore-style matching
+		if strings.Contains(pattern, "**") || strings.HasSuffix(pattern, "/") {
+			if matchesDoublestar(pattern, file) {
+				return true
+			}
+			continue
 		}
 
-		// handle directory wildcard pattern (e.g., "directory/*")
-		if strings.Contains(pattern, "/") {
-			matched, err = filepath.Match(pattern, filepath.ToSlash(file))
-			if err == nil && matched {
+		pattern = filepath.ToSlash(pattern)
+		if matched, err := path.Match(pattern, file); err == nil && matched {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, err := path.Match(pattern, path.Base(file)); err == nil && matched {
 				return true
 			}
 		}
@@ -50,3 +110,119 @@ func matchesAnyPattern(file string, patterns []string) bool {
 
 	return false
 }
+
+
+func matchesDoublestar(pattern string, file string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	matched, err := doublestar.Match(pattern, filepath.ToSlash(file))
+	return err == nil && matched
+}
+
+
+// pattern presets for AllowPatterns/IgnorePatterns. Each call returns a new
+// slice, so callers can append to it freely.
+
+var weightPatterns = []string{
+	"**/*.safetensors",
+	"**/*.bin",
+	"**/*.pt",
+	"**/*.pth",
+	"**/*.ckpt",
+	"**/*.gguf",
+}
+
+var configPatterns = []string{
+	"**/*.json",
+	"**/*.txt",
+	"**/*.yaml",
+	"**/*.yml",
+	"**/*.model",
+	"**/*.tiktoken",
+	"**/*.py",
+}
+
+var nonPyTorchWeightPatterns = []string{
+	"**/*.msgpack",
+	"**/*.h5",
+	"**/*.ot",
+	"**/*.onnx",
+	"**/*.onnx_data",
+	"**/*.tflite",
+	"**/*.mlmodel",
+	"**/*.mlpackage/**",
+	"**/flax_model*",
+	"**/tf_model*",
+	"**/rust_model*",
+	"onnx/",
+	"openvino/",
+	"coreml/",
+}
+
+var trainingArtifactPatterns = []string{
+	"**/optimizer.pt",
+	"**/optimizer.bin",
+	"**/scheduler.pt",
+	"**/scaler.pt",
+	"**/rng_state*.pth",
+	"**/trainer_state.json",
+	"**/training_args.bin",
+	"**/events.out.tfevents*",
+	"**/checkpoint-*/**",
+	"runs/",
+	"logs/",
+	"wandb/",
+}
+
+// WeightsOnly allows model weight files plus the configs needed to load them.
+func WeightsOnly() []string {
+	return MergePatterns(weightPatterns, configPatterns)
+}
+
+// ConfigsOnly allows configs, tokenizers and other small metadata files.
+func ConfigsOnly() []string {
+	return MergePatterns(configPatterns)
+}
+
+// NoTrainingArtifacts ignores optimizer/scheduler states, checkpoints and logs.
+func NoTrainingArtifacts() []string {
+	return MergePatterns(trainingArtifactPatterns)
+}
+
+// NonPyTorchWeights ignores TF, Flax, ONNX, CoreML and other duplicate exports.
+func NonPyTorchWeights() []string {
+	return MergePatterns(nonPyTorchWeightPatterns)
+}
+
+// MergePatterns concatenates pattern sets, dropping duplicates.
+func MergePatterns(sets ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, set := range sets {
+		for _, pattern := range set {
+			if !seen[pattern] {
+				seen[pattern] = true
+				merged = append(merged, pattern)
+			}
+		}
+	}
+	return merged
+}
+
+
+// file extensions of pickle-based formats that can execute code when loaded
+var PickleExtensions = []string{".bin", ".ckpt", ".pt", ".pth", ".pkl", ".pickle"}
+
+
+func isPickleFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	for _, pickleExt := range PickleExtensions {
+		if ext == pickleExt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package hub
+
+import "testing"
+
+func TestMatchesAnyPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{"*.json", "config.json", true},
+		{"*.json", "unet/config.json", true},
+		{"*.json", "unet/sub/config.json", true},
+		{"*.json", "unet/model.bin", false},
+		{"unet/*", "unet/config.json", true},
+		// "*" doesn't cross a slash, unlike fnmatch
+		{"unet/*", "unet/sub/x.bin", false},
+		{"unet/*", "vae/config.json", false},
+		{"unet/**", "unet/sub/x.bin", true},
+		{"**/*.bin", "model.bin", true},
+		{"**/*.bin", "unet/sub/x.bin", true},
+		{"**/*.bin", "unet/model.safetensors", false},
+		{"dir/", "dir/a.txt", true},
+		{"dir/", "dir/sub/b.txt", true},
+		{"dir/", "other/dir/a.txt", false},
+		{"dir/", "dir.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesAnyPattern(tt.file, []string{tt.pattern}); got != tt.want {
+			t.Errorf("matchesAnyPattern(%q, %q) = %v, want %v", tt.file, tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package hub
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+const (
+	defaultDirMode  os.FileMode = 0755
+	defaultFileMode os.FileMode = 0644
+)
+
+func (client *Client) dirMode() os.FileMode {
+	if client.DirMode != 0 {
+		return client.DirMode
+	}
+	return defaultDirMode
+}
+
+func (client *Client) fileMode() os.FileMode {
+	if client.FileMode != 0 {
+		return client.FileMode
+	}
+	return defaultFileMode
+}
+
+// applyPerms sets the configured mode and group on a path the client created.
+// Modes are set explicitly so the process umask doesn't narrow them.
+func (client *Client) applyPerms(path string, mode os.FileMode, configured bool) error {
+	if configured {
+		if err := client.fs().Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set mode on %s: %w", path, err)
+		}
+	}
+
+	if client.Group == "" {
+		return nil
+	}
+
+	gid, err := lookupGroup(client.Group)
+	if err != nil {
+		return err
+	}
+	if err := client.fs().Lchown(path, -1, gid); err != nil {
+		return fmt.Errorf("failed to set group on %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// mkdirAll is MkdirAll on the client's FS, applying the client's directory mode and group to
+// every directory it creates.
+func (client *Client) mkdirAll(path string) error {
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := client.fs().Stat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	if err := client.fs().MkdirAll(path, client.dirMode()); err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := client.applyPerms(missing[i], client.dirMode(), client.DirMode != 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile is WriteFile on the client's FS with the client's file mode and group. Files that
+// already exist may belong to another user, so only new ones are changed.
+func (client *Client) writeFile(path string, data []byte) error {
+	created := !client.exists(path)
+	if err := client.fs().WriteFile(path, data, client.fileMode()); err != nil {
+		return err
+	}
+
+	if created {
+		return client.applyPerms(path, client.fileMode(), client.FileMode != 0)
+	}
+	return nil
+}
+
+func (client *Client) openFile(path string, flag int) (File, error) {
+	created := !client.exists(path)
+	f, err := client.fs().OpenFile(path, flag, client.fileMode())
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		if err := client.applyPerms(path, client.fileMode(), client.FileMode != 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// tryLock takes a lock file that other users sharing the cache can also lock,
+// unlike flock's default of 0600. On other filesystems than OSFS the lock only
+// guards against concurrent downloads within this process.
+func (client *Client) tryLock(path string) (unlocker, bool, error) {
+	if _, ok := client.fs().(OSFS); !ok {
+		lock := &processLock{path: path}
+		return lock, lock.tryLock(), nil
+	}
+
+	created := !exists(path)
+	fileLock := flock.New(path, flock.SetPermissions(client.fileMode()))
+
+	locked, err := fileLock.TryLock()
+	if err != nil || !locked {
+		return fileLock, locked, err
+	}
+
+	if created {
+		if err := client.applyPerms(path, client.fileMode(), client.FileMode != 0); err != nil {
+			fileLock.Unlock()
+			return fileLock, false, err
+		}
+	}
+
+	return fileLock, true, nil
+}
+
+// lock is tryLock waiting for the lock to be released.
+func (client *Client) lock(path string) (unlocker, error) {
+	if _, ok := client.fs().(OSFS); ok {
+		fileLock := flock.New(path, flock.SetPermissions(client.fileMode()))
+		if err := fileLock.Lock(); err != nil {
+			return nil, err
+		}
+		return fileLock, nil
+	}
+
+	for {
+		lock, locked, err := client.tryLock(path)
+		if err != nil || locked {
+			return lock, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type unlocker interface {
+	Unlock() error
+}
+
+var processLocks sync.Map
+
+type processLock struct {
+	path string
+}
+
+func (l *processLock) tryLock() bool {
+	_, held := processLocks.LoadOrStore(l.path, struct{}{})
+	return !held
+}
+
+func (l *processLock) Unlock() error {
+	processLocks.Delete(l.path)
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up group %s: %w", group, err)
+	}
+
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/go-vault/model-cache/hub"
+)
+
+// weight file base names per component library
+var libraryBaseNames = map[string][]string{
+	"diffusers":    {"diffusion_pytorch_model"},
+	"transformers": {"model", "pytorch_model"},
+}
+
+// used when the library is unknown
+var defaultBaseNames = []string{
+	"diffusion_pytorch_model",
+	"model",
+	"pytorch_model",
+}
+
+// classes that ship configs and vocabularies but no weights
+var weightlessClassSuffixes = []string{
+	"Tokenizer",
+	"TokenizerFast",
+	"FeatureExtractor",
+	"ImageProcessor",
+	"Processor",
+	"Scheduler",
+}
+
+// component returns the [library, class] pair of a model_index entry.
+func (m *ModelIndex) component(name string) ModelComponent {
+	value := m.Components[name]
+
+	var component ModelComponent
+	if len(value) > 0 {
+		component.LibraryName = value[0]
+	}
+	if len(value) > 1 {
+		component.ClassName = value[1]
+	}
+
+	return component
+}
+
+// isEmpty reports whether the component is disabled in the model index
+// ([null, null]), which pipelines use for optional components.
+func (c ModelComponent) isEmpty() bool {
+	return c.LibraryName == "" && c.ClassName == ""
+}
+
+func (c ModelComponent) hasWeights() bool {
+	for _, suffix := range weightlessClassSuffixes {
+		if strings.HasSuffix(c.ClassName, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c ModelComponent) baseNames() []string {
+	if names, ok := libraryBaseNames[c.LibraryName]; ok {
+		return names
+	}
+	return defaultBaseNames
+}
+
+// components that may be absent from a download without failing it, unless
+// their ComponentDef marks them Required
+var optionalComponents = map[string]bool{
+	"scheduler":         true,
+	"tokenizer":         true,
+	"tokenizer_2":       true,
+	"tokenizer_3":       true,
+	"feature_extractor": true,
+	"safety_checker":    true,
+	"image_encoder":     true,
+}
+
+// componentDef returns the override for a component, nil when there is none.
+// skip is set for components left out by a nil entry or Skip.
+func componentDef(components map[string]*hub.ComponentDef, name string) (def *hub.ComponentDef, skip bool) {
+	def, listed := components[name]
+	if !listed {
+		return nil, false
+	}
+	return def, def == nil || def.Skip
+}
+
+// resolveComponent applies a ComponentDef to the model index entry of a
+// component.
+func (m *ModelIndex) resolveComponent(name string, def *hub.ComponentDef) ModelComponent {
+	component := m.component(name)
+	if def != nil && def.ClassName != "" {
+		component.ClassName = def.ClassName
+	}
+	return component
+}
+
+// componentVariant is the variant the component's weights are fetched in.
+func componentVariant(def *hub.ComponentDef, variant string) string {
+	if def != nil && def.Variant != "" {
+		return def.Variant
+	}
+	return variant
+}
+
+// componentFolder is the folder holding the component in its source repo.
+func componentFolder(def *hub.ComponentDef, name string) string {
+	if def != nil && def.SubFolder != "" {
+		return strings.Trim(def.SubFolder, "/")
+	}
+	return name
+}
@@ -2,12 +2,14 @@ package pipeline
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/cozy-creator/hf-hub/hub"
+	"github.com/go-vault/model-cache/hub"
 )
 
 
@@ -56,7 +58,8 @@ func (m *ModelIndex) UnmarshalJSON(data []byte) error {
 
 
 type DiffusionPipelineDownloader struct {
-	client *hub.Client
+	client  *hub.Client
+	summary *hub.DownloadSummary
 }
 
 
@@ -67,6 +70,33 @@ func NewDiffusionPipelineDownloader(client *hub.Client) *DiffusionPipelineDownlo
 }
 
 
+// DownloadWithSummary is Download, also reporting the totals of every file
+// fetched for the pipeline and its connected pipelines.
+func (dpd *DiffusionPipelineDownloader) DownloadWithSummary(repoID string, variant string, opts *DownloadOptions, components map[string]*hub.ComponentDef) (string, *hub.DownloadSummary, error) {
+	withSummary := &DiffusionPipelineDownloader{
+		client:  dpd.client,
+		summary: &hub.DownloadSummary{},
+	}
+
+	started := time.Now()
+	path, err := withSummary.Download(repoID, variant, opts, components)
+	withSummary.summary.Elapsed = time.Since(started)
+
+	return path, withSummary.summary, err
+}
+
+// download fetches through the client, adding to the summary when one is collected.
+func (dpd *DiffusionPipelineDownloader) download(params *hub.DownloadParams) (string, error) {
+	if dpd.summary == nil {
+		return dpd.client.Download(params)
+	}
+
+	path, summary, err := dpd.client.DownloadWithSummary(params)
+	dpd.summary.Add(summary)
+	return path, err
+}
+
+
 func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string, opts *DownloadOptions, components map[string]*hub.ComponentDef) (string, error) {
 	if opts == nil {
 		opts = &DownloadOptions{
@@ -74,6 +104,23 @@ func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string,
 		}
 	}
 
+	// never fall back to pickle-based formats in safetensors-only mode
+	if dpd.client.SafeTensorsOnly && !opts.UseSafetensors {
+		safeOpts := *opts
+		safeOpts.UseSafetensors = true
+		opts = &safeOpts
+	}
+
+	// each pipeline, connected ones included, keeps its bars together
+	client := dpd.client.WithProgressSection("")
+	if opts.Limiter != nil {
+		client = client.WithLimiter(opts.Limiter)
+	}
+	dpd = &DiffusionPipelineDownloader{
+		client:  client,
+		summary: dpd.summary,
+	}
+
 	// download the model index first
 	params := &hub.DownloadParams{
 		Repo: &hub.Repo{
@@ -83,7 +130,7 @@ func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string,
 		FileName: "model_index.json",
 	}
 
-	modelIndexPath, err := dpd.client.Download(params)
+	modelIndexPath, err := dpd.download(params)
 	if err != nil {
 		return "", fmt.Errorf("failed to get model index: %w", err)
 	}
@@ -99,7 +146,7 @@ func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string,
 	var lastErr error
 	if opts.UseSafetensors {
 		// only try safetensors
-		snapshotPath, err := dpd.tryDownloadFormat(repoID, modelIndex, variant, ".safetensors", components)
+		snapshotPath, err := dpd.tryDownloadFormat(repoID, modelIndex, variant, ".safetensors", components, opts)
 		if err != nil {
 			return "", fmt.Errorf("safetensors required but not available: %w", err)
 		}
@@ -114,10 +161,13 @@ func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string,
 	}
 
 	for _, format := range formats {
-		snapshotPath, err := dpd.tryDownloadFormat(repoID, modelIndex, variant, format, components)
+		snapshotPath, err := dpd.tryDownloadFormat(repoID, modelIndex, variant, format, components, opts)
 		if err == nil {
 			return snapshotPath, nil
 		}
+		if errors.Is(err, hub.ErrDownloadDeclined) {
+			return "", err
+		}
 		lastErr = err
 	}
 
@@ -127,7 +177,7 @@ func (dpd *DiffusionPipelineDownloader) Download(repoID string, variant string,
 }
 
 
-func (dpd *DiffusionPipelineDownloader) tryDownloadFormat(repoID string, modelIndex *ModelIndex, variant string, format string, components map[string]*hub.ComponentDef) (string, error) {
+func (dpd *DiffusionPipelineDownloader) tryDownloadFormat(repoID string, modelIndex *ModelIndex, variant string, format string, components map[string]*hub.ComponentDef, opts *DownloadOptions) (string, error) {
 	patterns := dpd.buildDownloadPatterns(modelIndex, variant, format, components)
 
 	params := &hub.DownloadParams{
@@ -135,73 +185,54 @@ func (dpd *DiffusionPipelineDownloader) tryDownloadFormat(repoID string, modelIn
 			Id: repoID,
 			Type: hub.ModelRepoType,
 		},
-		AllowPatterns: patterns,
+		AllowPatterns:  hub.MergePatterns(patterns, opts.AllowPatterns),
+		IgnorePatterns: opts.IgnorePatterns,
+		ConfirmFunc:    opts.ConfirmFunc,
 	}
 
-	snapshotPath, err := dpd.client.Download(params)
+	snapshotPath, err := dpd.download(params)
 	if err != nil {
 		return "", fmt.Errorf("failed to download model in %s format: %w", format, err)
 	}
 
-	ignoredFolders := map[string]bool{
-        "scheduler":          true,
-        "tokenizer":         true,
-        "tokenizer_2":       true,
-        "tokenizer_3":       true,
-        "feature_extractor": true,
-        "safety_checker":    true,
-        "image_encoder":     true,
-    }
-
-    for compName := range components {
-        // add the component to the ignore list
-		ignoredFolders[compName] = true
+	// components with a Source come from their own repo
+	externalPaths, err := dpd.downloadExternalComponents(modelIndex, variant, format, components, opts)
+	if err != nil {
+		return "", err
 	}
 
 	missingComponents := []string{}
     for component := range modelIndex.Components {
-		// skip ignored components
-		if ignoredFolders[component] {
+		def, skip := componentDef(components, component)
+		if skip || (optionalComponents[component] && (def == nil || !def.Required)) {
+			continue
+		}
+
+		if info := modelIndex.resolveComponent(component, def); info.isEmpty() || !info.hasWeights() {
 			continue
 		}
         componentPath := filepath.Join(snapshotPath, component)
+        ignored := func(name string) bool {
+            return hub.MatchesPatterns(component+"/"+name, opts.IgnorePatterns)
+        }
+        if path, ok := externalPaths[component]; ok {
+            componentPath = path
+            ignored = func(string) bool { return false }
+        }
         
         // Check if component directory exists
-        if _, err := os.Stat(componentPath); os.IsNotExist(err) {
+        if _, err := dpd.client.CacheFS().Stat(componentPath); os.IsNotExist(err) {
             missingComponents = append(missingComponents, component)
             continue
         }
 
-        // List files in component directory
-        files, err := os.ReadDir(componentPath)
+        // Check if component has weights, including every shard of an index
+        hasComponentWeights, err := componentHasWeights(dpd.client.CacheFS(), componentPath, componentVariant(def, variant), format, ignored)
         if err != nil {
             missingComponents = append(missingComponents, component)
             continue
         }
 
-        // Build pattern for matching
-        var pattern string
-        if variant != "" {
-            pattern = "*." + variant + format
-        } else {
-            pattern = "*" + format
-        }
-
-        // Check if component has weights
-        hasComponentWeights := false
-        for _, file := range files {
-            if !file.IsDir() {
-                matched, err := filepath.Match(pattern, file.Name())
-                if err != nil {
-                    continue
-                }
-                if matched {
-                    hasComponentWeights = true
-                    break
-                }
-            }
-        }
-
         if !hasComponentWeights {
             missingComponents = append(missingComponents, component)
         }
@@ -219,6 +250,98 @@ func (dpd *DiffusionPipelineDownloader) tryDownloadFormat(repoID string, modelIn
     return snapshotPath, nil
 }
 
+// downloadExternalComponents fetches the components whose ComponentDef names
+// a Source repo, returning the folder of each in its snapshot.
+func (dpd *DiffusionPipelineDownloader) downloadExternalComponents(modelIndex *ModelIndex, variant string, format string, components map[string]*hub.ComponentDef, opts *DownloadOptions) (map[string]string, error) {
+	paths := make(map[string]string)
+	for name := range modelIndex.Components {
+		def, skip := componentDef(components, name)
+		if skip || def == nil || def.Source == "" {
+			continue
+		}
+
+		folder := componentFolder(def, name)
+		params := &hub.DownloadParams{
+			Repo:          hub.NewRepo(def.Source),
+			AllowPatterns: componentPatterns(folder, name, modelIndex.resolveComponent(name, def), componentVariant(def, variant), format),
+			ConfirmFunc:   opts.ConfirmFunc,
+		}
+
+		component := &DiffusionPipelineDownloader{
+			client:  dpd.client.WithProgressSection(name),
+			summary: dpd.summary,
+		}
+		snapshotPath, err := component.download(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download component %s from %s: %w", name, def.Source, err)
+		}
+		paths[name] = filepath.Join(snapshotPath, filepath.FromSlash(folder))
+	}
+	return paths, nil
+}
+
+// RefreshComponent downloads the configs and weights of one component of the
+// pipeline again, e.g. a fixed VAE pushed upstream, leaving its other
+// components as cached. Formats are tried in the order Download uses. It
+// returns the component's folder in the snapshot of the latest commit.
+func (dpd *DiffusionPipelineDownloader) RefreshComponent(repoID string, component string, variant string) (string, error) {
+	client := dpd.client.WithProgressSection(component)
+	dpd = &DiffusionPipelineDownloader{
+		client:  client,
+		summary: dpd.summary,
+	}
+
+	repo := &hub.Repo{
+		Id:   repoID,
+		Type: hub.ModelRepoType,
+	}
+	modelIndexPath, err := dpd.download(&hub.DownloadParams{
+		Repo:     repo,
+		FileName: "model_index.json",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get model index: %w", err)
+	}
+	modelIndex, err := dpd.parseModelIndex(modelIndexPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse model index: %w", err)
+	}
+
+	info := modelIndex.resolveComponent(component, nil)
+	if _, ok := modelIndex.Components[component]; !ok || info.isEmpty() {
+		return "", fmt.Errorf("%s has no component %s", repoID, component)
+	}
+
+	formats := []string{".safetensors", ".ckpt", ".bin"}
+	if client.SafeTensorsOnly || !info.hasWeights() {
+		formats = formats[:1]
+	}
+
+	var lastErr error
+	for _, format := range formats {
+		snapshotPath, err := dpd.download(&hub.DownloadParams{
+			Repo:          repo,
+			AllowPatterns: componentPatterns(component, component, info, variant, format),
+			ForceDownload: true,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to refresh component %s: %w", component, err)
+		}
+
+		componentPath := filepath.Join(snapshotPath, component)
+		if !info.hasWeights() {
+			return componentPath, nil
+		}
+		hasWeights, err := componentHasWeights(client.CacheFS(), componentPath, variant, format, func(string) bool { return false })
+		if err == nil && hasWeights {
+			return componentPath, nil
+		}
+		lastErr = fmt.Errorf("no weights for component %s in %s format", component, format)
+	}
+
+	return "", lastErr
+}
+
 // func listDirFiles(dir string) []string {
 //     files, err := os.ReadDir(dir)
 //     if err != nil {
@@ -235,7 +358,7 @@ func (dpd *DiffusionPipelineDownloader) tryDownloadFormat(repoID string, modelIn
 
 
 func (dpd *DiffusionPipelineDownloader) parseModelIndex(path string) (*ModelIndex, error) {
-	data, err := os.ReadFile(path)
+	data, err := dpd.client.CacheFS().ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read model index: %w", err)
 	}
@@ -252,60 +375,58 @@ func (dpd *DiffusionPipelineDownloader) parseModelIndex(path string) (*ModelInde
 func (dpd *DiffusionPipelineDownloader) buildDownloadPatterns(index *ModelIndex, variant string, format string, components map[string]*hub.ComponentDef) []string {
 	patterns := []string{}
 
-    // Get list of component folders to ignore
-    ignoreComponents := make(map[string]bool)
-    if components != nil {
-        for compName := range components {
-            ignoreComponents[compName] = true
-        }
-    }
-
 	for componentName := range index.Components {
-
-		// skip ignored components
-		if ignoreComponents[componentName] {
+		// skipped components and those fetched from another repo
+		def, skip := componentDef(components, componentName)
+		if skip || (def != nil && def.Source != "") {
 			continue
 		}
 
-		// add component's config files
-        patterns = append(patterns,
-			fmt.Sprintf("%s/*.json", componentName),
-		)
+		component := index.resolveComponent(componentName, def)
+		patterns = append(patterns, componentPatterns(componentName, componentName, component, componentVariant(def, variant), format)...)
+	}
 
-		// for tokenizers and schedulers, download everything
-		if strings.Contains(componentName, "tokenizer") || strings.Contains(componentName, "scheduler") {
-			patterns = append(patterns, fmt.Sprintf("%s/*", componentName))
-			continue
-		}
+	return patterns
+}
 
 
-        // For other components, follow variant and format patterns
-        baseNames := []string{
-            "diffusion_pytorch_model",
-            "model",
-            "pytorch_model",
-        }
+// componentPatterns matches the configs and weights of the component name
+// stored in folder.
+func componentPatterns(folder string, name string, component ModelComponent, variant string, format string) []string {
+	if component.isEmpty() {
+		return nil
+	}
 
-        for _, baseName := range baseNames {
-            if variant == "" {
-                // Base patterns for weights
-                patterns = append(patterns,
-                    // Regular files
-                    fmt.Sprintf("%s/%s%s", componentName, baseName, format),
-                    // Sharded files
-                    fmt.Sprintf("%s/%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9]%s", componentName, baseName, format),
-                )
-            } else {
-                // Variant patterns for weights
-                patterns = append(patterns,
-                    // Regular files
-                    fmt.Sprintf("%s/%s.%s%s", componentName, baseName, variant, format),
-					// Sharded files (current format)
-                    fmt.Sprintf("%s/%s.%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9]%s", componentName, baseName, variant, format),
-                    // Sharded files (deprecated format)
-                    fmt.Sprintf("%s/%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9].%s%s", componentName, baseName, variant, format),
-                )
-            }
+	// add component's config files
+	patterns := []string{
+		fmt.Sprintf("%s/*.json", folder),
+	}
+
+	// for tokenizers, schedulers and processors, download everything
+	if strings.Contains(name, "tokenizer") || strings.Contains(name, "scheduler") || !component.hasWeights() {
+		return append(patterns, fmt.Sprintf("%s/*", folder))
+	}
+
+    // For other components, follow variant and format patterns of the component's library
+    for _, baseName := range component.baseNames() {
+        if variant == "" {
+            // Base patterns for weights
+            patterns = append(patterns,
+                // Regular files
+                fmt.Sprintf("%s/%s%s", folder, baseName, format),
+                // Sharded files
+                fmt.Sprintf("%s/%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9]%s", folder, baseName, format),
+            )
+        } else {
+            // Variant patterns for weights
+            patterns = append(patterns,
+                // Regular files
+                fmt.Sprintf("%s/%s.%s%s", folder, baseName, variant, format),
+                // Sharded files (current format)
+                fmt.Sprintf("%s/%s.%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9]%s", folder, baseName, variant, format),
+                // Sharded files (deprecated format)
+                fmt.Sprintf("%s/%s-[0-9][0-9][0-9][0-9][0-9]-of-[0-9][0-9][0-9][0-9][0-9].%s%s", folder, baseName, variant, format),
+            )
         }
     }
 
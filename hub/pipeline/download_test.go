@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/go-vault/model-cache/hub"
+)
+
+func TestComponentPatterns(t *testing.T) {
+	unet := ModelComponent{LibraryName: "diffusers", ClassName: "UNet2DConditionModel"}
+	tokenizer := ModelComponent{LibraryName: "transformers", ClassName: "CLIPTokenizer"}
+
+	tests := []struct {
+		name      string
+		component ModelComponent
+		variant   string
+		file      string
+		want      bool
+	}{
+		{"unet", unet, "", "unet/config.json", true},
+		{"unet", unet, "", "unet/diffusion_pytorch_model.safetensors", true},
+		{"unet", unet, "", "unet/diffusion_pytorch_model-00001-of-00002.safetensors", true},
+		{"unet", unet, "", "unet/diffusion_pytorch_model.fp16.safetensors", false},
+		{"unet", unet, "", "unet/diffusion_pytorch_model.bin", false},
+		{"unet", unet, "", "unet/sub/diffusion_pytorch_model.safetensors", false},
+		{"unet", unet, "", "vae/diffusion_pytorch_model.safetensors", false},
+		{"unet", unet, "fp16", "unet/diffusion_pytorch_model.fp16.safetensors", true},
+		{"unet", unet, "fp16", "unet/diffusion_pytorch_model.fp16-00001-of-00002.safetensors", true},
+		{"unet", unet, "fp16", "unet/diffusion_pytorch_model-00001-of-00002.fp16.safetensors", true},
+		{"unet", unet, "fp16", "unet/diffusion_pytorch_model.safetensors", false},
+		{"tokenizer", tokenizer, "", "tokenizer/vocab.json", true},
+		{"tokenizer", tokenizer, "", "tokenizer/merges.txt", true},
+		// folder patterns only reach the component's own files
+		{"tokenizer", tokenizer, "", "tokenizer/sub/merges.txt", false},
+	}
+
+	for _, tt := range tests {
+		patterns := componentPatterns(tt.name, tt.name, tt.component, tt.variant, ".safetensors")
+		if got := hub.MatchesPatterns(tt.file, patterns); got != tt.want {
+			t.Errorf("%s (variant %q): MatchesPatterns(%q) = %v, want %v; patterns %q", tt.name, tt.variant, tt.file, got, tt.want, patterns)
+		}
+	}
+}
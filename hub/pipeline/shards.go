@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-vault/model-cache/hub"
+)
+
+var shardSuffix = regexp.MustCompile(`-\d{5}-of-\d{5}$`)
+
+// shardIndex is the "<name>.safetensors.index.json" file describing which
+// shard holds each tensor of a sharded checkpoint.
+type shardIndex struct {
+	WeightMap map[string]string `json:"weight_map"`
+}
+
+// addVariant inserts the variant before the last extension, the way diffusers
+// and transformers name variant files ("model.safetensors.index.fp16.json").
+func addVariant(name string, variant string) string {
+	if variant == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + variant + ext
+}
+
+// isWeightFile reports whether name is a single-file or sharded checkpoint in
+// the given variant and format, e.g. "diffusion_pytorch_model.fp16-00001-of-00003.safetensors".
+func isWeightFile(name string, variant string, format string) bool {
+	if !strings.HasSuffix(name, format) {
+		return false
+	}
+	stem := strings.TrimSuffix(name, format)
+
+	if variant == "" {
+		return !strings.Contains(stem, ".")
+	}
+
+	// current format puts the variant before the shard suffix
+	if strings.HasSuffix(shardSuffix.ReplaceAllString(stem, ""), "."+variant) {
+		return true
+	}
+	// deprecated format puts it after
+	return strings.HasSuffix(stem, "."+variant) && shardSuffix.MatchString(strings.TrimSuffix(stem, "."+variant))
+}
+
+// componentHasWeights checks a downloaded component folder for weights in the
+// given variant and format. When a shard index is present every shard it lists
+// must exist, so a partially downloaded sharded checkpoint doesn't count,
+// except for shards the caller chose to skip.
+func componentHasWeights(fsys hub.FS, componentPath string, variant string, format string, ignored func(name string) bool) (bool, error) {
+	files, err := fsys.ReadDir(componentPath)
+	if err != nil {
+		return false, err
+	}
+
+	indexSuffix := addVariant(format+".index.json", variant)
+
+	hasIndex := false
+	hasWeights := false
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		name := file.Name()
+		if strings.HasSuffix(name, indexSuffix) && !strings.Contains(strings.TrimSuffix(name, indexSuffix), ".") {
+			complete, err := shardsPresent(fsys, componentPath, name, ignored)
+			if err != nil {
+				return false, err
+			}
+			if !complete {
+				return false, nil
+			}
+			hasIndex = true
+			continue
+		}
+
+		if isWeightFile(name, variant, format) {
+			hasWeights = true
+		}
+	}
+
+	return hasIndex || hasWeights, nil
+}
+
+func shardsPresent(fsys hub.FS, componentPath string, indexName string, ignored func(name string) bool) (bool, error) {
+	data, err := fsys.ReadFile(filepath.Join(componentPath, indexName))
+	if err != nil {
+		return false, fmt.Errorf("failed to read shard index: %w", err)
+	}
+
+	var index shardIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return false, fmt.Errorf("failed to parse shard index %s: %w", indexName, err)
+	}
+	if len(index.WeightMap) == 0 {
+		return false, nil
+	}
+
+	checked := make(map[string]bool)
+	for _, shard := range index.WeightMap {
+		if checked[shard] {
+			continue
+		}
+		checked[shard] = true
+
+		if ignored(shard) {
+			continue
+		}
+		if _, err := fsys.Stat(filepath.Join(componentPath, shard)); err != nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -1,5 +1,7 @@
 package pipeline
 
+import "github.com/go-vault/model-cache/hub"
+
 
 type ModelComponent struct {
 	LibraryName string `json:"library_name,omitempty"`
@@ -17,5 +19,14 @@ type ModelIndex struct {
 
 type DownloadOptions struct {
 	UseSafetensors   bool
+	// asked once per format attempt before its weights are fetched
+	ConfirmFunc      func(plan hub.DownloadPlan) bool
+	// replaces the client's Limiter for this pipeline and its connected pipelines
+	Limiter          *hub.Limiter
+	// added to the patterns generated from the model index, e.g. "*.md" to
+	// also fetch the model card, or "*.onnx" and a single shard to skip them;
+	// shards ignored here don't count as missing
+	AllowPatterns    []string
+	IgnorePatterns   []string
 }
 
@@ -0,0 +1,57 @@
+package hub
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// ErrDownloadDeclined is returned when ConfirmFunc turned the download down.
+var ErrDownloadDeclined = errors.New("download declined")
+
+// DownloadPlan lists what a download is about to fetch, resolved before any
+// file is transferred.
+type DownloadPlan struct {
+	Repo       *Repo
+	CommitHash string
+	Files      []PlannedFile
+	// TotalSize counts every file, DownloadSize only those not in the cache
+	TotalSize    int64
+	DownloadSize int64
+}
+
+type PlannedFile struct {
+	Name   string
+	Size   int64
+	Cached bool
+}
+
+// confirmDownload builds the plan for files, checks it against the quotas
+// and asks params.ConfirmFunc. Sizes missing from metadata count as 0.
+func confirmDownload(client *Client, params *DownloadParams, commitHash string, files []string, metadata map[string]*FileMetadata) (DownloadPlan, error) {
+	if params.ConfirmFunc == nil && params.MaxDownloadSize <= 0 && client.RepoQuota <= 0 {
+		return DownloadPlan{}, nil
+	}
+
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(params.Repo.Id, params.Repo.Type))
+	plan := DownloadPlan{Repo: params.Repo, CommitHash: commitHash}
+	for _, name := range files {
+		file := PlannedFile{Name: name}
+		if m := metadata[name]; m != nil {
+			file.Size = int64(m.Size)
+			file.Cached = client.exists(filepath.Join(storageFolder, "blobs", m.ETag))
+		}
+		plan.TotalSize += file.Size
+		if !file.Cached {
+			plan.DownloadSize += file.Size
+		}
+		plan.Files = append(plan.Files, file)
+	}
+
+	if err := checkQuotas(client, params, plan.DownloadSize); err != nil {
+		return plan, err
+	}
+	if params.ConfirmFunc != nil && !params.ConfirmFunc(plan) {
+		return plan, ErrDownloadDeclined
+	}
+	return plan, nil
+}
@@ -0,0 +1,265 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+)
+
+const defaultProgressLogInterval = 10 * time.Second
+
+// progress bars only render usefully on a terminal; systemd, docker logs and
+// CI get periodic log lines instead
+var stdoutIsTerminal = isTerminal(os.Stdout)
+
+// used when the client has no Progress, so bars can always be created
+var discardProgress = mpb.New(mpb.WithOutput(nil))
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewProgress returns a progress container that draws bars on a terminal and
+// stays silent otherwise, leaving progress to the periodic log lines.
+func NewProgress(options ...mpb.ContainerOption) *mpb.Progress {
+	if !stdoutIsTerminal {
+		options = append(options, mpb.WithOutput(nil))
+	}
+	return mpb.New(options...)
+}
+
+func (client *Client) progress() *mpb.Progress {
+	if client.Progress != nil {
+		return client.Progress
+	}
+	return discardProgress
+}
+
+// addBar creates a progress bar for a download. When stdout is not a terminal
+// the bar is also reported through the logger every ProgressLogInterval.
+func (client *Client) addBar(name string, total int64, options ...mpb.BarOption) *mpb.Bar {
+	bar := client.progress().AddBar(total, client.fileBarOptions(options)...)
+	client.logBar(name, bar, total)
+	return bar
+}
+
+// addRetriedBar is addBar for a download that may be retried or resumed. Its
+// total stays adjustable, since mpb fixes the total of bars created with one,
+// so each attempt can correct it from the server's response; the bar only
+// completes on SetTotal(-1, true).
+func (client *Client) addRetriedBar(name string, total int64, options ...mpb.BarOption) *mpb.Bar {
+	bar := client.progress().AddBar(0, client.fileBarOptions(options)...)
+	bar.SetTotal(total, false)
+	client.logBar(name, bar, total)
+	return bar
+}
+
+// addTotalBar adds the bar counting the files of a snapshot, which heads the
+// client's progress section. It counts files rather than bytes, so it isn't
+// logged.
+func (client *Client) addTotalBar(total int64, options ...mpb.BarOption) *mpb.Bar {
+	return client.progress().AddBar(total, client.section.barOptions(true, options)...)
+}
+
+func (client *Client) fileBarOptions(options []mpb.BarOption) []mpb.BarOption {
+	return client.section.barOptions(false, options)
+}
+
+func (client *Client) logBar(name string, bar *mpb.Bar, total int64) {
+	interval := client.ProgressLogInterval
+	if interval == 0 {
+		interval = defaultProgressLogInterval
+	}
+	if !stdoutIsTerminal && interval > 0 {
+		defaultProgressLogger.track(name, bar, total, interval)
+	}
+}
+
+type loggedBar struct {
+	name        string
+	bar         *mpb.Bar
+	total       int64
+	interval    time.Duration
+	lastLog     time.Time
+	lastCurrent int64
+}
+
+// progressLogger writes one compact line per active bar: percent, speed, ETA.
+type progressLogger struct {
+	mu      sync.Mutex
+	bars    []*loggedBar
+	running bool
+}
+
+var defaultProgressLogger = &progressLogger{}
+
+func (l *progressLogger) track(name string, bar *mpb.Bar, total int64, interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bars = append(l.bars, &loggedBar{
+		name:        name,
+		bar:         bar,
+		total:       total,
+		interval:    interval,
+		lastLog:     time.Now(),
+		lastCurrent: bar.Current(),
+	})
+
+	if !l.running {
+		l.running = true
+		go l.run()
+	}
+}
+
+func (l *progressLogger) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		now := time.Now()
+		active := l.bars[:0]
+		for _, b := range l.bars {
+			if b.bar.Aborted() {
+				continue
+			}
+			if b.bar.Completed() {
+				log.Printf("[Download] %s: done", b.name)
+				continue
+			}
+			if now.Sub(b.lastLog) >= b.interval {
+				b.report(now)
+			}
+			active = append(active, b)
+		}
+		l.bars = active
+
+		if len(l.bars) == 0 {
+			l.running = false
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (b *loggedBar) report(now time.Time) {
+	current := b.bar.Current()
+	elapsed := now.Sub(b.lastLog).Seconds()
+	speed := float64(current-b.lastCurrent) / elapsed
+
+	total := b.total
+	line := fmt.Sprintf("[Download] %s: %s", b.name, formatBytes(current))
+	if total > 0 {
+		line += fmt.Sprintf(" / %s (%.1f%%)", formatBytes(total), float64(current)/float64(total)*100)
+	}
+	line += fmt.Sprintf(", %s/s", formatBytes(int64(speed)))
+	if total > 0 && speed > 0 {
+		eta := time.Duration(float64(total-current)/speed) * time.Second
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	log.Print(line)
+
+	b.lastLog = now
+	b.lastCurrent = current
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// sharedBar is a progress bar fed by concurrent range requests. Their reads
+// can't each go through the bar's ProxyReader: its ewma update panics when
+// another reader's update came between its increment and its own.
+type sharedBar struct {
+	*mpb.Bar
+	mu sync.Mutex
+}
+
+func (b *sharedBar) proxyReader(r io.Reader) io.Reader {
+	return sharedBarReader{bar: b, r: r}
+}
+
+type sharedBarReader struct {
+	bar *sharedBar
+	r   io.Reader
+}
+
+func (x sharedBarReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := x.r.Read(p)
+	if n > 0 {
+		x.bar.mu.Lock()
+		x.bar.IncrBy(n)
+		x.bar.DecoratorEwmaUpdate(time.Since(start))
+		x.bar.mu.Unlock()
+	}
+	return n, err
+}
+
+// sections are numbered in creation order; each holds this many bars
+const sectionSize = 1 << 20
+
+var sectionCount atomic.Int64
+
+// progressSection keeps the bars of one part of a larger download together
+// in the shared container, in the order they were added, below the sections
+// created before it.
+type progressSection struct {
+	title string
+	base  int
+	bars  atomic.Int64
+}
+
+// WithProgressSection returns a copy of the client whose bars are grouped
+// together in the Progress container instead of interleaving with those of
+// other downloads, such as the components and connected pipelines of a
+// pipeline: the snapshot counters head the section, prefixed with title when
+// it is not empty, and file bars are indented below them.
+func (client *Client) WithProgressSection(title string) *Client {
+	c := client.clone()
+	c.section = &progressSection{
+		title: title,
+		base:  int(sectionCount.Add(1)) * sectionSize,
+	}
+	return c
+}
+
+// barOptions places a bar in the section ahead of the caller's options, so
+// the indent comes before its own decorators.
+func (s *progressSection) barOptions(heading bool, options []mpb.BarOption) []mpb.BarOption {
+	if s == nil {
+		return options
+	}
+
+	placed := []mpb.BarOption{mpb.BarPriority(s.base + int(s.bars.Add(1))%sectionSize)}
+	switch {
+	case !heading:
+		placed = append(placed, mpb.PrependDecorators(decor.Name("  ")))
+	case s.title != "":
+		placed = append(placed, mpb.PrependDecorators(decor.Name("["+s.title+"] ")))
+	}
+	return append(placed, options...)
+}
@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrNoQuantFits is returned when even the smallest quantization is larger
+// than the memory budget.
+var ErrNoQuantFits = errors.New("no quantization fits the memory budget")
+
+// QuantOption is one quantization or precision of the weights in a repo,
+// e.g. a GGUF file (all shards of it) or the fp16 variant of safetensors
+// weights.
+type QuantOption struct {
+	Name   string   `json:"name"`
+	Format string   `json:"format"`
+	Files  []string `json:"files"`
+	Size   int64    `json:"size"`
+}
+
+var (
+	// llama.cpp split files: model-Q4_K_M-00001-of-00003.gguf
+	ggufShardPattern = regexp.MustCompile(`-\d{5}-of-\d{5}\.gguf$`)
+	ggufQuantPattern = regexp.MustCompile(`(?i)(?:^|[-_.])((?:I?Q\d(?:_[A-Z0-9]+)*)|(?:B?F16)|(?:F32))(?:$|[-_.])`)
+
+	// diffusers and transformers variants: model.fp16.safetensors, model.fp16-00001-of-00002.safetensors
+	safetensorsVariantPattern = regexp.MustCompile(`\.(fp16|bf16|fp32|fp8|int8|int4)(?:-\d{5}-of-\d{5})?\.safetensors$`)
+)
+
+// ListQuantizations lists the GGUF quantizations and safetensors precisions
+// in the repo at repo.Revision, smallest first. Shards of the same weights
+// count as one option, GGUF vision projectors (mmproj) are left out.
+func (client *Client) ListQuantizations(repo *Repo) ([]QuantOption, error) {
+	tree, err := listRepoTree(client, repo, repo.Revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repo files: %w", err)
+	}
+	return quantOptions(tree), nil
+}
+
+func quantOptions(tree []TreeEntry) []QuantOption {
+	options := make(map[string]*QuantOption)
+	add := func(key string, name string, format string, entry TreeEntry) {
+		option := options[key]
+		if option == nil {
+			option = &QuantOption{Name: name, Format: format}
+			options[key] = option
+		}
+		option.Files = append(option.Files, entry.Path)
+		option.Size += entry.FileSize()
+	}
+
+	for _, entry := range tree {
+		if entry.Type == "directory" {
+			continue
+		}
+		base := path.Base(entry.Path)
+
+		switch {
+		case strings.HasSuffix(base, ".gguf"):
+			if strings.HasPrefix(strings.ToLower(base), "mmproj") {
+				continue
+			}
+			key := ggufShardPattern.ReplaceAllString(entry.Path, ".gguf")
+			name := strings.TrimSuffix(path.Base(key), ".gguf")
+			if m := ggufQuantPattern.FindStringSubmatch(name); m != nil {
+				name = strings.ToUpper(m[1])
+			}
+			add("gguf:"+key, name, "gguf", entry)
+
+		case strings.HasSuffix(base, ".safetensors"):
+			name := "default"
+			if m := safetensorsVariantPattern.FindStringSubmatch(base); m != nil {
+				name = m[1]
+			}
+			add("safetensors:"+name, name, "safetensors", entry)
+		}
+	}
+
+	list := make([]QuantOption, 0, len(options))
+	for _, option := range options {
+		sort.Strings(option.Files)
+		list = append(list, *option)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Size != list[j].Size {
+			return list[i].Size < list[j].Size
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	// the same label in two GGUF files of one repo (e.g. two model sizes)
+	// is told apart by the file name
+	seen := make(map[string]int)
+	for _, option := range list {
+		seen[option.Name]++
+	}
+	for i, option := range list {
+		if seen[option.Name] > 1 && option.Format == "gguf" {
+			list[i].Name = strings.TrimSuffix(path.Base(ggufShardPattern.ReplaceAllString(option.Files[0], ".gguf")), ".gguf")
+		}
+	}
+
+	return list
+}
+
+// SelectQuantization returns the largest option whose files fit in budget
+// bytes. Leave room in the budget for the context and activations.
+func SelectQuantization(options []QuantOption, budget int64) (*QuantOption, error) {
+	var best *QuantOption
+	for i := range options {
+		if options[i].Size <= budget && (best == nil || options[i].Size > best.Size) {
+			best = &options[i]
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+
+	if len(options) == 0 {
+		return nil, fmt.Errorf("%w: the repo has no GGUF or safetensors weights", ErrNoQuantFits)
+	}
+	smallest := options[0]
+	for _, option := range options[1:] {
+		if option.Size < smallest.Size {
+			smallest = option
+		}
+	}
+	return nil, fmt.Errorf("%w: smallest is %s with %d bytes, budget is %d", ErrNoQuantFits, smallest.Name, smallest.Size, budget)
+}
+
+// DownloadQuantization downloads the largest quantization fitting in budget
+// bytes and returns the path of its file, or of the snapshot for sharded and
+// multi-file options.
+func (client *Client) DownloadQuantization(repo *Repo, budget int64) (string, *QuantOption, error) {
+	options, err := client.ListQuantizations(repo)
+	if err != nil {
+		return "", nil, err
+	}
+	option, err := SelectQuantization(options, budget)
+	if err != nil {
+		return "", nil, err
+	}
+
+	params := &DownloadParams{Repo: repo, Revision: repo.Revision}
+	if len(option.Files) == 1 {
+		params.FileName = option.Files[0]
+	} else {
+		for _, file := range option.Files {
+			params.AllowRegex = append(params.AllowRegex, regexp.MustCompile("^"+regexp.QuoteMeta(file)+"$"))
+		}
+	}
+
+	path, err := client.Download(params)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", option.Name, err)
+	}
+	return path, option, nil
+}
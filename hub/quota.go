@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrQuotaExceeded is returned when a download would take more than
+// DownloadParams.MaxDownloadSize or Client.RepoQuota allow, and when a server
+// sends more of a file than it declared while a quota applies.
+var ErrQuotaExceeded = errors.New("download quota exceeded")
+
+// blobLimit is the most bytes a single blob may take under the call's quotas,
+// 0 when none applies.
+func blobLimit(client *Client, params *DownloadParams) int64 {
+	limit := params.MaxDownloadSize
+	if client.RepoQuota > 0 && (limit <= 0 || client.RepoQuota < limit) {
+		limit = client.RepoQuota
+	}
+	return max(limit, 0)
+}
+
+// checkQuotas fails a plan downloading more than params.MaxDownloadSize, or
+// more than is left of client.RepoQuota after the repo's cached blobs.
+func checkQuotas(client *Client, params *DownloadParams, downloadSize int64) error {
+	if params.MaxDownloadSize > 0 && downloadSize > params.MaxDownloadSize {
+		return fmt.Errorf("%w: %s needs %d bytes, the limit is %d", ErrQuotaExceeded, params.Repo.Id, downloadSize, params.MaxDownloadSize)
+	}
+	if client.RepoQuota > 0 && downloadSize > 0 {
+		used := repoUsage(client, params.Repo)
+		if used+downloadSize > client.RepoQuota {
+			return fmt.Errorf("%w: %s needs %d bytes with %d cached, the quota is %d", ErrQuotaExceeded, params.Repo.Id, downloadSize, used, client.RepoQuota)
+		}
+	}
+	return nil
+}
+
+// repoUsage is the size of the repo's complete blobs in the cache. Partial
+// downloads are left out, as the plan counts them in full.
+func repoUsage(client *Client, repo *Repo) int64 {
+	blobsDir := filepath.Join(client.CacheDir, repoFolderName(repo.Id, repo.Type), "blobs")
+	entries, err := client.fs().ReadDir(blobsDir)
+	if err != nil {
+		return 0
+	}
+
+	var used int64
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".incomplete") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			used += info.Size()
+		}
+	}
+	return used
+}
+
+// sizeGuard holds a blob to its declared size, or to the client's blob limit
+// when the size is unknown, so a server can't write past the quota its plan
+// was checked against.
+type sizeGuard struct {
+	name     string
+	limit    int64
+	received int64
+}
+
+// newSizeGuard guards a blob download that starts at offset. It returns nil,
+// which never fails, when no quota applies.
+func newSizeGuard(client *Client, name string, declared int64, offset int64) *sizeGuard {
+	if client.maxBlobSize <= 0 {
+		return nil
+	}
+	limit := client.maxBlobSize
+	if declared > 0 {
+		limit = min(declared, limit)
+	}
+	return &sizeGuard{name: name, limit: limit, received: offset}
+}
+
+// expect fails before reading a body whose length already overshoots.
+func (g *sizeGuard) expect(contentLength int64) error {
+	if g == nil || contentLength < 0 {
+		return nil
+	}
+	return g.check(g.received + contentLength)
+}
+
+// add records n received bytes.
+func (g *sizeGuard) add(n int) error {
+	if g == nil {
+		return nil
+	}
+	g.received += int64(n)
+	return g.check(g.received)
+}
+
+func (g *sizeGuard) check(size int64) error {
+	if size > g.limit {
+		return fmt.Errorf("%w: server sent %d bytes of %s, more than the %d allowed", ErrQuotaExceeded, size, g.name, g.limit)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxStreamReconnects caps how often a single download attempt reconnects
+// after the connection drops mid-body. It is separate from the retry budget,
+// which restarts the whole attempt.
+const maxStreamReconnects = 3
+
+// canReconnect reports whether a read error is a dropped connection worth
+// continuing from the current offset.
+func canReconnect(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, ErrDownloadTooSlow)
+}
+
+// reconnectAt requests url again from offset after the previous response
+// body broke off. The server must answer with exactly that range, otherwise
+// the bytes already written can't be continued.
+func reconnectAt(ctx context.Context, client *Client, url string, headers *http.Header, offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headersFor(client, url, headers)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := client.downloadHTTPClient(0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		err := newHTTPError(resp)
+		resp.Body.Close()
+		return nil, fmt.Errorf("server did not resume at byte %d: %w", offset, err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server resumed at %q instead of byte %d", resp.Header.Get("Content-Range"), offset)
+	}
+
+	if err := decodeBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	log.Printf("[Download] Reconnected at byte %d", offset)
+	return resp, nil
+}
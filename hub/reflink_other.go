@@ -0,0 +1,12 @@
+//go:build !linux
+
+package hub
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(dst *os.File, src *os.File) error {
+	return errors.New("reflinks are only supported on linux")
+}
@@ -0,0 +1,95 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// refFilePath is the file under refs/ that caches the commit revision points
+// at. Like the python package, a ref with slashes such as refs/pr/1 or
+// release/v1 is a nested file rather than an escaped name, so both share a
+// cache. Names git wouldn't allow, which could escape refs/ or collide with
+// writeRef's temp files, are rejected.
+func refFilePath(storageFolder string, revision string) (string, error) {
+	if !validRefName(revision) {
+		return "", fmt.Errorf("invalid revision name %q", revision)
+	}
+	return filepath.Join(storageFolder, "refs", filepath.FromSlash(revision)), nil
+}
+
+// readRef returns the commit refs/<revision> points at. The hash is checked
+// before it is used to find a snapshot, so a corrupted ref can't point
+// outside snapshots/.
+func readRef(fsys FS, storageFolder string, revision string) (string, error) {
+	refPath, err := refFilePath(storageFolder, revision)
+	if err != nil {
+		return "", err
+	}
+	data, err := fsys.ReadFile(refPath)
+	if err != nil {
+		return "", fmt.Errorf("revision %s not found in cache: %w", revision, err)
+	}
+	commitHash := strings.TrimSpace(string(data))
+	if !isCommitHash(commitHash) {
+		return "", fmt.Errorf("ref %s holds %q, not a commit hash", revision, commitHash)
+	}
+	return commitHash, nil
+}
+
+func validRefName(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		// also rules out "." and "..", git refuses a leading dot anyway
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// refTempPath is where writeRef stages a ref. The leading dot keeps it apart
+// from every valid ref name.
+func refTempPath(refPath string) string {
+	return filepath.Join(filepath.Dir(refPath), "."+filepath.Base(refPath)+".tmp")
+}
+
+// clearRefPath removes cached refs that stand in the way of refPath. Git
+// never has "release" and "release/v1" at once, but the cache can still hold
+// one after the hub replaced it with the other.
+func (client *Client) clearRefPath(storageFolder string, refPath string) {
+	refsDir := filepath.Join(storageFolder, "refs")
+	rel, err := filepath.Rel(refsDir, refPath)
+	if err != nil {
+		return
+	}
+
+	dir := refsDir
+	segments := strings.Split(rel, string(filepath.Separator))
+	for _, segment := range segments[:len(segments)-1] {
+		dir = filepath.Join(dir, segment)
+		if info, err := client.fs().Lstat(dir); err == nil && !info.IsDir() {
+			log.Printf("[Download] Removing stale ref %s", filepath.ToSlash(strings.TrimPrefix(dir, refsDir+string(filepath.Separator))))
+			client.fs().Remove(dir)
+			return
+		}
+	}
+	if info, err := client.fs().Lstat(refPath); err == nil && info.IsDir() {
+		log.Printf("[Download] Removing stale refs under %s", filepath.ToSlash(rel))
+		client.fs().RemoveAll(refPath)
+	}
+}
+
+// isStaleRefTemp reports whether a file in refs/ is a temp file an
+// interrupted writeRef left behind. Older versions named them <ref>.tmp,
+// which are told apart from refs ending in .tmp by their partial hash.
+func isStaleRefTemp(name string, data []byte) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") {
+		return true
+	}
+	return strings.HasSuffix(base, ".tmp") && !isCommitHash(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRef(t *testing.T) {
+	storageFolder := t.TempDir()
+	commit := "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		revision string
+		contents string
+		want     string
+		wantErr  bool
+	}{
+		{revision: "main", contents: commit, want: commit},
+		{revision: "refs/pr/1", contents: commit + "\n", want: commit},
+		{revision: "broken", contents: "../../../etc", wantErr: true},
+		{revision: "empty", contents: "", wantErr: true},
+		{revision: "missing", wantErr: true},
+		{revision: "../escape", wantErr: true},
+	}
+	for _, tt := range tests {
+		if tt.contents != "" || tt.want != "" || tt.revision == "empty" {
+			refPath := filepath.Join(storageFolder, "refs", filepath.FromSlash(tt.revision))
+			if err := os.MkdirAll(filepath.Dir(refPath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(refPath, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := readRef(OSFS{}, storageFolder, tt.revision)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("readRef(%q) = %q, want an error", tt.revision, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("readRef(%q) = %q, %v, want %q", tt.revision, got, err, tt.want)
+		}
+	}
+}
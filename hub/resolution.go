@@ -0,0 +1,148 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Source is where Client.ResolutionOrder may serve a download from.
+type Source string
+
+const (
+	// the local cache, without touching the network
+	SourceCache Source = "cache"
+	// Client.MirrorEndpoint, on its own
+	SourceMirror Source = "mirror"
+	// Client.Endpoint, on its own
+	SourceOrigin Source = "origin"
+)
+
+// WithResolutionOrder tries the sources in turn for each call, e.g. the cache,
+// then an internal mirror, then the hub.
+func (client *Client) WithResolutionOrder(order ...Source) *Client {
+	c := client.clone()
+	c.ResolutionOrder = order
+	return c
+}
+
+// ParseResolutionOrder reads a comma-separated order such as
+// "cache,mirror,origin".
+func ParseResolutionOrder(s string) ([]Source, error) {
+	var order []Source
+	for _, name := range strings.Split(s, ",") {
+		source := Source(strings.TrimSpace(name))
+		switch source {
+		case SourceCache, SourceMirror, SourceOrigin:
+			order = append(order, source)
+		default:
+			return nil, fmt.Errorf("unknown source %q, expected cache, mirror or origin", name)
+		}
+	}
+	return order, nil
+}
+
+// downloadInOrder tries each source of client.ResolutionOrder in turn until
+// one serves the call, and records it in the call's summary. A snapshot is
+// only served from the cache when it holds every file the call selects from
+// the listing recorded when the snapshot was first downloaded.
+func downloadInOrder(client *Client, params *DownloadParams) (string, error) {
+	var errs []error
+	for _, source := range client.ResolutionOrder {
+		sourceClient, sourceParams, err := client.forSource(params, source)
+		if err != nil {
+			return "", err
+		}
+
+		if source == SourceCache && params.FileName == "" {
+			if err := client.checkCachedSnapshot(params); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", source, err))
+				continue
+			}
+		}
+
+		var path string
+		if params.FileName == "" {
+			path, err = snapshotDownload(sourceClient, sourceParams)
+		} else {
+			path, err = fileDownload(sourceClient, sourceParams)
+		}
+		if err == nil {
+			params.summary.recordSource(source)
+			return path, nil
+		}
+
+		// a call the caller or a quota refused fails the same everywhere
+		var hookErr *HookError
+		if errors.Is(err, ErrDownloadDeclined) || errors.Is(err, ErrQuotaExceeded) || errors.As(err, &hookErr) {
+			return "", err
+		}
+		log.Printf("[Download] %s from %s failed, trying the next source: %v", params.Repo.Id, source, err)
+		errs = append(errs, fmt.Errorf("%s: %w", source, err))
+	}
+	return "", fmt.Errorf("no source could serve %s: %w", params.Repo.Id, errors.Join(errs...))
+}
+
+// checkCachedSnapshot checks that the cache holds every file of the snapshot
+// that params select, by the repo's recorded listing at the commit. Without
+// a listing, a partial snapshot can't be told from a complete one.
+func (client *Client) checkCachedSnapshot(params *DownloadParams) error {
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(params.Repo.Id, params.Repo.Type))
+	commitHash := params.Revision
+	if !isCommitHash(commitHash) {
+		var err error
+		if commitHash, err = readRef(client.fs(), storageFolder, params.Revision); err != nil {
+			return fmt.Errorf("snapshot is not in the cache: %w", err)
+		}
+	}
+
+	listed := recordedSnapshotFiles(client, params.Repo, commitHash)
+	if listed == nil {
+		return fmt.Errorf("no file listing recorded for snapshot %s", commitHash)
+	}
+
+	files := filterFilesByPattern(listed, params.AllowPatterns, params.IgnorePatterns, params.AllowRegex, params.IgnoreRegex, params.CaseInsensitivePatterns)
+	if params.MaxFileSize > 0 || params.MinFileSize > 0 {
+		var tree []TreeEntry
+		if entry := readMetadata(client, params.Repo, treeKey(commitHash)); entry != nil {
+			tree = entry.Tree
+		}
+		files = filterFilesBySize(files, tree, params.MinFileSize, params.MaxFileSize)
+	}
+
+	snapshotPath := filepath.Join(storageFolder, "snapshots", commitHash)
+	for _, file := range files {
+		// pickle files a safetensors-only download skipped aren't missing
+		if client.SafeTensorsOnly && isPickleFile(file) {
+			continue
+		}
+		// follows the pointer to its blob
+		if _, err := client.fs().Stat(filepath.Join(snapshotPath, filepath.FromSlash(file))); err != nil {
+			return fmt.Errorf("snapshot %s is missing %s in the cache", commitHash, file)
+		}
+	}
+	return nil
+}
+
+// forSource is the client and params that fetch only from source.
+func (client *Client) forSource(params *DownloadParams, source Source) (*Client, *DownloadParams, error) {
+	paramsCopy := *params
+	c := client.clone()
+	switch source {
+	case SourceCache:
+		paramsCopy.LocalFilesOnly = true
+	case SourceMirror:
+		if client.MirrorEndpoint == "" {
+			return nil, nil, fmt.Errorf("ResolutionOrder names the mirror, but MirrorEndpoint is empty")
+		}
+		c.Endpoint = client.MirrorEndpoint
+		c.MirrorEndpoint = ""
+	case SourceOrigin:
+		c.MirrorEndpoint = ""
+	default:
+		return nil, nil, fmt.Errorf("unknown source %q in ResolutionOrder", source)
+	}
+	return c, &paramsCopy, nil
+}
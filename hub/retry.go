@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget caps retries across every file of a snapshot download, so a
+// flaky network fails the whole download early instead of letting each file
+// back off on its own.
+type RetryBudget struct {
+	// MaxRetries is the total number of retries shared by all files. Zero
+	// means no limit, a negative value disables retries.
+	MaxRetries int
+	// MaxElapsed bounds the cumulative time spent in failed attempts and
+	// backoff waits. Zero means no limit.
+	MaxElapsed time.Duration
+	// InitialInterval and MaxInterval bound the jittered exponential backoff
+	// between attempts of a single file.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+var DefaultRetryBudget = RetryBudget{
+	MaxRetries:      DefaultRetries * 4,
+	MaxElapsed:      10 * time.Minute,
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     30 * time.Second,
+}
+
+type retryBudget struct {
+	policy RetryBudget
+
+	mu      sync.Mutex
+	retries int
+	spent   time.Duration
+
+	summary *DownloadSummary
+}
+
+func newRetryBudget(policy *RetryBudget) *retryBudget {
+	if policy == nil {
+		policy = &DefaultRetryBudget
+	}
+
+	b := &retryBudget{policy: *policy}
+	if b.policy.InitialInterval <= 0 {
+		b.policy.InitialInterval = DefaultRetryBudget.InitialInterval
+	}
+	if b.policy.MaxInterval <= 0 {
+		b.policy.MaxInterval = DefaultRetryBudget.MaxInterval
+	}
+
+	return b
+}
+
+func (b *retryBudget) exhaustedLocked() bool {
+	if b.policy.MaxRetries < 0 {
+		return true
+	}
+	if b.policy.MaxRetries > 0 && b.retries >= b.policy.MaxRetries {
+		return true
+	}
+	if b.policy.MaxElapsed > 0 && b.spent >= b.policy.MaxElapsed {
+		return true
+	}
+	return false
+}
+
+// retry runs op until it succeeds, returns a backoff.Permanent error, the
+// shared budget runs out or ctx is done, which also cuts a backoff wait
+// short. Safe for concurrent use by several files.
+func (b *retryBudget) retry(ctx context.Context, name string, op func() error) error {
+	expo := backoff.NewExponentialBackOff()
+	expo.InitialInterval = b.policy.InitialInterval
+	expo.MaxInterval = b.policy.MaxInterval
+	expo.RandomizationFactor = 0.5
+	expo.MaxElapsedTime = 0
+	expo.Reset()
+
+	for {
+		started := time.Now()
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			return permanent.Err
+		}
+
+		b.mu.Lock()
+		b.spent += time.Since(started)
+		if b.exhaustedLocked() {
+			retries := b.retries
+			b.mu.Unlock()
+			return fmt.Errorf("%w after %d retries: %w", ErrRetryBudgetExhausted, retries, err)
+		}
+
+		b.retries++
+		b.summary.recordRetry()
+		delay := expo.NextBackOff()
+		if remaining := b.policy.MaxElapsed - b.spent; b.policy.MaxElapsed > 0 && delay > remaining {
+			delay = remaining
+		}
+		b.spent += delay
+		b.mu.Unlock()
+
+		log.Printf("[Download] Retrying %s in %s: %v", name, delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w while retrying: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DownloadRevisions downloads the snapshots of several revisions of
+// params.Repo, such as a branch and a pull request ref, and returns their
+// folders by revision. The files of every revision are resolved first, so a
+// blob the revisions share is downloaded once and linked into each snapshot.
+// params.Revision is ignored, and params.FileName must be empty.
+func (client *Client) DownloadRevisions(params *DownloadParams, revisions []string) (map[string]string, error) {
+	client, params, cancel, err := client.prepareDownload(params)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	if params.FileName != "" {
+		return nil, fmt.Errorf("DownloadRevisions downloads whole snapshots, not %s", params.FileName)
+	}
+
+	revisionParams := make([]*DownloadParams, len(revisions))
+	for i, revision := range revisions {
+		paramsCopy := *params
+		repoCopy := *params.Repo
+		repoCopy.Revision = revision
+		paramsCopy.Repo = &repoCopy
+		paramsCopy.Revision = revision
+		revisionParams[i] = &paramsCopy
+	}
+
+	folders := make(map[string]string, len(revisions))
+	if err := checkConnectivity(client, params.LocalFilesOnly); err != nil {
+		for _, p := range revisionParams {
+			cachedSnapshot, err := findCachedSnapshot(client, p)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find snapshot of %s in cache and downloads are disabled: %w", p.Revision, err)
+			}
+			folders[p.Revision] = cachedSnapshot
+		}
+		return folders, nil
+	}
+
+	plans := make([]*snapshotPlan, len(revisions))
+	var g errgroup.Group
+	for i, p := range revisionParams {
+		g.Go(func() error {
+			plan, err := planSnapshot(client, p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.Revision, err)
+			}
+			plans[i] = plan
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// group the files of all revisions by blob; files without an etag can't
+	// be matched and get a group of their own
+	type revisionFile struct {
+		params   *DownloadParams
+		metadata *FileMetadata
+	}
+	var blobs []string
+	var downloadSize int64
+	groups := make(map[string][]revisionFile)
+	for i, plan := range plans {
+		var newFiles []string
+		for _, name := range plan.files {
+			metadata := plan.metadata[name]
+			key := plan.commitHash + "/" + name
+			if metadata != nil && metadata.ETag != "" {
+				key = metadata.ETag
+			}
+			if _, ok := groups[key]; !ok {
+				blobs = append(blobs, key)
+				newFiles = append(newFiles, name)
+			}
+			groups[key] = append(groups[key], revisionFile{
+				params:   snapshotFileParams(revisionParams[i], plan.commitHash, name),
+				metadata: metadata,
+			})
+		}
+
+		// blobs shared with an earlier revision were already in its plan
+		revisionPlan, err := confirmDownload(client, revisionParams[i], plan.commitHash, newFiles, plan.metadata)
+		if err != nil {
+			return nil, err
+		}
+		downloadSize += revisionPlan.DownloadSize
+	}
+	// the quotas hold for all revisions together
+	if err := checkQuotas(client, params, downloadSize); err != nil {
+		return nil, err
+	}
+	log.Printf("[Download] %d revisions of %s need %d distinct blobs", len(revisions), params.Repo.Id, len(blobs))
+
+	budget := newRetryBudget(params.RetryBudget)
+	budget.summary = params.summary
+
+	pd := newParallelDownloader(params.context(), params.Workers, params.CollectErrors)
+	for _, key := range blobs {
+		files := groups[key]
+		pd.Go(func(ctx context.Context) error {
+			// the first file fetches the blob, the others find it in the
+			// cache and only link it into their snapshot
+			for i, file := range files {
+				if i > 0 {
+					file.params.ForceDownload = false
+				}
+				file.params.ctx = ctx
+				if err := downloadSnapshotFile(client, budget, file.params, file.metadata); err != nil {
+					return fmt.Errorf("failed to download %s at %s: %w", file.params.FileName, file.params.Revision, err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := pd.Wait(); err != nil {
+		return nil, err
+	}
+
+	for i, plan := range plans {
+		if err := finishSnapshot(client, revisionParams[i], plan); err != nil {
+			return nil, err
+		}
+		folders[revisions[i]] = plan.folder
+	}
+	return folders, nil
+}
@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// SentenceTransformerModule is an entry of a sentence-transformers modules.json.
+type SentenceTransformerModule struct {
+	Idx  int    `json:"idx"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// DownloadSentenceTransformer fetches everything an embedding server needs to
+// load a sentence-transformers model: modules.json, the configs, tokenizer and
+// weights of the root transformer, and each module folder listed in
+// modules.json (pooling, dense, normalize). Safetensors weights are preferred
+// over pytorch .bin files per folder. Returns the snapshot path.
+func (client *Client) DownloadSentenceTransformer(repo *Repo) (string, error) {
+	modulesPath, err := client.Download(&DownloadParams{
+		Repo:     repo,
+		FileName: "modules.json",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get modules.json: %w", err)
+	}
+
+	data, err := client.fs().ReadFile(modulesPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read modules.json: %w", err)
+	}
+
+	var modules []SentenceTransformerModule
+	if err := json.Unmarshal(data, &modules); err != nil {
+		return "", fmt.Errorf("failed to parse modules.json: %w", err)
+	}
+
+	// the repo listing tells which folders publish safetensors; offline, the
+	// cache only holds whichever format was downloaded before
+	var files []string
+	if checkConnectivity(client, false) == nil {
+		info, err := getModelInfo(client, repo)
+		if err != nil {
+			return "", fmt.Errorf("failed to get repository info: %w", err)
+		}
+		for _, sibling := range info.Siblings {
+			files = append(files, sibling.RFileName)
+		}
+	}
+
+	folders := []string{""}
+	for _, module := range modules {
+		if module.Path != "" {
+			folders = append(folders, strings.Trim(module.Path, "/"))
+		}
+	}
+
+	var patterns []string
+	for _, folder := range folders {
+		patterns = append(patterns, sentenceTransformerPatterns(client, folder, files)...)
+	}
+
+	return client.Download(&DownloadParams{
+		Repo:          repo,
+		AllowPatterns: MergePatterns(patterns),
+	})
+}
+
+func sentenceTransformerPatterns(client *Client, folder string, files []string) []string {
+	join := func(name string) string {
+		if folder == "" {
+			return name
+		}
+		return folder + "/" + name
+	}
+
+	// configs, vocabularies and sentencepiece models
+	patterns := []string{join("*.json"), join("*.txt"), join("*.model")}
+
+	safetensors := []string{join("model.safetensors"), join("model-*-of-*.safetensors")}
+	pytorch := []string{join("pytorch_model.bin"), join("pytorch_model-*-of-*.bin")}
+
+	switch {
+	case client.SafeTensorsOnly || hasSafetensorsIn(folder, files):
+		patterns = append(patterns, safetensors...)
+	case len(files) > 0:
+		patterns = append(patterns, pytorch...)
+	default:
+		patterns = append(patterns, safetensors...)
+		patterns = append(patterns, pytorch...)
+	}
+
+	return patterns
+}
+
+func hasSafetensorsIn(folder string, files []string) bool {
+	for _, file := range files {
+		if path.Dir(file) == path.Clean("./"+folder) && strings.HasSuffix(file, ".safetensors") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package hub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BundleVerifier checks sigstore bundles (".sigstore.json", ".sigstore"), as
+// written by `cosign sign-blob --bundle` or sigstore-python: the bundle's
+// message signature over the file's SHA256 is checked against a known key,
+// or against the bundle's Fulcio certificate, which must chain to the given
+// roots and name the expected identity and OIDC issuer.
+//
+// Fulcio certificates live for minutes, so a keyless certificate is checked
+// at the time the bundle's transparency log entry records. That time is only
+// trusted once the entry's signed entry timestamp verifies against the Rekor
+// key and the entry is for this signature and certificate; the inclusion
+// proof is not checked against a Rekor checkpoint.
+type BundleVerifier struct {
+	publicKey crypto.PublicKey
+
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	rekorKey      crypto.PublicKey
+	rekorLogID    []byte
+	identity      string
+	issuer        string
+}
+
+// AnyIdentity, as the identity or issuer of NewKeylessBundleVerifier, accepts
+// every one, e.g. to check only that a file went through sigstore at all.
+const AnyIdentity = "*"
+
+// NewBundleVerifier verifies bundles signed with a long-lived key.
+func NewBundleVerifier(publicKeyPEM []byte) (*BundleVerifier, error) {
+	key, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleVerifier{publicKey: key}, nil
+}
+
+// NewKeylessBundleVerifier verifies bundles signed with a Fulcio certificate
+// chaining to rootsPEM, e.g. the sigstore public-good root and intermediate,
+// and logged in the Rekor instance with rekorKeyPEM. identity is the
+// certificate's email or URI SAN, such as a CI workflow ref, and issuer the
+// OIDC issuer that vouched for it. Both are required, as anyone can get a
+// Fulcio certificate; pass AnyIdentity to accept any.
+func NewKeylessBundleVerifier(rootsPEM []byte, rekorKeyPEM []byte, identity string, issuer string) (*BundleVerifier, error) {
+	if identity == "" || issuer == "" {
+		return nil, fmt.Errorf("keyless verification needs an identity and an issuer, or AnyIdentity")
+	}
+	rekorKey, err := parsePublicKey(rekorKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Rekor key: %w", err)
+	}
+	rekorDER, err := x509.MarshalPKIXPublicKey(rekorKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Rekor key: %w", err)
+	}
+	// Rekor names its log by the sha256 of its key
+	logID := sha256.Sum256(rekorDER)
+
+	v := &BundleVerifier{
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+		rekorKey:      rekorKey,
+		rekorLogID:    logID[:],
+		identity:      identity,
+		issuer:        issuer,
+	}
+
+	// self-signed certificates are roots, the rest intermediates
+	roots := 0
+	for rest := rootsPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root certificate: %w", err)
+		}
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			v.roots.AddCert(cert)
+			roots++
+		} else {
+			v.intermediates.AddCert(cert)
+		}
+	}
+	if roots == 0 {
+		return nil, fmt.Errorf("no root certificates found")
+	}
+
+	return v, nil
+}
+
+func (v *BundleVerifier) Suffixes() []string {
+	return []string{".sigstore.json", ".sigstore"}
+}
+
+// sigstoreBundle is the part of a sigstore bundle, v0.1 to v0.3, needed to
+// check a message signature.
+type sigstoreBundle struct {
+	MediaType            string `json:"mediaType"`
+	VerificationMaterial struct {
+		Certificate *struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificate"`
+		X509CertificateChain *struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []sigstoreTlogEntry `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	MessageSignature *struct {
+		MessageDigest struct {
+			Algorithm string `json:"algorithm"`
+			Digest    []byte `json:"digest"`
+		} `json:"messageDigest"`
+		Signature []byte `json:"signature"`
+	} `json:"messageSignature"`
+}
+
+// sigstoreTlogEntry is a bundle's Rekor entry with its signed entry
+// timestamp. Integers are strings, as in the protobuf JSON mapping.
+type sigstoreTlogEntry struct {
+	LogIndex string `json:"logIndex"`
+	LogID    struct {
+		KeyID []byte `json:"keyId"`
+	} `json:"logId"`
+	IntegratedTime   string `json:"integratedTime"`
+	InclusionPromise *struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	CanonicalizedBody []byte `json:"canonicalizedBody"`
+}
+
+// hashedRekord is the Rekor entry body of a signed blob.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+func (v *BundleVerifier) Verify(blob io.Reader, signature []byte) error {
+	var bundle sigstoreBundle
+	if err := json.Unmarshal(signature, &bundle); err != nil {
+		return fmt.Errorf("invalid sigstore bundle: %w", err)
+	}
+	if bundle.MessageSignature == nil {
+		return fmt.Errorf("sigstore bundle has no message signature")
+	}
+
+	digest, err := readerSHA256(blob)
+	if err != nil {
+		return err
+	}
+	messageDigest := bundle.MessageSignature.MessageDigest
+	if messageDigest.Algorithm != "SHA2_256" {
+		return fmt.Errorf("unsupported bundle digest %s", messageDigest.Algorithm)
+	}
+	if !bytes.Equal(messageDigest.Digest, digest) {
+		return fmt.Errorf("bundle digest doesn't match the file")
+	}
+
+	key := v.publicKey
+	if key == nil {
+		cert, err := v.verifyCertificate(&bundle, digest)
+		if err != nil {
+			return err
+		}
+		key = cert.PublicKey
+	}
+
+	return verifyDigest(key, digest, bundle.MessageSignature.Signature)
+}
+
+// fulcio certificate extensions naming the OIDC issuer, the original
+// raw-string one and its DER-encoded replacement
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// verifyCertificate returns the bundle's signing certificate once it chains
+// to the verifier's roots, at the time its log entry records, and names its
+// identity and issuer.
+func (v *BundleVerifier) verifyCertificate(bundle *sigstoreBundle, digest []byte) (*x509.Certificate, error) {
+	material := bundle.VerificationMaterial
+	var raw [][]byte
+	switch {
+	case material.Certificate != nil:
+		raw = append(raw, material.Certificate.RawBytes)
+	case material.X509CertificateChain != nil:
+		for _, cert := range material.X509CertificateChain.Certificates {
+			raw = append(raw, cert.RawBytes)
+		}
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("sigstore bundle has no certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(raw[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle certificate: %w", err)
+	}
+	intermediates := v.intermediates.Clone()
+	for _, der := range raw[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle certificate: %w", err)
+		}
+		intermediates.AddCert(cert)
+	}
+
+	// fulcio certificates live for minutes; they must have been valid when
+	// the signature was logged
+	if len(material.TlogEntries) == 0 {
+		return nil, fmt.Errorf("sigstore bundle has no transparency log entry")
+	}
+	loggedAt, err := v.verifyTlogEntry(&material.TlogEntries[0], leaf, bundle.MessageSignature.Signature, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   loggedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("untrusted bundle certificate: %w", err)
+	}
+
+	if v.identity != AnyIdentity && !certHasIdentity(leaf, v.identity) {
+		return nil, fmt.Errorf("bundle certificate is not for %s", v.identity)
+	}
+	if v.issuer != AnyIdentity && certIssuer(leaf) != v.issuer {
+		return nil, fmt.Errorf("bundle certificate was not issued through %s", v.issuer)
+	}
+
+	return leaf, nil
+}
+
+// verifyTlogEntry checks the entry's signed entry timestamp against the Rekor
+// key and that the entry logs this signature by this certificate over this
+// digest, and returns the time it was logged.
+func (v *BundleVerifier) verifyTlogEntry(entry *sigstoreTlogEntry, leaf *x509.Certificate, signature []byte, digest []byte) (time.Time, error) {
+	if entry.InclusionPromise == nil {
+		return time.Time{}, fmt.Errorf("bundle log entry has no signed entry timestamp")
+	}
+	if !bytes.Equal(entry.LogID.KeyID, v.rekorLogID) {
+		return time.Time{}, fmt.Errorf("bundle log entry is from another transparency log")
+	}
+	logIndex, err := strconv.ParseInt(entry.LogIndex, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid bundle log index: %w", err)
+	}
+	seconds, err := strconv.ParseInt(entry.IntegratedTime, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid bundle log time: %w", err)
+	}
+
+	// Rekor signs the canonical JSON of these fields, keys sorted
+	payload, err := json.Marshal(struct {
+		Body           []byte `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{entry.CanonicalizedBody, seconds, hex.EncodeToString(entry.LogID.KeyID), logIndex})
+	if err != nil {
+		return time.Time{}, err
+	}
+	payloadDigest := sha256.Sum256(payload)
+	if err := verifyDigest(v.rekorKey, payloadDigest[:], entry.InclusionPromise.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("bundle log entry timestamp: %w", err)
+	}
+
+	var body hashedRekord
+	if err := json.Unmarshal(entry.CanonicalizedBody, &body); err != nil {
+		return time.Time{}, fmt.Errorf("invalid bundle log entry: %w", err)
+	}
+	if body.Kind != "hashedrekord" {
+		return time.Time{}, fmt.Errorf("unsupported bundle log entry kind %q", body.Kind)
+	}
+	hash := body.Spec.Data.Hash
+	if hash.Algorithm != "sha256" || hash.Value != hex.EncodeToString(digest) {
+		return time.Time{}, fmt.Errorf("bundle log entry is for another file")
+	}
+	if !bytes.Equal(body.Spec.Signature.Content, signature) {
+		return time.Time{}, fmt.Errorf("bundle log entry is for another signature")
+	}
+	block, _ := pem.Decode(body.Spec.Signature.PublicKey.Content)
+	if block == nil || !bytes.Equal(block.Bytes, leaf.Raw) {
+		return time.Time{}, fmt.Errorf("bundle log entry is for another certificate")
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+func certHasIdentity(cert *x509.Certificate, identity string) bool {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func certIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuer):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,261 @@
+package hub
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials are the keys used to sign requests to S3.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SigV4Auth signs requests with AWS Signature Version 4, for mirrors served
+// from a private S3 or MinIO bucket. Bodies are not hashed, which S3 accepts
+// as UNSIGNED-PAYLOAD.
+type SigV4Auth struct {
+	Credentials AWSCredentials
+	Region      string
+	// Service defaults to s3.
+	Service string
+}
+
+// NewSigV4Auth loads credentials from the environment (AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN) or else from the shared
+// credentials file for AWS_PROFILE. An empty region is taken from AWS_REGION
+// or AWS_DEFAULT_REGION, then us-east-1.
+func NewSigV4Auth(region string) (*SigV4Auth, error) {
+	creds, err := LoadAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &SigV4Auth{Credentials: creds, Region: region, Service: "s3"}, nil
+}
+
+// LoadAWSCredentials returns the first credentials found in the environment
+// or the shared credentials file (AWS_SHARED_CREDENTIALS_FILE, or
+// ~/.aws/credentials) under the profile AWS_PROFILE, or default.
+func LoadAWSCredentials() (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("no AWS credentials in the environment: %w", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	return loadSharedCredentials(path, profile)
+}
+
+// loadSharedCredentials reads one profile from an ini-style credentials file.
+func loadSharedCredentials(path string, profile string) (AWSCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to read AWS credentials: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		creds   AWSCredentials
+		section string
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to read AWS credentials: %w", err)
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return AWSCredentials{}, errors.New("no AWS credentials for profile " + profile + " in " + path)
+	}
+	return creds, nil
+}
+
+func (a *SigV4Auth) Apply(req *http.Request) {
+	if a.Credentials.AccessKeyID == "" {
+		log.Printf("[Download] No AWS credentials to sign %s", redactURL(req.URL))
+		return
+	}
+	a.sign(req, time.Now().UTC())
+}
+
+const sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+func (a *SigV4Auth) sign(req *http.Request, now time.Time) {
+	service := a.Service
+	if service == "" {
+		service = "s3"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedPayload)
+	if a.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.Credentials.SessionToken)
+	}
+
+	// sign host and the x-amz-* headers only; proxies may rewrite the rest
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalPath(req.URL),
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sigV4UnsignedPayload,
+	}, "\n")
+
+	scope := day + "/" + a.Region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+a.Credentials.SecretAccessKey), day)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.Credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// sigV4CanonicalPath encodes the path as it goes out, segment by segment, so
+// an escaped slash, as in a refs%2Fpr%2F1 revision, stays part of its segment.
+func sigV4CanonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segments[i] = sigV4Escape(segment, true)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4CanonicalQuery(query map[string][]string) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything but the RFC 3986 unreserved
+// characters, and slashes unless escapeSlash is set.
+func sigV4Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,23 @@
+package hub
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSigV4CanonicalPath(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://bucket.example.com":                                       "/",
+		"https://bucket.example.com/org/model/resolve/main/config.json":    "/org/model/resolve/main/config.json",
+		"https://bucket.example.com/org/model/resolve/refs%2Fpr%2F1/a%20b": "/org/model/resolve/refs%2Fpr%2F1/a%20b",
+		"https://bucket.example.com/org/model/resolve/main/weights(1).bin": "/org/model/resolve/main/weights%281%29.bin",
+	} {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sigV4CanonicalPath(req.URL); got != want {
+			t.Errorf("%s: got %s, want %s", rawURL, got, want)
+		}
+	}
+}
@@ -1,22 +1,89 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
 	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
 )
 
 type ModelInfo struct {
-	Sha        string         `json:"sha"`
-	Files      []string       `json:"files"`
-	Siblings   []ModelSibling `json:"siblings"`
+	Id                 string              `json:"id"`
+	Author             string              `json:"author,omitempty"`
+	Sha                string              `json:"sha"`
+	Private            bool                `json:"private"`
+	Disabled           bool                `json:"disabled,omitempty"`
+	Gated              GatedMode           `json:"gated"`
+	Downloads          int64               `json:"downloads"`
+	Likes              int64               `json:"likes"`
+	Tags               []string            `json:"tags,omitempty"`
+	PipelineTag        string              `json:"pipeline_tag,omitempty"`
+	LibraryName        string              `json:"library_name,omitempty"`
+	CreatedAt          time.Time           `json:"createdAt"`
+	LastModified       time.Time           `json:"lastModified"`
+	CardData           map[string]any      `json:"cardData,omitempty"`
+	Config             map[string]any      `json:"config,omitempty"`
+	Safetensors        *SafetensorsInfo    `json:"safetensors,omitempty"`
+	Files              []string            `json:"files"`
+	Siblings           []ModelSibling      `json:"siblings"`
+	SecurityRepoStatus *SecurityRepoStatus `json:"securityRepoStatus,omitempty"`
+}
+
+// GatedMode is "auto" or "manual" for gated repos and empty otherwise.
+type GatedMode string
+
+func (g *GatedMode) UnmarshalJSON(data []byte) error {
+	// the API sends false for repos that aren't gated
+	var mode string
+	if err := json.Unmarshal(data, &mode); err != nil {
+		*g = ""
+		return nil
+	}
+	*g = GatedMode(mode)
+	return nil
+}
+
+// SafetensorsInfo is the parameter count per dtype parsed from the repo's
+// safetensors headers.
+type SafetensorsInfo struct {
+	Parameters map[string]int64 `json:"parameters"`
+	Total      int64            `json:"total"`
+}
+
+type SecurityRepoStatus struct {
+	ScansDone       bool            `json:"scansDone"`
+	FilesWithIssues []SecurityIssue `json:"filesWithIssues"`
 }
 
+type SecurityIssue struct {
+	Path  string `json:"path"`
+	Level string `json:"level"`
+}
+
+// ModelSibling is a file of the repo. Size, BlobId and LFS are only set by
+// Client.ModelInfo, which asks the API for blob details.
 type ModelSibling struct {
-	RFileName string `json:"rfilename"`
+	RFileName string      `json:"rfilename"`
+	Size      int64       `json:"size,omitempty"`
+	BlobId    string      `json:"blobId,omitempty"`
+	LFS       *SiblingLFS `json:"lfs,omitempty"`
+}
+
+type SiblingLFS struct {
+	Sha256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	PointerSize int64  `json:"pointerSize"`
 }
 
 
@@ -26,18 +93,143 @@ func snapshotDownload(client *Client, params *DownloadParams) (string, error) {
 	}
 
 	// check connectivity
-	if err := checkConnectivity(params.LocalFilesOnly); err != nil {
-		cachedSnapshot, err := findCachedSnapshot(client.CacheDir, params)
+	if err := checkConnectivity(client, params.LocalFilesOnly); err != nil {
+		cachedSnapshot, err := findCachedSnapshot(client, params)
 		if err != nil {
 			return "", fmt.Errorf("cannot find snapshot in cache and downloads are disabled: %w", err)
 		}
 		return cachedSnapshot, nil
 	}
 
+	plan, err := planSnapshot(client, params)
+	if err != nil {
+		return "", err
+	}
+	filesToDownload := plan.files
+
+	if _, err := confirmDownload(client, params, plan.commitHash, filesToDownload, plan.metadata); err != nil {
+		return "", err
+	}
+
+	// this snapshot's counts, kept apart from the caller's summary, which may
+	// span several downloads, for the progress bar
+	snapshotSummary := &DownloadSummary{}
+	defer func() {
+		if params.summary != nil {
+			params.summary.Add(snapshotSummary)
+		}
+	}()
+
+	budget := newRetryBudget(params.RetryBudget)
+	budget.summary = snapshotSummary
+
+	totalBar := client.addTotalBar(
+		int64(len(filesToDownload)),
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(fmt.Sprintf("Fetching %d files for %s:", len(filesToDownload), params.Repo.Id), decor.WCSyncSpaceR),
+			decor.CountersNoUnit("%d/%d", decor.WCSyncSpace),
+		),
+		mpb.AppendDecorators(
+			decor.Any(func(decor.Statistics) string { return snapshotSummary.Progress() }, decor.WCSyncSpace),
+		),
+	)
+
+	// files sharing a blob go to one worker, which fetches it once and links
+	// it for the others
+	var blobs []string
+	groups := make(map[string][]string)
+	for _, filename := range filesToDownload {
+		key := filename
+		if metadata := plan.metadata[filename]; metadata != nil && metadata.ETag != "" {
+			key = metadata.ETag
+		}
+		if _, ok := groups[key]; !ok {
+			blobs = append(blobs, key)
+		}
+		groups[key] = append(groups[key], filename)
+	}
+
+	var mu sync.Mutex
+	completed := make(map[string]bool, len(filesToDownload))
+	pd := newParallelDownloader(params.context(), params.Workers, params.CollectErrors)
+	for _, key := range blobs {
+		files := groups[key]
+		pd.Go(func(ctx context.Context) error {
+			for i, filename := range files {
+				fileParams := snapshotFileParams(params, plan.commitHash, filename)
+				fileParams.summary = snapshotSummary
+				fileParams.ctx = ctx
+				if i > 0 {
+					fileParams.ForceDownload = false
+				}
+				if err := downloadSnapshotFile(client, budget, fileParams, plan.metadata[filename]); err != nil {
+					log.Printf("[Download] Error downloading file %s: %v", filename, err)
+					return fmt.Errorf("failed to download %s: %w", filename, err)
+				}
+				mu.Lock()
+				completed[filename] = true
+				mu.Unlock()
+				totalBar.Increment()
+			}
+			return nil
+		})
+	}
+
+	if err := pd.Wait(); err != nil {
+		totalBar.Abort(true)
+		if ctx := params.context(); ctx.Err() != nil {
+			partial := &PartialDownloadError{SnapshotPath: plan.folder, Err: ctx.Err()}
+			for _, filename := range filesToDownload {
+				if completed[filename] {
+					partial.Completed = append(partial.Completed, filename)
+				} else {
+					partial.Remaining = append(partial.Remaining, filename)
+				}
+			}
+			log.Printf("[Download] Deadline exceeded after %d of %d files", len(partial.Completed), len(filesToDownload))
+			return "", partial
+		}
+		return "", err
+	}
+	// completes the bar even for an empty snapshot, whose total of 0 mpb
+	// treats as unknown
+	totalBar.SetTotal(int64(len(filesToDownload)), true)
+	log.Printf("[Download] %s: %s", params.Repo.Id, snapshotSummary.Progress())
+
+	if err := finishSnapshot(client, params, plan); err != nil {
+        return "", err
+    }
+
+    return plan.folder, nil
+}
+
+// snapshotPlan is a snapshot's commit and files, resolved before any of them
+// is downloaded.
+type snapshotPlan struct {
+	commitHash string
+	folder     string
+	files      []string
+	metadata   map[string]*FileMetadata
+}
+
+// planSnapshot resolves params.Revision to a commit, caches the ref, and
+// lists the files the snapshot downloads after filtering.
+func planSnapshot(client *Client, params *DownloadParams) (*snapshotPlan, error) {
 	// get repository info from API
 	modelInfo, err := getModelInfo(client, params.Repo)
 	if err != nil {
-		return "", fmt.Errorf("failed to get repository info: %w", err)
+		return nil, fmt.Errorf("failed to get repository info: %w", err)
+	}
+
+	// the commit names the snapshot folder, and every file a path in it
+	if !validPathSegment(modelInfo.Sha) {
+		return nil, fmt.Errorf("%w: commit %q", ErrUnsafePath, modelInfo.Sha)
+	}
+	for _, sibling := range modelInfo.Siblings {
+		if err := checkRepoFilePath(sibling.RFileName); err != nil {
+			return nil, fmt.Errorf("%s lists a file outside its snapshot: %w", params.Repo.Id, err)
+		}
 	}
 
 	// setup storage folder
@@ -45,87 +237,170 @@ func snapshotDownload(client *Client, params *DownloadParams) (string, error) {
 		client.CacheDir,
 		repoFolderName(params.Repo.Id, params.Repo.Type),
 	)
-	snapshotFolder := filepath.Join(storageFolder, "snapshots", modelInfo.Sha)
+	plan := &snapshotPlan{
+		commitHash: modelInfo.Sha,
+		folder:     filepath.Join(storageFolder, "snapshots", modelInfo.Sha),
+	}
 
 	// cache commit hash for revision
 	if params.Revision != modelInfo.Sha {
-		refPath := filepath.Join(storageFolder, "refs", params.Revision)
-		os.MkdirAll(filepath.Dir(refPath), 0755)
-		if err := os.WriteFile(refPath, []byte(modelInfo.Sha), 0644); err != nil {
-			return "", fmt.Errorf("failed to cache revision: %w", err)
+		if err := client.writeRef(storageFolder, params.Revision, modelInfo.Sha); err != nil {
+			return nil, fmt.Errorf("failed to cache revision: %w", err)
 		}
+		client.indexRef(params.Repo, params.Revision, modelInfo.Sha)
 	}
 
-
 	// filter files based on patterns before downloading
 	var filesToDownload []string
 	for _, sibling := range modelInfo.Siblings {
 		filesToDownload = append(filesToDownload, sibling.RFileName)
 	}
-	filesToDownload = filterFilesByPattern(filesToDownload, params.AllowPatterns, params.IgnorePatterns)
+	recordSnapshotFiles(client, params.Repo, modelInfo.Sha, filesToDownload)
+	filesToDownload = filterFilesByPattern(filesToDownload, params.AllowPatterns, params.IgnorePatterns, params.AllowRegex, params.IgnoreRegex, params.CaseInsensitivePatterns)
 
-	// pd := newParallelDownloader(client, len(filesToDownload), params.Repo.Id)
+	if client.SafeTensorsOnly {
+		filesToDownload = filterUnsafeFiles(filesToDownload, modelInfo.SecurityRepoStatus)
+	}
 
+	// a single paginated tree listing resolves etags and sizes for every file,
+	// files missing from it fall back to a HEAD request
+	tree, treeErr := cachedRepoTree(client, params.Repo, modelInfo.Sha)
+	if treeErr != nil {
+		log.Printf("[Download] Failed to list repo tree, resolving files individually: %v", treeErr)
+	}
+	plan.metadata = metadataFromTree(client, params.Repo, modelInfo.Sha, tree)
 
-	// start download
-    for _, filename := range filesToDownload {
-        fileParams := &DownloadParams{
-            Repo:           params.Repo,
-            FileName:       filename,
-            Revision:       modelInfo.Sha,
-            ForceDownload:  params.ForceDownload,
-            LocalFilesOnly: params.LocalFilesOnly,
-        }
-        log.Printf("[Download] Starting sequential download for %s", filename)
-		_, err := fileDownload(client, fileParams)
-		if err != nil {
-			log.Printf("[Download] Error downloading file %s: %v", filename, err)
-			return "", fmt.Errorf("failed to download %s: %w", filename, err)
+	if params.MaxFileSize > 0 || params.MinFileSize > 0 {
+		if treeErr != nil {
+			return nil, fmt.Errorf("failed to get file sizes: %w", treeErr)
 		}
-		log.Printf("[Download] Completed download for %s", filename)
-    }
+		filesToDownload = filterFilesBySize(filesToDownload, tree, params.MinFileSize, params.MaxFileSize)
+	}
+	plan.files = filesToDownload
 
-    // wait for all downloads
-    // pd.Wait()
+	return plan, nil
+}
+
+// snapshotFileParams are the params for downloading one file of a snapshot at
+// commitHash.
+func snapshotFileParams(params *DownloadParams, commitHash string, filename string) *DownloadParams {
+	return &DownloadParams{
+		Repo:           params.Repo,
+		FileName:       filename,
+		Revision:       commitHash,
+		ForceDownload:  params.ForceDownload,
+		LocalFilesOnly: params.LocalFilesOnly,
+		PerFileTimeout: params.PerFileTimeout,
+		Hooks:          params.Hooks,
+		summary:        params.summary,
+		planned:        true,
+		ctx:            params.context(),
+	}
+}
+
+// downloadSnapshotFile downloads a snapshot file, retrying within budget.
+func downloadSnapshotFile(client *Client, budget *retryBudget, fileParams *DownloadParams, metadata *FileMetadata) error {
+	ctx := fileParams.context()
+	return budget.retry(ctx, fileParams.FileName, func() error {
+		// past the deadline, stop instead of retrying
+		if err := ctx.Err(); err != nil {
+			return backoff.Permanent(err)
+		}
+		_, err := fileDownloadWithMetadata(client, fileParams, metadata)
+		var hookErr *HookError
+		if errors.As(err, &hookErr) || errors.Is(err, ErrUnsafePath) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrVerificationFailed) {
+			return backoff.Permanent(err)
+		}
+		// retrying won't fix a missing token, an unaccepted license or a
+		// file that isn't there
+		var fileErr *FileError
+		if errors.As(err, &fileErr) && (fileErr.Kind == ErrorKindAuth || fileErr.Kind == ErrorKindNotFound) {
+			return backoff.Permanent(err)
+		}
+		return err
+	})
+}
 
+// finishSnapshot writes the checksum manifest, if asked for, and runs the
+// AfterSnapshot hooks for a downloaded snapshot.
+func finishSnapshot(client *Client, params *DownloadParams, plan *snapshotPlan) error {
+	if params.ChecksumFormat != "" {
+		manifestPath, err := client.writeChecksums(plan.folder, plan.files, params.ChecksumFormat, params.ChecksumDir)
+		if err != nil {
+			return fmt.Errorf("failed to write checksums: %w", err)
+		}
+		if err := client.applyPerms(manifestPath, client.fileMode(), client.FileMode != 0); err != nil {
+			return err
+		}
+		log.Printf("[Download] Wrote checksums to %s", manifestPath)
+	}
 
-    // // Check for errors
-    // for err := range pd.errors {
-    //     if err != nil {
-    //         return "", err
-    //     }
-    // }
+	return runHooks(client, params, &HookEvent{
+		Stage:      AfterSnapshot,
+		RepoId:     params.Repo.Id,
+		RepoType:   params.Repo.Type,
+		CommitHash: plan.commitHash,
+		Path:       plan.folder,
+		Files:      plan.files,
+	})
+}
 
-    return snapshotFolder, nil
+// ModelInfo fetches the repo's metadata from the API, including sibling sizes
+// and LFS hashes.
+func (client *Client) ModelInfo(repo *Repo) (*ModelInfo, error) {
+	return fetchModelInfo(client, repo, true)
 }
 
 func getModelInfo(client *Client, repo *Repo) (*ModelInfo, error) {
-	url := fmt.Sprintf("%s/api/models/%s", client.Endpoint, repo.Id)
+	return fetchModelInfo(client, repo, false)
+}
+
+func fetchModelInfo(client *Client, repo *Repo, blobs bool) (*ModelInfo, error) {
+	revision := repo.Revision
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	cacheKey := modelInfoKey(repo, blobs, client.SafeTensorsOnly)
+	if entry := loadMetadata(client, repo, cacheKey, revision); entry != nil && entry.ModelInfo != nil {
+		return entry.ModelInfo, nil
+	}
+
+	infoURL := fmt.Sprintf("%s/api/%s/%s", client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id)
 	if repo.Revision != "" && repo.Revision != "main" {
-		url = fmt.Sprintf("%s/resolve/%s", url, repo.Revision)
+		infoURL = fmt.Sprintf("%s/revision/%s", infoURL, url.PathEscape(repo.Revision))
 	}
 
-	// fmt.Println("Getting model info from:", url)
+	query := []string{}
+	if client.SafeTensorsOnly {
+		query = append(query, "securityStatus=true")
+	}
+	if blobs {
+		query = append(query, "blobs=true")
+	}
+	if len(query) > 0 {
+		infoURL += "?" + strings.Join(query, "&")
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	// fmt.Println("Getting model info from:", infoURL)
+
+	req, err := http.NewRequest("GET", infoURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("User-Agent", client.UserAgent)
+	req.Header.Set("User-Agent", client.userAgent())
 	if client.Token != "" {
 		req.Header.Set("Authorization", "Bearer "+client.Token)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.httpClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", 
-			resp.StatusCode, resp.Status)
+		return nil, fmt.Errorf("API request failed: %w", newHTTPError(resp))
 	}
 
 	// parse response
@@ -138,31 +413,31 @@ func getModelInfo(client *Client, repo *Repo) (*ModelInfo, error) {
 		return nil, fmt.Errorf("invalid API response: missing commit hash")
 	}
 
+	storeMetadata(client, repo, &metadataEntry{Key: cacheKey, Commit: info.Sha, ModelInfo: &info})
+
 	return &info, nil
 }
 
 
-func findCachedSnapshot(cacheDir string, params *DownloadParams) (string, error) {
-	storageFolder := filepath.Join(cacheDir, repoFolderName(params.Repo.Id, params.Repo.Type))
+func findCachedSnapshot(client *Client, params *DownloadParams) (string, error) {
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(params.Repo.Id, params.Repo.Type))
 
 	// try using the revision as commit hash first
 	if isCommitHash(params.Revision) {
 		snapshotPath := filepath.Join(storageFolder, "snapshots", params.Revision)
-		if _, err := os.Stat(snapshotPath); err == nil {
+		if _, err := client.fs().Stat(snapshotPath); err == nil {
 			return snapshotPath, nil
 		}
 	}
 
 	// try to resolve revision from refs
-	refPath := filepath.Join(storageFolder, "refs", params.Revision)
-	commitBytes, err := os.ReadFile(refPath)
+	commitHash, err := readRef(client.fs(), storageFolder, params.Revision)
 	if err != nil {
-		return "", fmt.Errorf("revision not found in cache: %w", err)
+		return "", err
 	}
 
-	commitHash := string(commitBytes)
 	snapshotPath := filepath.Join(storageFolder, "snapshots", commitHash)
-	if _, err := os.Stat(snapshotPath); err == nil {
+	if _, err := client.fs().Stat(snapshotPath); err == nil {
 		return snapshotPath, nil
 	}
 
@@ -181,3 +456,49 @@ func isCommitHash(s string) bool {
 	}
 	return true
 }
+
+
+// filterUnsafeFiles drops pickle-based files and anything the hub's security
+// scanner flagged as not safe.
+func filterUnsafeFiles(files []string, status *SecurityRepoStatus) []string {
+	flagged := make(map[string]bool)
+	if status != nil {
+		for _, issue := range status.FilesWithIssues {
+			if issue.Level != "safe" {
+				flagged[issue.Path] = true
+			}
+		}
+	}
+
+	var filtered []string
+	for _, file := range files {
+		if isPickleFile(file) || flagged[file] {
+			log.Printf("[Download] Skipping %s (safetensors-only mode)", file)
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered
+}
+
+
+// filterFilesBySize keeps files whose size is within [minSize, maxSize]. A zero
+// bound is ignored. Files missing from the tree are kept.
+func filterFilesBySize(files []string, tree []TreeEntry, minSize int64, maxSize int64) []string {
+	sizes := make(map[string]int64, len(tree))
+	for _, entry := range tree {
+		sizes[entry.Path] = entry.FileSize()
+	}
+
+	var filtered []string
+	for _, file := range files {
+		size, ok := sizes[file]
+		if ok && ((maxSize > 0 && size > maxSize) || (minSize > 0 && size < minSize)) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered
+}
@@ -0,0 +1,285 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// files smaller than this are fetched over one connection
+	sourceChunkMinSize = 64 * 1024 * 1024
+	sourceChunkSize    = 32 * 1024 * 1024
+	// connections per file for Civitai and URL sources, see WithConnections
+	defaultSourceConnections = 4
+)
+
+// chunkState records which chunks of a chunked download are complete, next
+// to the partial file as <tmp>.parts.
+type chunkState struct {
+	Size  int64  `json:"size"`
+	Chunk int64  `json:"chunk"`
+	Done  []bool `json:"done"`
+}
+
+// downloadChunked fetches url over several connections in byte ranges when
+// the server supports them and the file is large enough; sources throttled per
+// connection (Civitai, R2) go faster that way. Otherwise, or when a single
+// stream is already half done, it falls back to downloadWithResume. A partial
+// file left by an earlier run is only continued when it came from the same
+// source, see preparePartial.
+func downloadChunked(url, destPath, tmpPath, apiKey string, connections int, limiter *Limiter, progress *mpb.Progress, progressMu *sync.Mutex, checksum *Checksum) error {
+	statePath := tmpPath + ".parts"
+
+	size, etag, err := probeRangeSize(url, apiKey)
+	if err != nil {
+		size = -1
+	}
+	current := &sourcePartial{URL: url, Size: size, ETag: etag}
+	if checksum != nil {
+		current.Checksum = checksum.String()
+	}
+	if err := preparePartial(tmpPath, current); err != nil {
+		return fmt.Errorf("failed to record source of %s: %w", filepath.Base(destPath), err)
+	}
+
+	state := loadChunkState(statePath)
+	if state == nil {
+		if info, err := os.Stat(tmpPath); err == nil && size > 0 && info.Size() == size {
+			// preallocated for chunks whose state is lost, so which bytes
+			// arrived is unknown; resuming it would ask for bytes past the end
+			log.Printf("[Download] Discarding partial %s without chunk state", filepath.Base(destPath))
+			os.Remove(tmpPath)
+		}
+		if _, err := os.Stat(tmpPath); err == nil || connections <= 1 {
+			return downloadWithResume(url, destPath, tmpPath, apiKey, limiter, progress, progressMu, checksum)
+		}
+	}
+
+	if size < sourceChunkMinSize {
+		if state != nil {
+			// the parts can't be continued as a single stream
+			os.Remove(tmpPath)
+			os.Remove(statePath)
+		}
+		return downloadWithResume(url, destPath, tmpPath, apiKey, limiter, progress, progressMu, checksum)
+	}
+
+	chunkCount := int((size + sourceChunkSize - 1) / sourceChunkSize)
+	if state == nil || state.Size != size || state.Chunk != sourceChunkSize || len(state.Done) != chunkCount {
+		// the remote file changed since the parts were written
+		os.Remove(tmpPath)
+		state = &chunkState{Size: size, Chunk: sourceChunkSize, Done: make([]bool, chunkCount)}
+	}
+	if err := saveChunkState(statePath, state); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Truncate(size); err != nil {
+		return fmt.Errorf("failed to preallocate %s: %w", tmpPath, err)
+	}
+
+	var pending []int
+	var done int64
+	for i, complete := range state.Done {
+		if complete {
+			done += min(int64(i+1)*sourceChunkSize, size) - int64(i)*sourceChunkSize
+		} else {
+			pending = append(pending, i)
+		}
+	}
+
+	progressMu.Lock()
+	bar := &sharedBar{Bar: progress.AddBar(size,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(filepath.Base(destPath), decor.WC{W: 40, C: decor.DidentRight}),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(
+			decor.EwmaETA(decor.ET_STYLE_GO, 90),
+			decor.Name(" ] "),
+			decor.EwmaSpeed(decor.UnitKiB, "% .2f", 60),
+		),
+	)}
+	progressMu.Unlock()
+	bar.SetCurrent(done)
+
+	log.Printf("[Download] Fetching %s in %d chunks over %d connections", filepath.Base(destPath), len(pending), connections)
+
+	chunks := make(chan int, len(pending))
+	for _, i := range pending {
+		chunks <- i
+	}
+	close(chunks)
+
+	var stateMu sync.Mutex
+	var failed atomic.Bool
+	var g errgroup.Group
+	for w := 0; w < connections; w++ {
+		g.Go(func() error {
+			for i := range chunks {
+				// chunks in flight finish so the next attempt has less to fetch
+				if failed.Load() {
+					return nil
+				}
+				r := byteRange{start: int64(i) * sourceChunkSize, end: min(int64(i+1)*sourceChunkSize, size) - 1}
+				if err := fetchSourceRange(context.Background(), url, apiKey, limiter, r, out, bar); err != nil {
+					failed.Store(true)
+					return err
+				}
+
+				stateMu.Lock()
+				state.Done[i] = true
+				err := saveChunkState(statePath, state)
+				stateMu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		// the finished chunks are kept for the next attempt
+		bar.Abort(true)
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	out.Close()
+
+	if checksum != nil {
+		if err := verifyChecksumFile(OSFS{}, checksum, tmpPath, filepath.Base(destPath)); err != nil {
+			discardPartial(tmpPath)
+			return backoff.Permanent(err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	os.Remove(statePath)
+	os.Remove(tmpPath + ".source")
+	return nil
+}
+
+// probeRangeSize returns the size of the file at url, or -1 when the server
+// doesn't answer range requests, and its ETag when one is sent.
+func probeRangeSize(url, apiKey string) (int64, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return -1, "", err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := defaultHTTPClient.Do(req)
+	if err != nil {
+		return -1, "", err
+	}
+	resp.Body.Close()
+
+	etag := normalizeETag(resp.Header.Get("ETag"))
+	if resp.StatusCode != http.StatusPartialContent {
+		return -1, etag, nil
+	}
+	// Content-Range: bytes 0-0/12345
+	contentRange := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1, etag, nil
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1, etag, nil
+	}
+	return size, etag, nil
+}
+
+// fetchSourceRange is fetchRange for sources outside the hub. Each request
+// goes to the original URL, so signed redirect targets that expire during a
+// long download are issued again.
+func fetchSourceRange(ctx context.Context, url, apiKey string, limiter *Limiter, r byteRange, out io.WriterAt, bar *sharedBar) error {
+	release, err := limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.start, r.end))
+
+	resp, err := defaultHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request failed: %w", newHTTPError(resp))
+	}
+
+	length := r.end - r.start + 1
+	written, err := io.Copy(io.NewOffsetWriter(out, r.start), bar.proxyReader(io.LimitReader(resp.Body, length)))
+	if err == nil && written != length {
+		err = fmt.Errorf("short range: got %d of %d bytes", written, length)
+	}
+	if err != nil {
+		// the chunk is fetched again from scratch
+		bar.IncrInt64(-written)
+		return err
+	}
+	return nil
+}
+
+func loadChunkState(path string) *chunkState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil || state.Chunk <= 0 {
+		return nil
+	}
+	return &state
+}
+
+func saveChunkState(path string, state *chunkState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
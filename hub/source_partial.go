@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// sourcePartial identifies the file a partial source download was fetched
+// from, recorded next to it as <tmp>.source. A restart only resumes the
+// partial when the source still matches, so a changed URL or a replaced
+// remote file starts over instead of appending the wrong bytes.
+type sourcePartial struct {
+	URL      string `json:"url"`
+	Size     int64  `json:"size"`
+	ETag     string `json:"etag,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// matches reports whether a partial recorded as p can be resumed from
+// current. Size and ETag are only compared when both sides know them, since a
+// probe can fail or a server may not send an ETag.
+func (p *sourcePartial) matches(current *sourcePartial) bool {
+	if p.URL != current.URL || p.Checksum != current.Checksum {
+		return false
+	}
+	if p.Size >= 0 && current.Size >= 0 && p.Size != current.Size {
+		return false
+	}
+	if p.ETag != "" && current.ETag != "" && p.ETag != current.ETag {
+		return false
+	}
+	return true
+}
+
+// preparePartial discards the partial download at tmpPath, and its chunk
+// state, unless its manifest shows it came from the same source, then records
+// current for the next restart.
+func preparePartial(tmpPath string, current *sourcePartial) error {
+	manifestPath := tmpPath + ".source"
+
+	if info, err := os.Stat(tmpPath); err == nil {
+		recorded := loadSourcePartial(manifestPath)
+		switch {
+		case recorded == nil:
+			// written before manifests, or the manifest was lost
+			log.Printf("[Download] Discarding partial %s: no record of its source", filepath.Base(tmpPath))
+			discardPartial(tmpPath)
+		case !recorded.matches(current):
+			log.Printf("[Download] Discarding partial %s: source changed from %s (%d bytes) to %s (%d bytes)", filepath.Base(tmpPath), recorded.URL, recorded.Size, current.URL, current.Size)
+			discardPartial(tmpPath)
+		case current.Size >= 0 && info.Size() > current.Size:
+			log.Printf("[Download] Discarding partial %s: %d bytes, remote file has %d", filepath.Base(tmpPath), info.Size(), current.Size)
+			discardPartial(tmpPath)
+		default:
+			// keep what the earlier probe learned when this one couldn't tell
+			if current.Size < 0 {
+				current.Size = recorded.Size
+			}
+			if current.ETag == "" {
+				current.ETag = recorded.ETag
+			}
+		}
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath, data)
+}
+
+// discardPartial removes a partial download with its chunk state and manifest.
+func discardPartial(tmpPath string) {
+	os.Remove(tmpPath)
+	os.Remove(tmpPath + ".parts")
+	os.Remove(tmpPath + ".source")
+}
+
+func loadSourcePartial(path string) *sourcePartial {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var partial sourcePartial
+	if err := json.Unmarshal(data, &partial); err != nil || partial.URL == "" {
+		return nil
+	}
+	return &partial
+}
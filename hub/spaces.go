@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"fmt"
+	"time"
+)
+
+// Space stages reported in SpaceRuntime.Stage.
+const (
+	SpaceNoAppFile    = "NO_APP_FILE"
+	SpaceConfigError  = "CONFIG_ERROR"
+	SpaceBuilding     = "BUILDING"
+	SpaceBuildError   = "BUILD_ERROR"
+	SpaceRunning      = "RUNNING"
+	SpaceRunningBuild = "RUNNING_BUILDING"
+	SpaceRuntimeError = "RUNTIME_ERROR"
+	SpaceDeleting     = "DELETING"
+	SpacePaused       = "PAUSED"
+	SpaceSleeping     = "SLEEPING"
+	SpaceStopped      = "STOPPED"
+)
+
+// SpaceInfo is a Space's metadata, including its SDK and runtime.
+type SpaceInfo struct {
+	Id           string         `json:"id"`
+	Author       string         `json:"author,omitempty"`
+	Sha          string         `json:"sha"`
+	Private      bool           `json:"private"`
+	Disabled     bool           `json:"disabled,omitempty"`
+	Likes        int64          `json:"likes"`
+	Tags         []string       `json:"tags,omitempty"`
+	SDK          string         `json:"sdk,omitempty"` // e.g. "gradio", "streamlit", "docker", "static"
+	Subdomain    string         `json:"subdomain,omitempty"`
+	Host         string         `json:"host,omitempty"` // where the running app is served
+	CreatedAt    time.Time      `json:"createdAt"`
+	LastModified time.Time      `json:"lastModified"`
+	CardData     map[string]any `json:"cardData,omitempty"`
+	Siblings     []ModelSibling `json:"siblings"`
+	Runtime      *SpaceRuntime  `json:"runtime,omitempty"`
+}
+
+// SpaceRuntime is the state of a Space's container.
+type SpaceRuntime struct {
+	Stage        string        `json:"stage"`
+	Hardware     SpaceHardware `json:"hardware"`
+	Storage      string        `json:"storage,omitempty"`   // persistent storage tier, if any
+	SleepTime    int           `json:"gcTimeout,omitempty"` // seconds of inactivity before the Space sleeps
+	ErrorMessage string        `json:"errorMessage,omitempty"`
+}
+
+// SpaceHardware is the hardware a Space runs on, e.g. "cpu-basic" or
+// "t4-small". Requested differs from Current while an upgrade is pending.
+type SpaceHardware struct {
+	Current   string `json:"current"`
+	Requested string `json:"requested"`
+}
+
+// SpaceVariable is a public environment variable of a Space.
+type SpaceVariable struct {
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SpaceInfo fetches a Space's metadata and runtime.
+func (client *Client) SpaceInfo(id string) (*SpaceInfo, error) {
+	var info SpaceInfo
+	if err := apiRequest(client, "GET", spaceAPIURL(client, id, ""), nil, &info); err != nil {
+		return nil, fmt.Errorf("failed to get space info: %w", err)
+	}
+	return &info, nil
+}
+
+// SpaceRuntime fetches only the runtime of a Space, e.g. to poll its stage
+// after a restart.
+func (client *Client) SpaceRuntime(id string) (*SpaceRuntime, error) {
+	var runtime SpaceRuntime
+	if err := apiRequest(client, "GET", spaceAPIURL(client, id, "/runtime"), nil, &runtime); err != nil {
+		return nil, fmt.Errorf("failed to get space runtime: %w", err)
+	}
+	return &runtime, nil
+}
+
+// SpaceVariables lists a Space's variables by key. Secrets can't be read
+// back.
+func (client *Client) SpaceVariables(id string) (map[string]SpaceVariable, error) {
+	variables := make(map[string]SpaceVariable)
+	if err := apiRequest(client, "GET", spaceAPIURL(client, id, "/variables"), nil, &variables); err != nil {
+		return nil, fmt.Errorf("failed to list space variables: %w", err)
+	}
+	return variables, nil
+}
+
+// AddSpaceVariable creates or updates a Space variable. The Space restarts to
+// pick it up.
+func (client *Client) AddSpaceVariable(id string, key string, value string, description string) error {
+	body := spaceSetting{Key: key, Value: value, Description: description}
+	if err := apiRequest(client, "POST", spaceAPIURL(client, id, "/variables"), body, nil); err != nil {
+		return fmt.Errorf("failed to set space variable %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteSpaceVariable removes a Space variable.
+func (client *Client) DeleteSpaceVariable(id string, key string) error {
+	if err := apiRequest(client, "DELETE", spaceAPIURL(client, id, "/variables"), spaceSetting{Key: key}, nil); err != nil {
+		return fmt.Errorf("failed to delete space variable %s: %w", key, err)
+	}
+	return nil
+}
+
+// AddSpaceSecret creates or updates a Space secret. Its value is write-only.
+func (client *Client) AddSpaceSecret(id string, key string, value string, description string) error {
+	body := spaceSetting{Key: key, Value: value, Description: description}
+	if err := apiRequest(client, "POST", spaceAPIURL(client, id, "/secrets"), body, nil); err != nil {
+		return fmt.Errorf("failed to set space secret %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteSpaceSecret removes a Space secret.
+func (client *Client) DeleteSpaceSecret(id string, key string) error {
+	if err := apiRequest(client, "DELETE", spaceAPIURL(client, id, "/secrets"), spaceSetting{Key: key}, nil); err != nil {
+		return fmt.Errorf("failed to delete space secret %s: %w", key, err)
+	}
+	return nil
+}
+
+type spaceSetting struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func spaceAPIURL(client *Client, id string, suffix string) string {
+	return fmt.Sprintf("%s/api/%s/%s%s", client.Endpoint, repoTypeAPIPath(SpaceRepoType), id, suffix)
+}
@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultSpeedWindow = 60 * time.Second
+
+// reconnects allowed per file when a connection falls below the speed floor
+const maxSlowReconnects = 5
+
+var ErrDownloadTooSlow = errors.New("download too slow")
+
+// speedMonitor measures the average speed over consecutive windows and fails
+// once a full window is below the floor. CDN connections sometimes degrade to
+// a trickle without stalling, and a fresh connection is usually much faster.
+type speedMonitor struct {
+	minSpeed    int64
+	window      time.Duration
+	windowStart time.Time
+	windowBytes int64
+}
+
+func newSpeedMonitor(client *Client) *speedMonitor {
+	if client.MinDownloadSpeed <= 0 {
+		return nil
+	}
+
+	window := client.SpeedWindow
+	if window <= 0 {
+		window = defaultSpeedWindow
+	}
+
+	return &speedMonitor{
+		minSpeed:    client.MinDownloadSpeed,
+		window:      window,
+		windowStart: time.Now(),
+	}
+}
+
+// add records n downloaded bytes. A nil monitor never fails.
+func (m *speedMonitor) add(n int) error {
+	if m == nil {
+		return nil
+	}
+
+	m.windowBytes += int64(n)
+
+	elapsed := time.Since(m.windowStart)
+	if elapsed < m.window {
+		return nil
+	}
+
+	speed := int64(float64(m.windowBytes) / elapsed.Seconds())
+	if speed < m.minSpeed {
+		return fmt.Errorf("%w: %d B/s over %s, below %d B/s", ErrDownloadTooSlow, speed, elapsed.Round(time.Second), m.minSpeed)
+	}
+
+	m.windowStart = time.Now()
+	m.windowBytes = 0
+	return nil
+}
@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+)
+
+// Stream writes a repo file straight to w without storing it in the cache,
+// e.g. to read a config into memory or proxy a file to an HTTP response.
+// Failures before the first byte are retried; once data has been written to
+// w the download can't be restarted, so the error is returned instead. In
+// offline mode the file is streamed from the cache when present.
+func (client *Client) Stream(repo *Repo, fileName string, revision string, w io.Writer) (int64, error) {
+	if revision == "" {
+		revision = repo.Revision
+	}
+	if revision == "" {
+		revision = DefaultRevision
+	}
+
+	repoType := repo.Type
+	if repoType == "" {
+		repoType = ModelRepoType
+	}
+
+	if err := checkConnectivity(client, false); err != nil {
+		cachedPath, cacheErr := findInCache(client, repo.Id, repoType, fileName, revision)
+		if cacheErr != nil {
+			return 0, fmt.Errorf("file not found in cache and downloads are disabled: %w", cacheErr)
+		}
+		f, err := client.fs().OpenFile(cachedPath, os.O_RDONLY, 0)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		return io.Copy(w, f)
+	}
+
+	fileURL := hubFileURL(client.Endpoint, repo, "resolve", revision, fileName)
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 2 * time.Minute
+	b.InitialInterval = 1 * time.Second
+	b.MaxInterval = 30 * time.Second
+
+	var written int64
+	err := backoff.Retry(func() error {
+		n, err := streamOnce(client, fileURL, fileName, w)
+		written += n
+		if err != nil && written > 0 {
+			return backoff.Permanent(fmt.Errorf("stream interrupted after %d bytes: %w", written, err))
+		}
+		return err
+	}, b)
+
+	return written, err
+}
+
+func streamOnce(client *Client, fileURL string, fileName string, w io.Writer) (int64, error) {
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return 0, backoff.Permanent(err)
+	}
+	req.Header = *resolveHeaders(client)
+
+	resp, err := client.downloadHTTPClient(0).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return 0, newHTTPError(resp)
+	default:
+		return 0, backoff.Permanent(newHTTPError(resp))
+	}
+
+	if err := decodeBody(resp); err != nil {
+		return 0, backoff.Permanent(err)
+	}
+
+	description := fmt.Sprintf("Streaming %s", fileName)
+	bar := client.addBar(fileName,
+		resp.ContentLength,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(description+": ", decor.WC{W: len(description) + 2, C: decor.DidentRight}),
+			decor.Percentage(decor.WCSyncSpace),
+		),
+		mpb.AppendDecorators(
+			decor.CountersKibiByte("%.2f / %.2f"),
+			decor.EwmaSpeed(decor.UnitKiB, "%.2f", 60),
+		),
+	)
+
+	reader := bar.ProxyReader(resp.Body)
+	defer reader.Close()
+
+	n, err := io.CopyBuffer(w, reader, client.newCopyBuffer().bytes())
+	if err != nil {
+		bar.Abort(true)
+		return n, err
+	}
+
+	bar.SetTotal(n, true)
+	return n, nil
+}
@@ -0,0 +1,173 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DownloadSummary reports what a download did.
+type DownloadSummary struct {
+	mu sync.Mutex
+
+	// Files is the number of files requested; each was either downloaded or a cache hit.
+	Files      int
+	Downloaded int
+	CacheHits  int
+	// Bytes counts bytes transferred, not including cache hits, which
+	// CachedBytes counts instead.
+	Bytes       int64
+	CachedBytes int64
+	Retries int
+	Elapsed time.Duration
+	// Requests counts the hub and CDN requests made, including metadata
+	// checks of cached files; 0 means the call never touched the network.
+	Requests int
+	// Source served the call when the client has a ResolutionOrder.
+	Source Source
+}
+
+// DownloadWithSummary is Download, also reporting files downloaded versus
+// found in the cache, bytes transferred, retries and elapsed time.
+func (client *Client) DownloadWithSummary(params *DownloadParams) (string, *DownloadSummary, error) {
+	summary := &DownloadSummary{}
+
+	paramsCopy := *params
+	paramsCopy.summary = summary
+
+	// count requests below the auth and tracing layers, once per request
+	base := client.HTTPClient
+	if base == nil {
+		base = defaultHTTPClient
+	}
+	counted := *base
+	counted.Transport = &countingTransport{base: base.Transport, summary: summary}
+	client = client.clone()
+	client.HTTPClient = &counted
+
+	started := time.Now()
+	path, err := client.Download(&paramsCopy)
+	summary.Elapsed = time.Since(started)
+
+	return path, summary, err
+}
+
+// AverageSpeed is the transfer rate in bytes per second.
+func (s *DownloadSummary) AverageSpeed() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// Add merges another summary into s, e.g. for the several downloads of a pipeline.
+func (s *DownloadSummary) Add(other *DownloadSummary) {
+	if other == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Files += other.Files
+	s.Downloaded += other.Downloaded
+	s.CacheHits += other.CacheHits
+	s.Bytes += other.Bytes
+	s.CachedBytes += other.CachedBytes
+	s.Retries += other.Retries
+	s.Elapsed += other.Elapsed
+	s.Requests += other.Requests
+	if s.Source == "" {
+		s.Source = other.Source
+	}
+}
+
+// UsedNetwork reports whether the download made any request, as opposed to
+// being served from the cache alone.
+func (s *DownloadSummary) UsedNetwork() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Requests > 0
+}
+
+func (s *DownloadSummary) String() string {
+	return fmt.Sprintf("%d files (%d downloaded, %d cached), %s in %s (%s/s), %d retries",
+		s.Files, s.Downloaded, s.CacheHits,
+		formatBytes(s.Bytes), s.Elapsed.Round(time.Millisecond),
+		formatBytes(int64(s.AverageSpeed())), s.Retries)
+}
+
+// Progress describes the work done so far, e.g. "12 files reused from cache,
+// 3 downloaded (4.2 GB)", for progress bars and logs.
+func (s *DownloadSummary) Progress() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("%d files reused from cache, %d downloaded (%s)", s.CacheHits, s.Downloaded, formatBytes(s.Bytes))
+}
+
+// the record* helpers are no-ops on a nil summary, so call sites don't need to check
+
+func (s *DownloadSummary) recordDownload(bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files++
+	s.Downloaded++
+	s.Bytes += bytes
+}
+
+func (s *DownloadSummary) recordCacheHit(bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files++
+	s.CacheHits++
+	s.CachedBytes += bytes
+}
+
+func (s *DownloadSummary) recordSource(source Source) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Source = source
+}
+
+func (s *DownloadSummary) recordRetry() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Retries++
+}
+
+func (s *DownloadSummary) recordRequest() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Requests++
+}
+
+// countingTransport records each request in a summary.
+type countingTransport struct {
+	base    http.RoundTripper
+	summary *DownloadSummary
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.summary.recordRequest()
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,42 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"runtime"
+	"strings"
+)
+
+// sessionID identifies this process in the User-Agent, like the python
+// package's per-process session id, so the hub can tell a run's requests
+// apart from another's.
+var sessionID = newSessionID()
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// userAgent is the User-Agent sent to the hub: the library built on this
+// package, if set, then UserAgent, then, unless telemetry is disabled, the Go
+// version and session id the hub uses for its download statistics.
+func (client *Client) userAgent() string {
+	var parts []string
+	if client.LibraryName != "" {
+		version := client.LibraryVersion
+		if version == "" {
+			version = "unknown"
+		}
+		parts = append(parts, client.LibraryName+"/"+version)
+	}
+	if client.UserAgent != "" {
+		parts = append(parts, client.UserAgent)
+	}
+	if !client.DisableTelemetry {
+		parts = append(parts, "go/"+strings.TrimPrefix(runtime.Version(), "go"), "session_id/"+sessionID)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDownloadTimeout is returned when a download received no data for
+// Client.DownloadTimeout.
+var ErrDownloadTimeout = errors.New("download timed out")
+
+// readWatchdog cancels a download that goes Client.DownloadTimeout without
+// connecting or receiving data, the read timeout of python's
+// HF_HUB_DOWNLOAD_TIMEOUT. A nil watchdog never fires.
+type readWatchdog struct {
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelCauseFunc
+}
+
+func (client *Client) watchReads(ctx context.Context) (context.Context, *readWatchdog) {
+	if client.DownloadTimeout <= 0 {
+		return ctx, nil
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &readWatchdog{timeout: client.DownloadTimeout, cancel: cancel}
+	w.timer = time.AfterFunc(w.timeout, func() {
+		cancel(fmt.Errorf("%w: no data for %s", ErrDownloadTimeout, w.timeout))
+	})
+	return ctx, w
+}
+
+// touch restarts the timeout after data arrived.
+func (w *readWatchdog) touch() {
+	if w != nil {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+func (w *readWatchdog) stop() {
+	if w != nil {
+		w.timer.Stop()
+		w.cancel(nil)
+	}
+}
+
+// wrap reports err as a timeout when the watchdog cut the download off.
+func (w *readWatchdog) wrap(ctx context.Context, err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrDownloadTimeout) {
+		return cause
+	}
+	return err
+}
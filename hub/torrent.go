@@ -0,0 +1,501 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/decor"
+)
+
+// ErrNoWebSeeds is returned for torrents without web seeds; pieces are only
+// fetched over HTTP, the BitTorrent peer protocol isn't spoken.
+var ErrNoWebSeeds = errors.New("torrent has no web seeds")
+
+// TorrentOptions configure a TorrentSource. Either SHA256 or ManifestURL must
+// be set, the finished file is checked against it.
+type TorrentOptions struct {
+	// FileName picks the file of a multi-file torrent by its path inside it
+	FileName string
+	// SHA256 of the file, or a sha256sum-style manifest listing it
+	SHA256      string
+	ManifestURL string
+	// WebSeeds are tried alongside the torrent's url-list, e.g. cluster
+	// nodes that already hold the file
+	WebSeeds []string
+	// Workers fetching pieces in parallel, 4 per web seed when 0
+	Workers int
+}
+
+// TorrentSource downloads one file of a torrent from its web seeds (BEP 19).
+// Pieces are spread over all seeds and checked against the torrent's piece
+// hashes as they arrive, so a bad mirror is caught early and an interrupted
+// download resumes from the pieces already on disk.
+type TorrentSource struct {
+	torrent string
+	opts    TorrentOptions
+	client  *http.Client
+	limiter *Limiter
+
+	progressMu sync.Mutex
+	meta       *torrentMeta
+}
+
+type torrentMeta struct {
+	name        string
+	pieceLength int64
+	pieces      []string
+	files       []torrentFile
+	multiFile   bool
+	webSeeds    []string
+}
+
+type torrentFile struct {
+	path   string
+	length int64
+	offset int64
+}
+
+// NewTorrentSource reads the .torrent from a URL or a local path.
+func NewTorrentSource(torrent string, opts TorrentOptions) *TorrentSource {
+	return &TorrentSource{torrent: torrent, opts: opts, client: defaultHTTPClient}
+}
+
+// WithLimiter makes each piece request wait for a slot of l, usually the
+// Client.Limiter shared with hub downloads.
+func (s *TorrentSource) WithLimiter(l *Limiter) *TorrentSource {
+	s.limiter = l
+	return s
+}
+
+func (s *TorrentSource) GetFileInfo() (*FileInfo, error) {
+	meta, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	file, err := meta.file(s.opts.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{Size: file.length, Filename: path.Base(file.path)}
+	if seeds := s.seedURLs(meta, file); len(seeds) > 0 {
+		info.URL = seeds[0]
+	}
+	return info, nil
+}
+
+func (s *TorrentSource) Download(destPath string, progress *mpb.Progress) error {
+	meta, err := s.load()
+	if err != nil {
+		return err
+	}
+	file, err := meta.file(s.opts.FileName)
+	if err != nil {
+		return err
+	}
+
+	expected, err := s.expectedSHA256(file)
+	if err != nil {
+		return err
+	}
+
+	seeds := s.seedURLs(meta, file)
+	if len(seeds) == 0 {
+		return ErrNoWebSeeds
+	}
+
+	tmpPath := destPath + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Truncate(file.length); err != nil {
+		return err
+	}
+
+	pieces := meta.piecesOf(file)
+	var todo []torrentPiece
+	var resumed int64
+	for _, piece := range pieces {
+		if piece.verifiable && piece.check(out) {
+			resumed += piece.size
+			continue
+		}
+		todo = append(todo, piece)
+	}
+	if resumed > 0 {
+		log.Printf("[Download] Resuming %s with %d of %d pieces on disk", path.Base(file.path), len(pieces)-len(todo), len(pieces))
+	}
+
+	s.progressMu.Lock()
+	bar := progress.AddBar(file.length,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name(path.Base(file.path), decor.WC{W: 40, C: decor.DidentRight}),
+			decor.CountersKibiByte("% .2f / % .2f"),
+		),
+		mpb.AppendDecorators(
+			decor.EwmaETA(decor.ET_STYLE_GO, 90),
+			decor.Name(" ] "),
+			decor.EwmaSpeed(decor.UnitKiB, "% .2f", 60),
+		),
+	)
+	s.progressMu.Unlock()
+	bar.SetCurrent(resumed)
+
+	workers := s.opts.Workers
+	if workers <= 0 {
+		workers = 4 * len(seeds)
+	}
+
+	queue := make(chan torrentPiece)
+	errs := make(chan error, len(todo))
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(todo)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for piece := range queue {
+				if err := s.fetchPiece(seeds, piece, out); err != nil {
+					errs <- err
+					continue
+				}
+				bar.IncrInt64(piece.size)
+			}
+		}()
+	}
+	for _, piece := range todo {
+		queue <- piece
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		bar.Abort(true)
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	out.Close()
+
+	sum, err := sha256File(OSFS{}, tmpPath)
+	if err != nil {
+		return err
+	}
+	if sum != expected {
+		os.Remove(tmpPath)
+		return fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", file.path, expected, sum)
+	}
+
+	return os.Rename(tmpPath, destPath)
+}
+
+// torrentPiece is the part of a piece inside the downloaded file, at offset
+// in the file. Pieces that straddle two files can't be hashed from one file
+// and are only covered by the final sha256.
+type torrentPiece struct {
+	index      int
+	offset     int64
+	size       int64
+	hash       string
+	verifiable bool
+}
+
+func (p torrentPiece) check(r io.ReaderAt) bool {
+	buf := make([]byte, p.size)
+	if _, err := r.ReadAt(buf, p.offset); err != nil {
+		return false
+	}
+	sum := sha1.Sum(buf)
+	return string(sum[:]) == p.hash
+}
+
+func (meta *torrentMeta) piecesOf(file *torrentFile) []torrentPiece {
+	if file.length == 0 {
+		return nil
+	}
+
+	first := file.offset / meta.pieceLength
+	last := (file.offset + file.length - 1) / meta.pieceLength
+
+	lastFile := meta.files[len(meta.files)-1]
+	total := lastFile.offset + lastFile.length
+
+	var pieces []torrentPiece
+	for i := first; i <= last && int(i) < len(meta.pieces); i++ {
+		start := i * meta.pieceLength
+		// the last piece of the torrent is shorter than pieceLength
+		end := min(start+meta.pieceLength, total)
+		inFileStart := max(start, file.offset)
+		inFileEnd := min(end, file.offset+file.length)
+
+		pieces = append(pieces, torrentPiece{
+			index:      int(i),
+			offset:     inFileStart - file.offset,
+			size:       inFileEnd - inFileStart,
+			hash:       meta.pieces[i],
+			verifiable: start >= file.offset && end <= file.offset+file.length,
+		})
+	}
+	return pieces
+}
+
+// fetchPiece downloads the piece with a range request, starting at a seed
+// chosen by the piece index so the load spreads, and moving on to the next
+// seed when one fails or sends bad data.
+func (s *TorrentSource) fetchPiece(seeds []string, piece torrentPiece, out io.WriterAt) error {
+	var lastErr error
+	for attempt := 0; attempt < 2*len(seeds); attempt++ {
+		seed := seeds[(piece.index+attempt)%len(seeds)]
+
+		data, err := s.fetchRange(seed, piece.offset, piece.size)
+		if err == nil && piece.verifiable {
+			if sum := sha1.Sum(data); string(sum[:]) != piece.hash {
+				err = fmt.Errorf("piece %d from %s failed its hash check", piece.index, redactURLString(seed))
+			}
+		}
+		if err != nil {
+			lastErr = err
+			log.Printf("[Download] %v", err)
+			continue
+		}
+
+		_, err = out.WriteAt(data, piece.offset)
+		return err
+	}
+	return fmt.Errorf("failed to fetch piece %d: %w", piece.index, lastErr)
+}
+
+func (s *TorrentSource) fetchRange(seed string, offset int64, size int64) ([]byte, error) {
+	release, err := s.limiter.acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, err := http.NewRequest("GET", seed, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request to %s: %w", redactURLString(seed), newHTTPError(resp))
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("short read from %s: %w", redactURLString(seed), err)
+	}
+	return data, nil
+}
+
+// seedURLs returns the file's URL on every web seed. Per BEP 19 a seed ending
+// in a slash is a directory holding the torrent's name; multi-file torrents
+// always are.
+func (s *TorrentSource) seedURLs(meta *torrentMeta, file *torrentFile) []string {
+	var urls []string
+	for _, seed := range append(append([]string(nil), s.opts.WebSeeds...), meta.webSeeds...) {
+		if seed == "" {
+			continue
+		}
+		if !meta.multiFile && !strings.HasSuffix(seed, "/") {
+			urls = append(urls, seed)
+			continue
+		}
+
+		seed = strings.TrimSuffix(seed, "/")
+		parts := []string{url.PathEscape(meta.name)}
+		if meta.multiFile {
+			for _, part := range strings.Split(file.path, "/") {
+				parts = append(parts, url.PathEscape(part))
+			}
+		}
+		urls = append(urls, seed+"/"+strings.Join(parts, "/"))
+	}
+	return urls
+}
+
+func (s *TorrentSource) expectedSHA256(file *torrentFile) (string, error) {
+	if s.opts.SHA256 != "" {
+		return strings.ToLower(s.opts.SHA256), nil
+	}
+	if s.opts.ManifestURL == "" {
+		return "", fmt.Errorf("refusing to download %s without a sha256 or manifest", file.path)
+	}
+
+	data, err := s.read(s.opts.ManifestURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	// "<sha256>  <path>" lines as written by sha256sum
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		if name == file.path || name == path.Base(file.path) {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not listed in the manifest", file.path)
+}
+
+func (s *TorrentSource) load() (*torrentMeta, error) {
+	if s.meta != nil {
+		return s.meta, nil
+	}
+
+	data, err := s.read(s.torrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent: %w", err)
+	}
+	meta, err := parseTorrent(data)
+	if err != nil {
+		return nil, err
+	}
+	s.meta = meta
+	return meta, nil
+}
+
+// read fetches a URL, or reads a local file for anything else.
+func (s *TorrentSource) read(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.ReadFile(location)
+	}
+
+	resp, err := s.client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+func (meta *torrentMeta) file(name string) (*torrentFile, error) {
+	if !meta.multiFile {
+		return &meta.files[0], nil
+	}
+	if name == "" {
+		if len(meta.files) == 1 {
+			return &meta.files[0], nil
+		}
+		return nil, fmt.Errorf("torrent %s has %d files, set FileName", meta.name, len(meta.files))
+	}
+	for i := range meta.files {
+		if meta.files[i].path == name {
+			return &meta.files[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in torrent %s", name, meta.name)
+}
+
+func parseTorrent(data []byte) (*torrentMeta, error) {
+	value, err := decodeBencode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse torrent: %w", err)
+	}
+	root, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse torrent: not a dictionary")
+	}
+	info, ok := root["info"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse torrent: missing info")
+	}
+
+	meta := &torrentMeta{}
+	meta.name, _ = info["name"].(string)
+	meta.pieceLength, _ = info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if meta.name == "" || meta.pieceLength <= 0 || len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("failed to parse torrent: invalid info")
+	}
+	for i := 0; i < len(pieces); i += sha1.Size {
+		meta.pieces = append(meta.pieces, pieces[i:i+sha1.Size])
+	}
+
+	// names come from the torrent and end up in paths
+	if !validRelativePath(meta.name) {
+		return nil, fmt.Errorf("invalid torrent name %q", meta.name)
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		meta.files = []torrentFile{{path: meta.name, length: length}}
+	} else {
+		files, _ := info["files"].([]any)
+		var offset int64
+		for _, f := range files {
+			entry, _ := f.(map[string]any)
+			length, _ := entry["length"].(int64)
+			parts, _ := entry["path"].([]any)
+			var names []string
+			for _, p := range parts {
+				if name, ok := p.(string); ok {
+					names = append(names, name)
+				}
+			}
+			filePath := strings.Join(names, "/")
+			if len(names) == 0 || !validRelativePath(filePath) {
+				return nil, fmt.Errorf("invalid file path %q in torrent", filePath)
+			}
+			meta.files = append(meta.files, torrentFile{path: filePath, length: length, offset: offset})
+			offset += length
+		}
+		if len(meta.files) == 0 {
+			return nil, fmt.Errorf("failed to parse torrent: no files")
+		}
+		meta.multiFile = true
+	}
+
+	switch seeds := root["url-list"].(type) {
+	case string:
+		meta.webSeeds = []string{seeds}
+	case []any:
+		for _, seed := range seeds {
+			if u, ok := seed.(string); ok {
+				meta.webSeeds = append(meta.webSeeds, u)
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+func redactURLString(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return redactURL(u)
+}
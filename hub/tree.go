@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+type TreeEntry struct {
+	Type string   `json:"type"`
+	Oid  string   `json:"oid"`
+	Size int64    `json:"size"`
+	Path string   `json:"path"`
+	LFS  *LFSInfo `json:"lfs,omitempty"`
+}
+
+type LFSInfo struct {
+	Oid         string `json:"oid"`
+	Size        int64  `json:"size"`
+	PointerSize int64  `json:"pointerSize"`
+}
+
+// FileSize returns the size of the actual file content, which for LFS files
+// is the size of the LFS object rather than the pointer.
+func (e *TreeEntry) FileSize() int64 {
+	if e.LFS != nil {
+		return e.LFS.Size
+	}
+	return e.Size
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// listRepoTree returns every file in the repo at the given revision, following
+// the tree API's pagination.
+func listRepoTree(client *Client, repo *Repo, revision string) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	err := walkRepoTree(client, repo, revision, func(entry TreeEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// walkRepoTree calls visit for every file in the repo at the given revision
+// until it returns false.
+func walkRepoTree(client *Client, repo *Repo, revision string, visit func(TreeEntry) bool) error {
+	if revision == "" {
+		revision = DefaultRevision
+	}
+
+	treeURL := fmt.Sprintf("%s/api/%s/%s/tree/%s?recursive=true&expand=false",
+		client.Endpoint,
+		repoTypeAPIPath(repo.Type),
+		repo.Id,
+		url.PathEscape(revision),
+	)
+
+	err := apiPages(client, treeURL, func(entry TreeEntry) bool {
+		if entry.Type != "file" {
+			return true
+		}
+		return visit(entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list repo tree: %w", err)
+	}
+	return nil
+}
+
+// treeEntryETag is the blob name of a listed file: the sha256 of LFS files,
+// the git oid of the others.
+func treeEntryETag(entry TreeEntry) string {
+	if entry.LFS != nil {
+		return entry.LFS.Oid
+	}
+	return entry.Oid
+}
+
+func repoTypeAPIPath(repoType string) string {
+	switch repoType {
+	case DatasetRepoType:
+		return "datasets"
+	case SpaceRepoType:
+		return "spaces"
+	}
+	return "models"
+}
+
+// metadataFromTree resolves download metadata for every file in a tree listing,
+// so snapshot downloads don't need a HEAD request per file. LFS files are keyed
+// by their sha256 like the hub's X-Linked-Etag, other files by their git oid.
+func metadataFromTree(client *Client, repo *Repo, commitHash string, tree []TreeEntry) map[string]*FileMetadata {
+	metadata := make(map[string]*FileMetadata, len(tree))
+	for _, entry := range tree {
+		etag := treeEntryETag(entry)
+		if etag == "" {
+			continue
+		}
+
+		metadata[entry.Path] = &FileMetadata{
+			CommitHash: commitHash,
+			ETag:       etag,
+			// the client follows the redirect to the CDN when downloading
+			Location: hubFileURL(client.Endpoint, repo, "resolve", commitHash, entry.Path),
+			Size:     int(entry.FileSize()),
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,155 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	minBufferSize     = 16 * 1024
+	maxBufferSize     = 4 * 1024 * 1024
+	defaultBufferSize = 64 * 1024
+
+	// buffers are sized to hold about this much transfer time
+	bufferTarget = 10 * time.Millisecond
+	// throughput is re-measured over windows of this length
+	tuneWindow = time.Second
+)
+
+// throughputMeter keeps a moving average of download speed across all
+// downloads, so new downloads start with sizes that fit the link.
+type throughputMeter struct {
+	mu   sync.Mutex
+	ewma float64
+}
+
+var measuredThroughput = &throughputMeter{}
+
+func (m *throughputMeter) observe(bytesPerSecond float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ewma == 0 {
+		m.ewma = bytesPerSecond
+		return
+	}
+	m.ewma = 0.8*m.ewma + 0.2*bytesPerSecond
+}
+
+// estimate returns bytes per second, or 0 before anything was measured.
+func (m *throughputMeter) estimate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ewma
+}
+
+// bufferSizeFor picks a power-of-two buffer holding ~10ms of data: 16KB on
+// slow or metered links, up to 4MB on 10Gbit ones.
+func bufferSizeFor(bytesPerSecond float64) int {
+	if bytesPerSecond <= 0 {
+		return defaultBufferSize
+	}
+
+	target := int(bytesPerSecond * bufferTarget.Seconds())
+	size := minBufferSize
+	for size < target && size < maxBufferSize {
+		size *= 2
+	}
+	return size
+}
+
+// copyBuffer is a read buffer that grows or shrinks with the measured speed.
+type copyBuffer struct {
+	buf         []byte
+	fixed       bool
+	windowStart time.Time
+	windowBytes int64
+}
+
+// newCopyBuffer returns a buffer of the client's BufferSize, or an adaptive
+// one when it is not set.
+func (client *Client) newCopyBuffer() *copyBuffer {
+	if client != nil && client.BufferSize > 0 {
+		return &copyBuffer{buf: make([]byte, client.BufferSize), fixed: true}
+	}
+	return newAdaptiveBuffer()
+}
+
+func newAdaptiveBuffer() *copyBuffer {
+	return &copyBuffer{
+		buf:         make([]byte, bufferSizeFor(measuredThroughput.estimate())),
+		windowStart: time.Now(),
+	}
+}
+
+func (b *copyBuffer) bytes() []byte {
+	return b.buf
+}
+
+// record accounts n read bytes and resizes the buffer once per window when
+// the speed calls for a size at least twice as large or small.
+func (b *copyBuffer) record(n int) {
+	if b.fixed {
+		return
+	}
+
+	b.windowBytes += int64(n)
+	elapsed := time.Since(b.windowStart)
+	if elapsed < tuneWindow {
+		return
+	}
+
+	speed := float64(b.windowBytes) / elapsed.Seconds()
+	measuredThroughput.observe(speed)
+
+	if size := bufferSizeFor(speed); size >= 2*len(b.buf) || 2*size <= len(b.buf) {
+		b.buf = make([]byte, size)
+	}
+
+	b.windowStart = time.Now()
+	b.windowBytes = 0
+}
+
+// rangeWorkers is the number of concurrent range requests per source for
+// multi-source downloads: RangeWorkers when set, otherwise based on the
+// measured speed so metered links aren't flooded with connections.
+func (client *Client) rangeWorkers() int {
+	if client.RangeWorkers > 0 {
+		return client.RangeWorkers
+	}
+
+	const mb = 1024 * 1024
+	switch speed := measuredThroughput.estimate(); {
+	case speed == 0:
+		return 2
+	case speed < 5*mb:
+		return 1
+	case speed < 50*mb:
+		return 2
+	case speed < 200*mb:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// flusher syncs a partial download to disk every Client.FlushInterval, so a
+// crash loses at most that much of it.
+type flusher struct {
+	out      File
+	interval time.Duration
+	last     time.Time
+}
+
+func (client *Client) newFlusher(out File) *flusher {
+	return &flusher{out: out, interval: client.FlushInterval, last: time.Now()}
+}
+
+// written is called after each write and syncs once the interval has passed.
+func (f *flusher) written() error {
+	if f.interval <= 0 || time.Since(f.last) < f.interval {
+		return nil
+	}
+	f.last = time.Now()
+	return f.out.Sync()
+}
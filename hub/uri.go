@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// repoTypePrefixes maps the path prefixes used by the hub's URLs to repo types.
+var repoTypePrefixes = map[string]string{
+	"models":   ModelRepoType,
+	"datasets": DatasetRepoType,
+	"spaces":   SpaceRepoType,
+}
+
+// hubHosts are accepted in https references in addition to the client endpoint.
+var hubHosts = []string{"huggingface.co", "hf.co"}
+
+// ParseRepoURI parses a reference to a repo or a file in it:
+//
+//	org/name, datasets/org/name, spaces/org/name
+//	hf://org/name/path/to/file@revision
+//	hf://datasets/org/name@revision/path/to/file
+//	https://huggingface.co/org/name/blob/revision/path/to/file
+//	https://huggingface.co/datasets/org/name/resolve/revision/path/to/file
+//	https://huggingface.co/org/name/tree/revision
+//
+// The returned repo carries the revision when one is given. fileName is empty
+// for references to a whole repo.
+func ParseRepoURI(uri string) (repo *Repo, fileName string, err error) {
+	return parseRepoURI(uri, DefaultEndpoint)
+}
+
+func parseRepoURI(uri string, endpoint string) (*Repo, string, error) {
+	switch {
+	case strings.HasPrefix(uri, "hf://"):
+		return parseHFPath(strings.TrimPrefix(uri, "hf://"))
+	case strings.HasPrefix(uri, "https://"), strings.HasPrefix(uri, "http://"):
+		return parseHubURL(uri, endpoint)
+	}
+
+	repoType, id := splitRepoType(uri)
+	if strings.Count(id, "/") > 1 {
+		return nil, "", fmt.Errorf("invalid repo id %q", uri)
+	}
+	return &Repo{Id: id, Type: repoType}, "", nil
+}
+
+// isRepoURI reports whether id is a URI or a type-prefixed id rather than a
+// plain repo id.
+func isRepoURI(id string) bool {
+	if strings.Contains(id, "://") {
+		return true
+	}
+	prefix, _, found := strings.Cut(id, "/")
+	_, typed := repoTypePrefixes[prefix]
+	return found && typed && strings.Count(id, "/") == 2
+}
+
+func splitRepoType(path string) (string, string) {
+	prefix, rest, found := strings.Cut(path, "/")
+	if repoType, ok := repoTypePrefixes[prefix]; ok && found && strings.Contains(rest, "/") {
+		return repoType, rest
+	}
+	return ModelRepoType, path
+}
+
+// parseHFPath parses the part of an hf:// URI after the scheme. The revision
+// follows either the repo name or the file path.
+func parseHFPath(path string) (*Repo, string, error) {
+	repoType, rest := splitRepoType(strings.Trim(path, "/"))
+
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", fmt.Errorf("invalid hf:// URI %q: expected org/name", path)
+	}
+
+	repo := &Repo{Type: repoType}
+	name := parts[1]
+	fileName := ""
+	if len(parts) == 3 {
+		fileName = parts[2]
+	}
+
+	if n, revision, found := strings.Cut(name, "@"); found {
+		// hf://org/name@revision/path, where the revision may be refs/pr/N
+		name = n
+		if strings.HasPrefix(revision, "refs") && fileName != "" {
+			if segments := strings.SplitN(fileName, "/", 3); len(segments) >= 2 && segments[0] == "pr" {
+				revision = revision + "/pr/" + segments[1]
+				fileName = ""
+				if len(segments) == 3 {
+					fileName = segments[2]
+				}
+			}
+		}
+		repo.Revision = unescapePath(revision)
+	} else if i := strings.LastIndex(fileName, "@"); i >= 0 {
+		// hf://org/name/path@revision
+		repo.Revision = unescapePath(fileName[i+1:])
+		fileName = fileName[:i]
+	}
+
+	repo.Id = parts[0] + "/" + name
+	return repo, strings.Trim(fileName, "/"), nil
+}
+
+func unescapePath(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// parseHubURL parses the web and download URLs of the hub.
+func parseHubURL(rawURL string, endpoint string) (*Repo, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+
+	hosts := hubHosts
+	if e, err := url.Parse(endpoint); err == nil && e.Host != "" {
+		hosts = append([]string{e.Host}, hubHosts...)
+	}
+	known := false
+	for _, host := range hosts {
+		if u.Host == host {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, "", fmt.Errorf("URL %q does not point to %s", rawURL, endpoint)
+	}
+
+	// keep escaped slashes so revisions like refs%2Fpr%2F1 stay one segment
+	repoType, rest := splitRepoType(strings.Trim(u.EscapedPath(), "/"))
+
+	parts := strings.SplitN(rest, "/", 5)
+	if len(parts) < 2 {
+		return nil, "", fmt.Errorf("invalid hub URL %q: expected org/name", rawURL)
+	}
+
+	repo := &Repo{Id: parts[0] + "/" + parts[1], Type: repoType}
+	if len(parts) == 2 {
+		return repo, "", nil
+	}
+
+	switch parts[2] {
+	case "blob", "resolve", "tree":
+	default:
+		return nil, "", fmt.Errorf("invalid hub URL %q: unexpected %q", rawURL, parts[2])
+	}
+	if len(parts) < 4 {
+		return nil, "", fmt.Errorf("invalid hub URL %q: missing revision", rawURL)
+	}
+
+	revision, fileName := parts[3], ""
+	if len(parts) == 5 {
+		fileName = parts[4]
+	}
+	// refs/pr/N revisions may also arrive unescaped
+	if revision == "refs" {
+		if segments := strings.SplitN(fileName, "/", 3); len(segments) >= 2 && segments[0] == "pr" {
+			revision = revision + "/pr/" + segments[1]
+			fileName = ""
+			if len(segments) == 3 {
+				fileName = segments[2]
+			}
+		}
+	}
+	if parts[2] == "tree" {
+		fileName = ""
+	}
+
+	repo.Revision = unescapePath(revision)
+	return repo, unescapePath(fileName), nil
+}
@@ -0,0 +1,57 @@
+package hub
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// HfRepoURL is the repo's page on the hub, e.g.
+// https://huggingface.co/datasets/org/name.
+func (client *Client) HfRepoURL(repo *Repo) string {
+	return fmt.Sprintf("%s/%s", client.Endpoint, repoURLPath(repo))
+}
+
+// HfFileURL is the URL a file at revision is downloaded from. An empty
+// revision falls back to the repo's, then to main. Revisions such as
+// refs/pr/1 and file names are escaped.
+func (client *Client) HfFileURL(repo *Repo, fileName string, revision string) string {
+	return hubFileURL(client.Endpoint, repo, "resolve", urlRevision(repo, revision), fileName)
+}
+
+// HfBlobURL is the page showing a file at revision on the hub.
+func (client *Client) HfBlobURL(repo *Repo, fileName string, revision string) string {
+	return hubFileURL(client.Endpoint, repo, "blob", urlRevision(repo, revision), fileName)
+}
+
+// HfTreeURL is the page listing a folder at revision on the hub; an empty
+// folder is the repo root.
+func (client *Client) HfTreeURL(repo *Repo, folder string, revision string) string {
+	return strings.TrimSuffix(hubFileURL(client.Endpoint, repo, "tree", urlRevision(repo, revision), folder), "/")
+}
+
+func urlRevision(repo *Repo, revision string) string {
+	if revision == "" {
+		revision = repo.Revision
+	}
+	if revision == "" {
+		revision = DefaultRevision
+	}
+	return revision
+}
+
+// hubFileURL builds <endpoint>/<repo>/<kind>/<revision>/<path>, the layout
+// of the hub and its mirrors, where kind is "resolve", "raw", "blob" or
+// "tree".
+func hubFileURL(endpoint string, repo *Repo, kind string, revision string, fileName string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", endpoint, repoURLPath(repo), kind, url.PathEscape(revision), escapeFilePath(fileName))
+}
+
+// escapeFilePath escapes each segment of a repo path, keeping the slashes.
+func escapeFilePath(fileName string) string {
+	segments := strings.Split(fileName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
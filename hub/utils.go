@@ -3,12 +3,12 @@ package hub
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"strconv"
 	"io"
-	"encoding/json"
 )
 
 
@@ -19,27 +19,11 @@ type LFSPointer struct {
 
 
 func GetToken() string {
-	// check environment variable
-	token := os.Getenv("HF_TOKEN")
-	if token != "" {
-		return token
-	}
-
-	// check token file (similar to hf_hub python)
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-
-	tokenPath := filepath.Join(homeDir, ".cache", "huggingface", "token")
-	tokenBytes, err := os.ReadFile(tokenPath)
+	cfg, err := LoadConfig()
 	if err != nil {
 		return ""
 	}
-
-	// clean token i.e. remove any trailing newlines and whitespace
-	token = strings.TrimSpace(string(tokenBytes))
-	return token
+	return cfg.Token
 }
 
 func repoFolderName(repoID string, repoType string) string {
@@ -49,15 +33,12 @@ func repoFolderName(repoID string, repoType string) string {
 	return strings.Join(parts, "--")
 }
 
-func getFileMetadata(client *Client, repoId string, filename string, headers *http.Header) (*FileMetadata, error) {
-	url := fmt.Sprintf("%s/%s/resolve/%s/%s", 
-		client.Endpoint, 
-		repoId, 
-		DefaultRevision, 
-		filename,
-	)
+// getFileMetadata resolves a file at revision, a branch, tag or commit hash,
+// with a HEAD request.
+func getFileMetadata(client *Client, repo *Repo, revision string, filename string, headers *http.Header) (*FileMetadata, error) {
+	fileURL := hubFileURL(client.Endpoint, repo, "resolve", revision, filename)
 
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequest("HEAD", fileURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -66,29 +47,45 @@ func getFileMetadata(client *Client, repoId string, filename string, headers *ht
 		req.Header = *headers
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.metadataHTTPClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Metadata for regular files
-	etag := strings.Trim(resp.Header.Get("ETag"), "\"")
+	// a 304 to a revalidation carries the same headers as a 200
+	if resp.StatusCode >= 400 {
+		return nil, newHTTPError(resp)
+	}
+
+	// LFS files are linked to their sha256 in X-Linked-Etag; the ETag of the
+	// redirect or of the CDN differs and would key a second blob
+	etag := normalizeETag(resp.Header.Get("X-Linked-Etag"))
+	if etag == "" {
+		etag = normalizeETag(resp.Header.Get("ETag"))
+	}
 	commitHash := resp.Header.Get("X-Repo-Commit")
-	size, _ := strconv.Atoi(resp.Header.Get("Content-Length"))
+	size, _ := strconv.Atoi(resp.Header.Get("X-Linked-Size"))
+	if size == 0 {
+		size, _ = strconv.Atoi(resp.Header.Get("Content-Length"))
+	}
 
 	// Handle LFS pointer fallback
 	if etag == "" || commitHash == "" {
-		pointerData, err := fetchLFSPointer(client, client.Endpoint, repoId, filename)
+		pointerData, err := fetchLFSPointer(client, client.Endpoint, repo, revision, filename)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch LFS pointer: %w", err)
 		}
 		etag = pointerData.Sha256
 		size = pointerData.Size
 
-		commitHash, err = fetchCommitHash(client.Endpoint, repoId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch commit hash: %w", err)
+		// the commit of the requested revision, not of main, names the snapshot
+		commitHash = revision
+		if !isCommitHash(revision) {
+			commitHash, err = fetchRefCommit(client, repo, revision)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch commit hash: %w", err)
+			}
 		}
 	}
 
@@ -102,40 +99,24 @@ func getFileMetadata(client *Client, repoId string, filename string, headers *ht
 
 	// use request URL if no redirect location
 	if metadata.Location == "" {
-		metadata.Location = url
+		metadata.Location = fileURL
 	}
 
 	return metadata, nil
 }
 
 
-func fetchCommitHash(endpoint, repoId string) (string, error) {
-	url := fmt.Sprintf("%s/api/models/%s", endpoint, repoId)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch commit hash: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	var result struct {
-		CommitHash string `json:"sha"`
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode commit hash: %w", err)
-	}
-
-	return result.CommitHash, nil
+// normalizeETag strips the weak validator prefix and quotes, so `W/"abc"`,
+// `"abc"` and `abc` all name the same blob.
+func normalizeETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	etag = strings.TrimPrefix(etag, "W/")
+	return strings.Trim(etag, "\"")
 }
 
-func fetchLFSPointer(client *Client, endpoint, repoId, filename string) (*LFSPointer, error) {
-	rawURL := fmt.Sprintf("%s/%s/raw/%s/%s", endpoint, repoId, DefaultRevision, filename)
+
+func fetchLFSPointer(client *Client, endpoint string, repo *Repo, revision string, filename string) (*LFSPointer, error) {
+	rawURL := hubFileURL(endpoint, repo, "raw", revision, filename)
 	req, err := http.NewRequest("GET", rawURL, nil)
     if err != nil {
         return nil, fmt.Errorf("failed to create request: %w", err)
@@ -144,10 +125,10 @@ func fetchLFSPointer(client *Client, endpoint, repoId, filename string) (*LFSPoi
 	if client.Token != "" {
         req.Header.Set("Authorization", "Bearer " + client.Token)
     }
-    req.Header.Set("User-Agent", client.UserAgent)
+    req.Header.Set("User-Agent", client.userAgent())
 
 	// Make request with headers
-    resp, err := http.DefaultClient.Do(req)
+    resp, err := client.httpClient().Do(req)
     if err != nil {
         return nil, fmt.Errorf("failed to fetch LFS pointer: %w", err)
     }
@@ -155,7 +136,7 @@ func fetchLFSPointer(client *Client, endpoint, repoId, filename string) (*LFSPoi
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, newHTTPError(resp)
 	}
 
 	// parse LFS pointer
@@ -216,7 +197,10 @@ func expandPath(path string) (string, error) {
 }
 
 
-func createSymlink(srcPath, dstPath string) error {
+func createSymlink(client *Client, srcPath, dstPath string) error {
+	if client.realFiles() {
+		return placeSnapshotFile(client, srcPath, dstPath)
+	}
 
 	srcAbs, err := filepath.Abs(srcPath)
 	if err != nil {
@@ -235,25 +219,25 @@ func createSymlink(srcPath, dstPath string) error {
 	}
 
 	// remove existing destination if exists
-	if _, err := os.Lstat(dstAbs); err == nil {
-		os.Remove(dstAbs)
+	if _, err := client.fs().Lstat(dstAbs); err == nil {
+		client.fs().Remove(dstAbs)
 	}
 
 	// ensure parent directory exists
-	if err := os.MkdirAll(filepath.Dir(dstAbs), 0755); err != nil {
+	if err := client.mkdirAll(filepath.Dir(dstAbs)); err != nil {
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
 	// create symlink
-	if err := os.Symlink(relPath, dstAbs); err != nil {
+	if err := client.fs().Symlink(relPath, dstAbs); err != nil {
 		// if symlink creation fails, fall back to copying the file
-		srcFile, err := os.Open(srcAbs)
+		srcFile, err := client.fs().OpenFile(srcAbs, os.O_RDONLY, 0)
 		if err != nil {
 			return fmt.Errorf("failed to open source file: %w", err)
 		}
 		defer srcFile.Close()
 
-		dstFile, err := os.Create(dstAbs)
+		dstFile, err := client.openFile(dstAbs, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
 		if err != nil {
 			return fmt.Errorf("failed to create destination file: %w", err)
 		}
@@ -262,33 +246,74 @@ func createSymlink(srcPath, dstPath string) error {
 		if _, err := io.Copy(dstFile, srcFile); err != nil {
 			return fmt.Errorf("failed to copy source file to destination: %w", err)
 		}
+		return nil
 	}
 
-	return nil
+	// the link itself only needs the group
+	return client.applyPerms(dstAbs, 0, false)
 }
 
 
 
 
 func IsOfflineMode() bool {
-	return os.Getenv("HF_HUB_OFFLINE") == "1"
+	return envBool("HF_HUB_OFFLINE")
 }
 
-func checkConnectivity(localFilesOnly bool) error {
-	if IsOfflineMode() {
+func checkConnectivity(client *Client, localFilesOnly bool) error {
+	if client.Offline {
 		return fmt.Errorf("cannot download files as offline mode is enabled (HF_HUB_OFFLINE=1)")
 	}
 	if localFilesOnly {
 		return fmt.Errorf("cannot download files as local_files_only is set to true")
 	}
+	if client.AutoOffline {
+		return client.probeEndpoint()
+	}
 
 	return nil
 }
 
 
+// resolveHeaders are getHeaders for file requests. Accept-Encoding: identity
+// keeps the transport from asking for gzip, so Content-Length and resume
+// offsets count the bytes that end up in the blob.
+func resolveHeaders(client *Client) *http.Header {
+	headers := getHeaders(client)
+	headers.Set("Accept-Encoding", "identity")
+	return headers
+}
+
+// headersFor copies headers for a request to rawURL, leaving out the hub token
+// unless rawURL is on the Endpoint's host: an LFS file is fetched from the
+// CDN the hub redirected to, which must not see it.
+func headersFor(client *Client, rawURL string, headers *http.Header) http.Header {
+	if headers == nil {
+		return http.Header{}
+	}
+	h := headers.Clone()
+	if !sameHost(client.Endpoint, rawURL) {
+		h.Del("Authorization")
+	}
+	return h
+}
+
+// sameHost reports whether both URLs parse and name the same host and port.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
+}
+
 func getHeaders(client *Client) *http.Header {
 	headers := &http.Header{}
-	headers.Set("User-Agent", client.UserAgent)
+	headers.Set("User-Agent", client.userAgent())
 	if client.Token != "" {
 		headers.Set("Authorization", "Bearer "+client.Token)
 	}
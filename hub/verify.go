@@ -0,0 +1,245 @@
+package hub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+type VerifyMode int
+
+const (
+	// VerifyOff skips signature checks entirely.
+	VerifyOff VerifyMode = iota
+	// VerifyPermissive rejects invalid signatures but accepts unsigned files.
+	VerifyPermissive
+	// VerifyStrict rejects files without a valid signature.
+	VerifyStrict
+)
+
+var ErrUnsigned = errors.New("no signature found")
+
+// ErrVerificationFailed marks a downloaded file the verification policy
+// rejected; downloading it again won't change the outcome.
+var ErrVerificationFailed = errors.New("verification failed")
+
+// SignatureVerifier checks a detached signature published next to a model
+// file, e.g. "model.safetensors.sig".
+type SignatureVerifier interface {
+	// Suffixes lists the signature file extensions this verifier understands.
+	Suffixes() []string
+	// Verify reads the downloaded file from blob, which is opened on the
+	// client's FS.
+	Verify(blob io.Reader, signature []byte) error
+}
+
+type VerificationPolicy struct {
+	Mode      VerifyMode
+	Verifiers []SignatureVerifier
+	// Patterns limits verification to matching files; empty means every file.
+	Patterns []string
+	// Check is an optional user policy run before detached signatures are looked up.
+	// Returning ErrUnsigned defers to the verifiers, any other error rejects the file.
+	Check func(repo *Repo, fileName string, blobPath string) error
+}
+
+// verifyArtifact runs the client's verification policy against a downloaded
+// but not yet finalized blob.
+func verifyArtifact(client *Client, repo *Repo, revision string, fileName string, blobPath string) error {
+	policy := client.Verification
+	if policy == nil || policy.Mode == VerifyOff {
+		return nil
+	}
+	if len(policy.Patterns) > 0 && !matchesAnyPattern(fileName, policy.Patterns) {
+		return nil
+	}
+
+	if policy.Check != nil {
+		err := policy.Check(repo, fileName, blobPath)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrUnsigned) {
+			return fmt.Errorf("%w: policy rejected %s: %w", ErrVerificationFailed, fileName, err)
+		}
+	}
+
+	for _, verifier := range policy.Verifiers {
+		for _, suffix := range verifier.Suffixes() {
+			signature, err := fetchSignature(client, repo, revision, fileName+suffix)
+			if err != nil {
+				return fmt.Errorf("failed to fetch signature for %s: %w", fileName, err)
+			}
+			if signature == nil {
+				continue
+			}
+
+			if err := verifyBlob(client, verifier, blobPath, signature); err != nil {
+				return fmt.Errorf("%w: invalid signature for %s: %w", ErrVerificationFailed, fileName, err)
+			}
+			return nil
+		}
+	}
+
+	if policy.Mode == VerifyStrict {
+		return fmt.Errorf("%w: %s: %w", ErrVerificationFailed, fileName, ErrUnsigned)
+	}
+
+	log.Printf("[Download] No signature found for %s, accepting unsigned file", fileName)
+	return nil
+}
+
+func verifyBlob(client *Client, verifier SignatureVerifier, blobPath string, signature []byte) error {
+	f, err := client.fs().OpenFile(blobPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifier.Verify(f, signature)
+}
+
+// fetchSignature downloads a detached signature file. It returns nil when the
+// repo doesn't publish one.
+func fetchSignature(client *Client, repo *Repo, revision string, fileName string) ([]byte, error) {
+	signatureURL := hubFileURL(client.Endpoint, repo, "resolve", revision, fileName)
+
+	req, err := http.NewRequest("GET", signatureURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = *getHeaders(client)
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp)
+	}
+
+	// signatures are small, anything bigger is not one
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// KeyVerifier checks cosign-style ".sig" files: a base64 ECDSA or RSA
+// signature over the SHA256 of the file, made with a known key pair.
+type KeyVerifier struct {
+	publicKey crypto.PublicKey
+}
+
+func NewKeyVerifier(publicKeyPEM []byte) (*KeyVerifier, error) {
+	key, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyVerifier{publicKey: key}, nil
+}
+
+func parsePublicKey(publicKeyPEM []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	return key, nil
+}
+
+func (v *KeyVerifier) Suffixes() []string {
+	return []string{".sig"}
+}
+
+func (v *KeyVerifier) Verify(blob io.Reader, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		// not base64, assume a raw signature
+		sig = signature
+	}
+
+	digest, err := readerSHA256(blob)
+	if err != nil {
+		return err
+	}
+	return verifyDigest(v.publicKey, digest, sig)
+}
+
+func readerSHA256(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// verifyDigest checks an ECDSA or RSA signature over a SHA256 digest.
+func verifyDigest(publicKey crypto.PublicKey, digest []byte, sig []byte) error {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return fmt.Errorf("ecdsa signature mismatch")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("rsa signature mismatch: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	return nil
+}
+
+// PGPVerifier checks armored detached GPG signatures (".asc") against a keyring.
+type PGPVerifier struct {
+	keyring openpgp.EntityList
+}
+
+func NewPGPVerifier(armoredKeyring []byte) (*PGPVerifier, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKeyring))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	return &PGPVerifier{keyring: keyring}, nil
+}
+
+func (v *PGPVerifier) Suffixes() []string {
+	return []string{".asc"}
+}
+
+func (v *PGPVerifier) Verify(blob io.Reader, signature []byte) error {
+	if _, err := openpgp.CheckArmoredDetachedSignature(v.keyring, blob, bytes.NewReader(signature), nil); err != nil {
+		return err
+	}
+
+	return nil
+}
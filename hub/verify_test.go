@@ -0,0 +1,231 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyArtifactStrictUnsigned(t *testing.T) {
+	client := &Client{Verification: &VerificationPolicy{Mode: VerifyStrict}}
+
+	err := verifyArtifact(client, &Repo{Id: "org/model"}, "main", "model.safetensors", "unused")
+	if !errors.Is(err, ErrVerificationFailed) || !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("got %v, want ErrVerificationFailed and ErrUnsigned", err)
+	}
+}
+
+func TestVerifyArtifactPolicyRejects(t *testing.T) {
+	client := &Client{Verification: &VerificationPolicy{
+		Mode: VerifyPermissive,
+		Check: func(repo *Repo, fileName string, blobPath string) error {
+			return errors.New("not on the allowlist")
+		},
+	}}
+
+	err := verifyArtifact(client, &Repo{Id: "org/model"}, "main", "model.safetensors", "unused")
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("got %v, want ErrVerificationFailed", err)
+	}
+}
+
+func TestBundleVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewBundleVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("weights")
+	digest := sha256.Sum256(content)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := func(digest []byte) []byte {
+		var b sigstoreBundle
+		b.MediaType = "application/vnd.dev.sigstore.bundle.v0.3+json"
+		b.MessageSignature = &struct {
+			MessageDigest struct {
+				Algorithm string `json:"algorithm"`
+				Digest    []byte `json:"digest"`
+			} `json:"messageDigest"`
+			Signature []byte `json:"signature"`
+		}{Signature: sig}
+		b.MessageSignature.MessageDigest.Algorithm = "SHA2_256"
+		b.MessageSignature.MessageDigest.Digest = digest
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if err := verifier.Verify(bytes.NewReader(content), bundle(digest[:])); err != nil {
+		t.Fatalf("valid bundle rejected: %v", err)
+	}
+
+	other := sha256.Sum256([]byte("other weights"))
+	if err := verifier.Verify(bytes.NewReader(content), bundle(other[:])); err == nil {
+		t.Fatal("bundle for another file accepted")
+	}
+
+	if err := verifier.Verify(strings.NewReader("tampered"), bundle(digest[:])); err == nil {
+		t.Fatal("tampered file accepted")
+	}
+}
+
+func TestKeylessBundleVerifier(t *testing.T) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	rootKey, leafKey, rekorKey := newKey(), newKey(), newKey()
+	now := time.Now()
+
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fulcio certificates are valid for minutes around the signing
+	leaf := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{"signer@example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuer, Value: []byte("https://issuer.example.com")}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekorDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootsPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})
+	rekorPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rekorDER})
+	logID := sha256.Sum256(rekorDER)
+
+	content := []byte("weights")
+	digest := sha256.Sum256(content)
+	sig, err := ecdsa.SignASN1(rand.Reader, leafKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bundle logs the signature at integratedTime; the signed entry timestamp
+	// vouches for signedTime
+	bundle := func(integratedTime, signedTime int64) []byte {
+		body, err := json.Marshal(map[string]any{
+			"apiVersion": "0.0.1",
+			"kind":       "hashedrekord",
+			"spec": map[string]any{
+				"data":      map[string]any{"hash": map[string]any{"algorithm": "sha256", "value": hex.EncodeToString(digest[:])}},
+				"signature": map[string]any{"content": sig, "publicKey": map[string]any{"content": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := fmt.Sprintf(`{"body":%q,"integratedTime":%d,"logID":%q,"logIndex":7}`,
+			base64.StdEncoding.EncodeToString(body), signedTime, hex.EncodeToString(logID[:]))
+		payloadDigest := sha256.Sum256([]byte(payload))
+		set, err := ecdsa.SignASN1(rand.Reader, rekorKey, payloadDigest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+			"verificationMaterial": map[string]any{
+				"certificate": map[string]any{"rawBytes": leafDER},
+				"tlogEntries": []map[string]any{{
+					"logIndex":          "7",
+					"logId":             map[string]any{"keyId": logID[:]},
+					"integratedTime":    strconv.FormatInt(integratedTime, 10),
+					"inclusionPromise":  map[string]any{"signedEntryTimestamp": set},
+					"canonicalizedBody": body,
+				}},
+			},
+			"messageSignature": map[string]any{
+				"messageDigest": map[string]any{"algorithm": "SHA2_256", "digest": digest[:]},
+				"signature":     sig,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if _, err := NewKeylessBundleVerifier(rootsPEM, rekorPEM, "", ""); err == nil {
+		t.Fatal("verifier without an identity or issuer created")
+	}
+
+	verifier, err := NewKeylessBundleVerifier(rootsPEM, rekorPEM, "signer@example.com", "https://issuer.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(bytes.NewReader(content), bundle(now.Unix(), now.Unix())); err != nil {
+		t.Fatalf("valid bundle rejected: %v", err)
+	}
+
+	// a log time the timestamp doesn't vouch for
+	if err := verifier.Verify(bytes.NewReader(content), bundle(now.Unix()-60, now.Unix())); err == nil {
+		t.Fatal("bundle with an unsigned log time accepted")
+	}
+
+	anyone, err := NewKeylessBundleVerifier(rootsPEM, rekorPEM, AnyIdentity, AnyIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := anyone.Verify(bytes.NewReader(content), bundle(now.Unix(), now.Unix())); err != nil {
+		t.Fatalf("valid bundle rejected with AnyIdentity: %v", err)
+	}
+
+	other, err := NewKeylessBundleVerifier(rootsPEM, rekorPEM, "someone@example.com", "https://issuer.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Verify(bytes.NewReader(content), bundle(now.Unix(), now.Unix())); err == nil {
+		t.Fatal("bundle for another identity accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WatchEvent reports a ref that moved and the snapshot it was synced to. Err
+// is set when polling or the sync failed; the watch keeps polling.
+type WatchEvent struct {
+	Repo         *Repo
+	Ref          string
+	OldCommit    string
+	NewCommit    string
+	SnapshotPath string
+	Err          error
+}
+
+// WatchFunc is called from the watch goroutine, one event at a time.
+type WatchFunc func(event WatchEvent)
+
+// Watch polls ref every interval and downloads the new snapshot whenever it
+// advances, then calls callback, e.g. to hot-reload a model. Only changed
+// files are fetched since blobs are shared between snapshots. A ref whose
+// cached commit is missing or stale is synced right away. The returned
+// function stops the watch.
+func (client *Client) Watch(repo *Repo, ref string, interval time.Duration, callback WatchFunc) (stop func()) {
+	return client.WatchParams(&DownloadParams{Repo: repo, Revision: ref}, interval, callback)
+}
+
+// WatchParams is Watch for a filtered snapshot; params.Revision is the ref
+// to follow.
+func (client *Client) WatchParams(params *DownloadParams, interval time.Duration, callback WatchFunc) (stop func()) {
+	ref := params.Revision
+	if ref == "" {
+		ref = params.Repo.Revision
+	}
+	if ref == "" {
+		ref = DefaultRevision
+	}
+
+	watched := *params
+	watched.Repo = params.Repo.WithRevision(ref)
+	if watched.Repo.Type == "" {
+		watched.Repo.Type = ModelRepoType
+	}
+	params = &watched
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(done) }) }
+
+	go func() {
+		current := cachedRefCommit(client, params.Repo, ref)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			current = client.pollRef(params, ref, current, callback)
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return stop
+}
+
+// pollRef syncs the snapshot if ref moved away from current and returns the
+// commit the watch is at afterwards.
+func (client *Client) pollRef(params *DownloadParams, ref string, current string, callback WatchFunc) string {
+	latest, err := fetchRefCommit(client, params.Repo, ref)
+	if err != nil {
+		callback(WatchEvent{Repo: params.Repo, Ref: ref, OldCommit: current, Err: err})
+		return current
+	}
+	if latest == current {
+		return current
+	}
+
+	log.Printf("[Download] %s@%s moved from %s to %s", params.Repo.Id, ref, shortCommit(current), shortCommit(latest))
+
+	syncParams := *params
+	syncParams.Revision = latest
+	syncParams.Repo = params.Repo.WithRevision(latest)
+	syncParams.ctx = nil
+	path, err := client.Download(&syncParams)
+	if err == nil {
+		// the sync downloaded by commit, point the ref at it like a download by ref would
+		storageFolder := filepath.Join(client.CacheDir, repoFolderName(params.Repo.Id, params.Repo.Type))
+		if err = client.writeRef(storageFolder, ref, latest); err == nil {
+			client.indexRef(params.Repo, ref, latest)
+		}
+	}
+
+	event := WatchEvent{Repo: params.Repo, Ref: ref, OldCommit: current, NewCommit: latest, SnapshotPath: path, Err: err}
+	callback(event)
+	if err != nil {
+		// retried on the next tick
+		return current
+	}
+	return latest
+}
+
+// fetchRefCommit asks the API which commit ref points at now.
+func fetchRefCommit(client *Client, repo *Repo, ref string) (string, error) {
+	target := fmt.Sprintf("%s/api/%s/%s/revision/%s",
+		client.Endpoint, repoTypeAPIPath(repo.Type), repo.Id, url.PathEscape(ref))
+
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = *getHeaders(client)
+
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newHTTPError(resp)
+	}
+
+	var result struct {
+		Sha string `json:"sha"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode revision info: %w", err)
+	}
+	if result.Sha == "" {
+		return "", fmt.Errorf("invalid API response: missing commit hash")
+	}
+	return result.Sha, nil
+}
+
+// cachedRefCommit returns the commit the cache's ref points at, if its
+// snapshot exists.
+func cachedRefCommit(client *Client, repo *Repo, ref string) string {
+	storageFolder := filepath.Join(client.CacheDir, repoFolderName(repo.Id, repo.Type))
+	commit, err := readRef(client.fs(), storageFolder, ref)
+	if err != nil {
+		return ""
+	}
+	if _, err := client.fs().Stat(filepath.Join(storageFolder, "snapshots", commit)); err != nil {
+		return ""
+	}
+	return commit
+}
+
+func shortCommit(commit string) string {
+	if commit == "" {
+		return "(none)"
+	}
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
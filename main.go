@@ -2,14 +2,19 @@ package main
 
 import (
     "fmt"
+    "io"
     "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
     "time"
 	// "io"
 	// "net/http"
 	// "strings"
 
     "github.com/go-vault/model-cache/hub"
-    "github.com/go-vault/model-cache/pipeline"
+    "github.com/go-vault/model-cache/hub/pipeline"
     "github.com/vbauerster/mpb/v7"
 )
 
@@ -71,7 +76,33 @@ import (
 
 // Diffusion pipeline download
 
+// exit codes, so scripts can tell why a command failed
+const (
+    exitFailure  = 1
+    exitUsage    = 2
+    exitAuth     = 3
+    exitNotFound = 4
+    exitNetwork  = 5
+    exitDisk     = 6
+)
+
+// subcommands offered by the shell completion, besides repo ids to download
+var subcommands = []string{"reindex", "dedup", "completion"}
+
 func main() {
+    // Shell completion, before any progress output
+    if len(os.Args) > 1 && os.Args[1] == "completion" {
+        if len(os.Args) != 3 || !printCompletion(os.Args[2]) {
+            fmt.Fprintln(os.Stderr, "usage: completion bash|zsh|fish")
+            os.Exit(exitUsage)
+        }
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "__complete" {
+        complete(os.Args[2:])
+        return
+    }
+
     // Create default client
     client := hub.DefaultClient()
     
@@ -82,18 +113,156 @@ func main() {
     )
     client.Progress = progress
 
+    // Rebuild the cache index from what is on disk
+    if len(os.Args) > 1 && os.Args[1] == "reindex" {
+        idx, err := hub.RebuildCacheIndex(client.CacheDir)
+        if err != nil {
+            fail(err, "Failed to rebuild cache index")
+        }
+        stats := idx.Stats()
+        fmt.Printf("Indexed %d repos, %d revisions, %d files (%d bytes)\n", stats.Repos, stats.Revisions, stats.Files, stats.Size)
+        return
+    }
+
+    // Report the blobs cached under several repos and what sharing them frees
+    if len(os.Args) > 1 && os.Args[1] == "dedup" {
+        report, err := hub.FindDuplicateBlobs(client.CacheDir)
+        if err != nil {
+            fail(err, "Failed to scan the cache")
+        }
+        for _, duplicate := range report.Duplicates {
+            fmt.Printf("%s  %d bytes x %d copies\n", duplicate.Hash, duplicate.Size, duplicate.Copies)
+            for _, holder := range duplicate.Repos {
+                fmt.Printf("    %s  %s\n", holder.RepoId, strings.Join(holder.Files, ", "))
+            }
+        }
+        fmt.Printf("%d duplicate blobs, %d of %d bytes reclaimable with a shared blob store\n", len(report.Duplicates), report.Reclaimable, report.TotalSize)
+        return
+    }
+
+    // Download a repo or file given as an id, hf:// URI or hub URL
+    if len(os.Args) > 1 {
+        path, err := client.Download(&hub.DownloadParams{Repo: hub.NewRepo(os.Args[1])})
+        if err != nil {
+            fail(err, "Failed to download %s", os.Args[1])
+        }
+        progress.Wait()
+        fmt.Printf("Downloaded to: %s\n", path)
+        return
+    }
+
     downloader := pipeline.NewDiffusionPipelineDownloader(client)
     
     // Download a diffusion model, ignore text_encoder
     fmt.Println("Starting download...")
-    modelPath, err := downloader.Download("fal/AuraFlow-v0.3", "", nil, nil)
+    modelPath, summary, err := downloader.DownloadWithSummary("fal/AuraFlow-v0.3", "", nil, nil)
     if err != nil {
-        log.Fatalf("Failed to download model: %v", err)
+        fail(err, "Failed to download model")
     }
 
     // Wait for progress bars to finish
     progress.Wait()
 
     fmt.Printf("Model downloaded to: %s\n", modelPath)
+    fmt.Printf("Summary: %s\n", summary)
 }
 
+
+// fail logs err and exits with the code for its kind.
+func fail(err error, format string, args ...any) {
+    log.Printf("%s: %v", fmt.Sprintf(format, args...), err)
+
+    switch hub.ErrorKindOf(err) {
+    case hub.ErrorKindAuth:
+        os.Exit(exitAuth)
+    case hub.ErrorKindNotFound:
+        os.Exit(exitNotFound)
+    case hub.ErrorKindNetwork:
+        os.Exit(exitNetwork)
+    case hub.ErrorKindDisk:
+        os.Exit(exitDisk)
+    }
+    os.Exit(exitFailure)
+}
+
+// printCompletion writes the completion script for shell, which asks the
+// program itself for candidates through __complete.
+func printCompletion(shell string) bool {
+    prog := filepath.Base(os.Args[0])
+    fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(prog)
+    // candidates come from this binary, wherever the shell finds prog
+    exe, err := os.Executable()
+    if err != nil {
+        exe = prog
+    }
+
+    switch shell {
+    case "bash":
+        fmt.Printf(`%[2]s() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    COMPREPLY=($(compgen -W "$('%[3]s' __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}")" -- "$cur"))
+}
+complete -F %[2]s %[1]s
+`, prog, fn, exe)
+    case "zsh":
+        fmt.Printf(`#compdef %[1]s
+%[2]s() {
+    local -a candidates
+    candidates=(${(f)"$('%[3]s' __complete ${words[2,CURRENT-1]})"})
+    compadd -a candidates
+}
+compdef %[2]s %[1]s
+`, prog, fn, exe)
+    case "fish":
+        fmt.Printf("complete -c %[1]s -f -a \"('%[2]s' __complete (commandline -opc)[2..-1])\"\n", prog, exe)
+    default:
+        return false
+    }
+    return true
+}
+
+// complete prints the candidates for the word after args, one per line:
+// subcommands and the repos in the cache index for the first word.
+func complete(args []string) {
+    switch {
+    case len(args) == 0:
+        for _, name := range subcommands {
+            fmt.Println(name)
+        }
+        for _, id := range cachedRepoIds() {
+            fmt.Println(id)
+        }
+    case len(args) == 1 && args[0] == "completion":
+        fmt.Println("bash\nzsh\nfish")
+    }
+}
+
+// cachedRepoIds lists the indexed repos as the download argument takes them,
+// with a datasets/ or spaces/ prefix for those types. Caches without an
+// index offer none, since scanning a large cache would stall the shell.
+func cachedRepoIds() []string {
+    cfg, err := hub.LoadConfig()
+    if err != nil {
+        return nil
+    }
+    // the index logs failed compactions, which would end up in the shell
+    log.SetOutput(io.Discard)
+    idx, err := hub.LoadCacheIndex(cfg.CacheDir)
+    if err != nil {
+        return nil
+    }
+
+    var ids []string
+    for _, repo := range idx.Repos {
+        switch repo.Type {
+        case hub.DatasetRepoType:
+            ids = append(ids, "datasets/"+repo.Id)
+        case hub.SpaceRepoType:
+            ids = append(ids, "spaces/"+repo.Id)
+        default:
+            ids = append(ids, repo.Id)
+        }
+    }
+    sort.Strings(ids)
+    return ids
+}